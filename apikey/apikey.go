@@ -0,0 +1,166 @@
+// Package apikey scoped API keys for server-to-server wallet access. A key
+// looks like gwk_<prefix>_<secret>: the prefix identifies it in listings and
+// logs, only the sha256 of the whole key is stored.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// KeyPrefix starts every API key, telling keys apart from access tokens
+const KeyPrefix = "gwk_"
+
+// MaxKeys active keys a user may have at once
+const MaxKeys = 20
+
+// maxNameLength longest key name, in characters
+const maxNameLength = 100
+
+// lastUsedResolution a key's last use is only written once it is older than
+// this, so busy keys do not cost a write per request
+const lastUsedResolution = time.Minute
+
+// ErrInvalidName key name is empty or longer than 100 characters
+var ErrInvalidName = &models.Error{Code: models.CodeInvalidInput, Message: fmt.Sprintf("Name must be 1 to %d characters", maxNameLength)}
+
+// Service creates, authenticates and revokes API keys
+type Service struct {
+	Store store.APIKeyStore
+
+	now func() time.Time
+}
+
+// NewService new API key service
+func NewService(s store.APIKeyStore) *Service {
+	return &Service{Store: s, now: time.Now}
+}
+
+// IsKey reports whether credential looks like an API key rather than an
+// access token
+func IsKey(credential string) bool {
+	return strings.HasPrefix(credential, KeyPrefix)
+}
+
+// Create issues a key for the user and returns it, shown only this once, with
+// its stored record. Scopes are deduplicated and sorted.
+func (s *Service) Create(ctx context.Context, userID int, name string, scopes []string) (string, *models.APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return "", nil, ErrInvalidName
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	existing, err := s.Store.APIKeys(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	active := 0
+	for _, k := range existing {
+		if k.RevokedAt == nil {
+			active++
+		}
+	}
+	if active >= MaxKeys {
+		return "", nil, models.ErrTooManyAPIKeys
+	}
+
+	prefix, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := KeyPrefix + prefix + "_" + secret
+	k := &models.APIKey{UserID: userID, Name: name, Prefix: prefix, KeyHash: hashKey(raw), Scopes: scopes}
+	if err := s.Store.CreateAPIKey(ctx, k); err != nil {
+		return "", nil, err
+	}
+	return raw, k, nil
+}
+
+// Authenticate returns the active key raw belongs to and records its use,
+// models.ErrInvalidAPIKey if it is malformed, unknown or revoked
+func (s *Service) Authenticate(ctx context.Context, raw string) (*models.APIKey, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(raw, KeyPrefix), "_")
+	if !IsKey(raw) || !ok || prefix == "" {
+		return nil, models.ErrInvalidAPIKey
+	}
+	k, err := s.Store.APIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hashKey(raw))) != 1 || k.RevokedAt != nil {
+		return nil, models.ErrInvalidAPIKey
+	}
+
+	now := s.now()
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= lastUsedResolution {
+		if err := s.Store.TouchAPIKey(ctx, k.ID, now); err != nil {
+			return nil, err
+		}
+		k.LastUsedAt = &now
+	}
+	return k, nil
+}
+
+// List returns the user's keys including revoked ones, newest first
+func (s *Service) List(ctx context.Context, userID int) ([]models.APIKey, error) {
+	return s.Store.APIKeys(ctx, userID)
+}
+
+// Revoke revokes the user's key, requests with it fail from then on
+func (s *Service) Revoke(ctx context.Context, userID, id int) error {
+	return s.Store.RevokeAPIKey(ctx, userID, id)
+}
+
+// normalizeScopes validates, deduplicates and sorts scopes
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, models.ErrInvalidScope
+	}
+	seen := make(map[string]bool, len(scopes))
+	out := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			return nil, models.ErrInvalidScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			out = append(out, scope)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// randomString n random bytes in the given encoding
+func randomString(n int, encode func([]byte) string) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("apikey: %w", err)
+	}
+	return encode(raw), nil
+}
+
+// hashKey sha256 of the whole key, keys are random enough not to need a slow hash
+func hashKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
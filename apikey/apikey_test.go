@@ -0,0 +1,124 @@
+package apikey
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// newTestService service on a memory store with a controllable clock
+func newTestService() (*Service, *time.Time) {
+	s := NewService(store.NewMemory())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestService_Create(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		keyName string
+		scopes  []string
+		wantErr error
+	}{
+		{name: "empty name", keyName: "  ", scopes: []string{models.ScopeWalletRead}, wantErr: ErrInvalidName},
+		{name: "long name", keyName: strings.Repeat("a", 101), scopes: []string{models.ScopeWalletRead}, wantErr: ErrInvalidName},
+		{name: "no scopes", keyName: "shop", wantErr: models.ErrInvalidScope},
+		{name: "unknown scope", keyName: "shop", scopes: []string{"wallet:*"}, wantErr: models.ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestService()
+			_, _, err := s.Create(ctx, 1, tt.keyName, tt.scopes)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		s, _ := newTestService()
+		raw, k, err := s.Create(ctx, 1, " shop ", []string{models.ScopeWalletTransfer, models.ScopeWalletRead, models.ScopeWalletRead})
+		require.NoError(t, err)
+		assert.True(t, IsKey(raw))
+		assert.True(t, strings.HasPrefix(raw, KeyPrefix+k.Prefix+"_"))
+		assert.Equal(t, "shop", k.Name)
+		assert.Equal(t, []string{models.ScopeWalletRead, models.ScopeWalletTransfer}, k.Scopes)
+		assert.Equal(t, hashKey(raw), k.KeyHash)
+		assert.NotContains(t, k.KeyHash, raw)
+	})
+
+	t.Run("too many active keys", func(t *testing.T) {
+		s, _ := newTestService()
+		var last *models.APIKey
+		for i := 0; i < MaxKeys; i++ {
+			_, k, err := s.Create(ctx, 1, "shop", []string{models.ScopeWalletRead})
+			require.NoError(t, err)
+			last = k
+		}
+		_, _, err := s.Create(ctx, 1, "shop", []string{models.ScopeWalletRead})
+		assert.ErrorIs(t, err, models.ErrTooManyAPIKeys)
+		_, _, err = s.Create(ctx, 2, "shop", []string{models.ScopeWalletRead})
+		assert.NoError(t, err)
+
+		require.NoError(t, s.Revoke(ctx, 1, last.ID))
+		_, _, err = s.Create(ctx, 1, "shop", []string{models.ScopeWalletRead})
+		assert.NoError(t, err)
+	})
+}
+
+func TestService_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	s, now := newTestService()
+	raw, created, err := s.Create(ctx, 1, "shop", []string{models.ScopeWalletRead})
+	require.NoError(t, err)
+
+	k, err := s.Authenticate(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, k.ID)
+	assert.Equal(t, 1, k.UserID)
+	require.NotNil(t, k.LastUsedAt)
+	firstUse := *now
+
+	// last use is only written once a minute
+	*now = now.Add(30 * time.Second)
+	k, err = s.Authenticate(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, firstUse, *k.LastUsedAt)
+	*now = now.Add(time.Minute)
+	k, err = s.Authenticate(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, *now, *k.LastUsedAt)
+
+	tampered := raw[:len(raw)-1] + "A"
+	if tampered == raw {
+		tampered = raw[:len(raw)-1] + "B"
+	}
+	for _, bad := range []string{
+		"",
+		"gwk_",
+		"gwk_" + created.Prefix,
+		"gwk_unknown_secret",
+		tampered,
+		strings.TrimPrefix(raw, KeyPrefix),
+	} {
+		_, err := s.Authenticate(ctx, bad)
+		assert.ErrorIs(t, err, models.ErrInvalidAPIKey, bad)
+	}
+
+	require.NoError(t, s.Revoke(ctx, 1, created.ID))
+	_, err = s.Authenticate(ctx, raw)
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+	assert.ErrorIs(t, s.Revoke(ctx, 2, created.ID), models.ErrAPIKeyNotFound)
+}
@@ -0,0 +1,422 @@
+// Package config typed service configuration, loaded once at startup from the
+// environment, .env and an optional CONFIG_FILE and passed to the components
+// that need it
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gin-wallet2/models"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+)
+
+// minSecretLength HS256 keys shorter than the hash output weaken the signature
+const minSecretLength = 32
+
+// Config service configuration
+type Config struct {
+	Port           string
+	GinMode        string
+	LogLevel       zerolog.Level
+	LogFormat      string
+	IdempotencyTTL time.Duration
+	// HealthTimeout limit for each /readyz dependency check
+	HealthTimeout time.Duration
+	HTTP          HTTP
+	DB            DB
+	JWT           JWT
+	Tracing       Tracing
+	RateLimit     RateLimit
+	Lockout       Lockout
+	Password      Password
+	MFA           MFA
+	// TrustedProxies addresses or CIDRs whose X-Forwarded-For is believed for
+	// the client IP, empty means the connection's remote address is used
+	TrustedProxies []string
+}
+
+// HTTP server timeouts. ShutdownTimeout is how long in-flight requests get to
+// finish after SIGTERM before their contexts are cancelled.
+type HTTP struct {
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// DB database connection settings
+type DB struct {
+	Host        string
+	Port        string
+	User        string
+	Password    string
+	Name        string
+	SSLMode     string
+	AutoMigrate bool
+	// ConnectTimeout how long startup keeps retrying an unreachable database
+	ConnectTimeout time.Duration
+}
+
+// JWT token settings. Tokens are signed with the private key in
+// SigningKeyFile, RS256 or EdDSA, and also verified with the keys in
+// VerificationKeyFiles while a rotation is under way. Without a signing key
+// they are signed with the HS256 Secret.
+type JWT struct {
+	Secret               []byte
+	SigningKeyFile       string
+	VerificationKeyFiles []string
+	Issuer               string
+	Audience             string
+	AccessTTL            time.Duration
+	RefreshTTL           time.Duration
+}
+
+// Tracing OpenTelemetry settings. Exporter is none, stdout or otlp; the OTLP
+// endpoint and headers come from the standard OTEL_EXPORTER_OTLP_* variables.
+type Tracing struct {
+	Exporter    string
+	ServiceName string
+	SampleRatio float64
+}
+
+// RateLimit token bucket limits, Auth per client IP on /register and /login,
+// Wallet per user on /wallet routes
+type RateLimit struct {
+	Auth   Limit
+	Wallet Limit
+}
+
+// Limit Burst requests at once, refilled at Burst per Period. A zero Burst
+// disables the limit.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Lockout failed login policy. An account is locked after MaxFailures
+// consecutive failures and a client IP after IPMaxFailures, first for
+// Duration, doubling with each further failure up to MaxDuration. Failures are
+// forgotten once the last one is older than Window. A zero count disables the
+// lock.
+type Lockout struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Duration      time.Duration
+	MaxDuration   time.Duration
+	Window        time.Duration
+}
+
+// Password policy for new passwords and how they are hashed. Hash is bcrypt or
+// argon2id; logins rehash stored passwords made with other settings.
+type Password struct {
+	MinLength         int
+	Hash              string
+	BcryptCost        int
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+}
+
+// MFA two-factor authentication. EncryptionKey, if set, encrypts the TOTP
+// secrets in the database. Withdrawals and transfers above StepUpAmount need a
+// two-factor code, zero turns that off.
+type MFA struct {
+	Issuer        string
+	EncryptionKey []byte
+	StepUpAmount  models.Money
+}
+
+// Addr listen address for the HTTP server
+func (c *Config) Addr() string {
+	return ":" + c.Port
+}
+
+// DSN lib/pq connection string
+func (d DB) DSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		d.Host, d.Port, d.User, d.Password, d.Name, d.SSLMode)
+}
+
+// Load reads .env and then CONFIG_FILE, both in .env format, into the
+// environment without overriding variables that are already set, and parses
+// the result. Real environment variables win over .env, which wins over CONFIG_FILE.
+func Load() (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		if err := godotenv.Load(file); err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE: %w", err)
+		}
+	}
+	return Parse(os.LookupEnv)
+}
+
+// Parse builds the config from lookup and validates it, reporting every
+// invalid or missing setting at once
+func Parse(lookup func(string) (string, bool)) (*Config, error) {
+	p := &parser{lookup: lookup}
+
+	signingKeyFile := p.str("JWT_SIGNING_KEY_FILE", "")
+	jwtSecret := p.optionalSecret("JWT_SECRET")
+	if signingKeyFile == "" && jwtSecret == "" {
+		p.fail("JWT_SECRET", "required unless JWT_SIGNING_KEY_FILE is set")
+	}
+
+	cfg := &Config{
+		Port:           p.port("PORT", "8080"),
+		GinMode:        p.oneOf("GIN_MODE", "release", "debug", "release", "test"),
+		LogLevel:       p.logLevel("LOG_LEVEL", zerolog.InfoLevel),
+		LogFormat:      p.oneOf("LOG_FORMAT", "json", "json", "console"),
+		IdempotencyTTL: p.duration("IDEMPOTENCY_TTL", 24*time.Hour),
+		HealthTimeout:  p.duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		HTTP: HTTP{
+			ReadTimeout:     p.duration("HTTP_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:    p.duration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:     p.duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+			ShutdownTimeout: p.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		},
+		DB: DB{
+			Host:           p.required("DB_HOST"),
+			Port:           p.port("DB_PORT", "5432"),
+			User:           p.required("DB_USER"),
+			Password:       p.str("DB_PASSWORD", ""),
+			Name:           p.required("DB_NAME"),
+			SSLMode:        p.oneOf("DB_SSL_MODE", "require", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+			AutoMigrate:    p.bool("DB_AUTO_MIGRATE", false),
+			ConnectTimeout: p.duration("DB_CONNECT_TIMEOUT", time.Minute),
+		},
+		JWT: JWT{
+			Secret:               []byte(jwtSecret),
+			SigningKeyFile:       signingKeyFile,
+			VerificationKeyFiles: p.list("JWT_VERIFICATION_KEY_FILES"),
+			Issuer:               p.str("JWT_ISSUER", "gin-wallet"),
+			Audience:             p.str("JWT_AUDIENCE", "gin-wallet"),
+			AccessTTL:            p.duration("JWT_EXPIRATION", 15*time.Minute),
+			RefreshTTL:           p.duration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		},
+		RateLimit: RateLimit{
+			Auth:   p.limit("RATE_LIMIT_AUTH", Limit{Burst: 10, Period: time.Minute}),
+			Wallet: p.limit("RATE_LIMIT_WALLET", Limit{Burst: 120, Period: time.Minute}),
+		},
+		TrustedProxies: p.list("TRUSTED_PROXIES"),
+		Lockout: Lockout{
+			MaxFailures:   p.count("LOGIN_MAX_FAILURES", 5),
+			IPMaxFailures: p.count("LOGIN_IP_MAX_FAILURES", 50),
+			Duration:      p.duration("LOGIN_LOCKOUT", time.Minute),
+			MaxDuration:   p.duration("LOGIN_MAX_LOCKOUT", 30*time.Minute),
+			Window:        p.duration("LOGIN_FAILURE_WINDOW", time.Hour),
+		},
+		Password: Password{
+			// password/common.txt leaves out passwords shorter than 8, the
+			// length check has to reject those
+			MinLength:         p.intRange("PASSWORD_MIN_LENGTH", 8, 8, 72),
+			Hash:              p.oneOf("PASSWORD_HASH", "bcrypt", "bcrypt", "argon2id"),
+			BcryptCost:        p.intRange("BCRYPT_COST", 12, 4, 31),
+			Argon2Memory:      p.intRange("ARGON2_MEMORY_KIB", 19*1024, 8, 4<<20),
+			Argon2Iterations:  p.intRange("ARGON2_ITERATIONS", 2, 1, 100),
+			Argon2Parallelism: p.intRange("ARGON2_PARALLELISM", 1, 1, 255),
+		},
+		MFA: MFA{
+			Issuer:        p.str("MFA_ISSUER", "gin-wallet"),
+			EncryptionKey: []byte(p.optionalSecret("MFA_ENCRYPTION_KEY")),
+			StepUpAmount:  p.amount("STEP_UP_AMOUNT", models.MustParseMoney("1000")),
+		},
+		Tracing: Tracing{
+			Exporter:    p.oneOf("TRACING_EXPORTER", "none", "none", "stdout", "otlp"),
+			ServiceName: p.str("OTEL_SERVICE_NAME", "gin-wallet"),
+			SampleRatio: p.ratio("TRACING_SAMPLE_RATIO", 1),
+		},
+	}
+
+	if len(p.errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errors.Join(p.errs...))
+	}
+	return cfg, nil
+}
+
+// parser reads settings, collecting errors instead of stopping at the first
+type parser struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (p *parser) fail(name, format string, args ...interface{}) {
+	p.errs = append(p.errs, fmt.Errorf("%s: "+format, append([]interface{}{name}, args...)...))
+}
+
+func (p *parser) str(name, def string) string {
+	if v, ok := p.lookup(name); ok && strings.TrimSpace(v) != "" {
+		return strings.TrimSpace(v)
+	}
+	return def
+}
+
+func (p *parser) required(name string) string {
+	v := p.str(name, "")
+	if v == "" {
+		p.fail(name, "required")
+	}
+	return v
+}
+
+// optionalSecret a key of at least minSecretLength characters, or unset
+func (p *parser) optionalSecret(name string) string {
+	v := p.str(name, "")
+	if v != "" && len(v) < minSecretLength {
+		p.fail(name, "must be at least %d characters", minSecretLength)
+	}
+	return v
+}
+
+func (p *parser) port(name, def string) string {
+	v := strings.TrimPrefix(p.str(name, def), ":")
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 65535 {
+		p.fail(name, "invalid port %q", v)
+	}
+	return v
+}
+
+func (p *parser) duration(name string, def time.Duration) time.Duration {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		p.fail(name, "invalid duration %q", v)
+		return def
+	}
+	return d
+}
+
+// limit parses "10/1m" as 10 requests per minute, or "off"
+func (p *parser) limit(name string, def Limit) Limit {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	if strings.EqualFold(v, "off") {
+		return Limit{}
+	}
+	burst, period, ok := strings.Cut(v, "/")
+	n, err := strconv.Atoi(burst)
+	d, err2 := time.ParseDuration(period)
+	if !ok || err != nil || err2 != nil || n < 1 || d <= 0 {
+		p.fail(name, "must look like 10/1m or off, got %q", v)
+		return def
+	}
+	return Limit{Burst: n, Period: d}
+}
+
+// amount parses a positive amount of money, or "off" for zero
+func (p *parser) amount(name string, def models.Money) models.Money {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	if strings.EqualFold(v, "off") {
+		return models.Money{}
+	}
+	m, err := models.ParseMoney(v)
+	if err != nil || !m.IsPositive() {
+		p.fail(name, "must be a positive amount or off, got %q", v)
+		return def
+	}
+	return m
+}
+
+// count parses a non-negative integer
+func (p *parser) count(name string, def int) int {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		p.fail(name, "must be a non-negative integer, got %q", v)
+		return def
+	}
+	return n
+}
+
+// intRange parses an integer between lo and hi
+func (p *parser) intRange(name string, def, lo, hi int) int {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		p.fail(name, "must be an integer between %d and %d, got %q", lo, hi, v)
+		return def
+	}
+	return n
+}
+
+func (p *parser) list(name string) []string {
+	var out []string
+	for _, v := range strings.Split(p.str(name, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (p *parser) ratio(name string, def float64) float64 {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		p.fail(name, "must be a number between 0 and 1, got %q", v)
+		return def
+	}
+	return f
+}
+
+func (p *parser) bool(name string, def bool) bool {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(name, "invalid boolean %q", v)
+		return def
+	}
+	return b
+}
+
+func (p *parser) oneOf(name, def string, allowed ...string) string {
+	v := strings.ToLower(p.str(name, def))
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	p.fail(name, "must be one of %s, got %q", strings.Join(allowed, ", "), v)
+	return def
+}
+
+func (p *parser) logLevel(name string, def zerolog.Level) zerolog.Level {
+	v := p.str(name, "")
+	if v == "" {
+		return def
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(v))
+	if err != nil {
+		p.fail(name, "invalid log level %q", v)
+		return def
+	}
+	return level
+}
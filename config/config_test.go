@@ -0,0 +1,217 @@
+package config
+
+import (
+	"gin-wallet2/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func validEnv() map[string]string {
+	return map[string]string{
+		"DB_HOST":    "localhost",
+		"DB_USER":    "postgres",
+		"DB_NAME":    "wallet",
+		"JWT_SECRET": testSecret,
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	cfg, err := Parse(lookupMap(validEnv()))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":8080", cfg.Addr())
+	assert.Equal(t, "release", cfg.GinMode)
+	assert.Equal(t, zerolog.InfoLevel, cfg.LogLevel)
+	assert.Equal(t, "json", cfg.LogFormat)
+	assert.Equal(t, 24*time.Hour, cfg.IdempotencyTTL)
+	assert.Equal(t, HTTP{ReadTimeout: 15 * time.Second, WriteTimeout: 30 * time.Second, IdleTimeout: time.Minute, ShutdownTimeout: 30 * time.Second}, cfg.HTTP)
+	assert.Equal(t, 15*time.Minute, cfg.JWT.AccessTTL)
+	assert.Equal(t, 720*time.Hour, cfg.JWT.RefreshTTL)
+	assert.Equal(t, []byte(testSecret), cfg.JWT.Secret)
+	assert.Empty(t, cfg.JWT.SigningKeyFile)
+	assert.Empty(t, cfg.JWT.VerificationKeyFiles)
+	assert.Equal(t, "gin-wallet", cfg.JWT.Issuer)
+	assert.Equal(t, "gin-wallet", cfg.JWT.Audience)
+	assert.False(t, cfg.DB.AutoMigrate)
+	assert.Equal(t, time.Minute, cfg.DB.ConnectTimeout)
+	assert.Equal(t, 2*time.Second, cfg.HealthTimeout)
+	assert.Equal(t, Tracing{Exporter: "none", ServiceName: "gin-wallet", SampleRatio: 1}, cfg.Tracing)
+	assert.Equal(t, RateLimit{Auth: Limit{Burst: 10, Period: time.Minute}, Wallet: Limit{Burst: 120, Period: time.Minute}}, cfg.RateLimit)
+	assert.Empty(t, cfg.TrustedProxies)
+	assert.Equal(t, Password{MinLength: 8, Hash: "bcrypt", BcryptCost: 12, Argon2Memory: 19456, Argon2Iterations: 2, Argon2Parallelism: 1}, cfg.Password)
+	assert.Equal(t, Lockout{MaxFailures: 5, IPMaxFailures: 50, Duration: time.Minute, MaxDuration: 30 * time.Minute, Window: time.Hour}, cfg.Lockout)
+	assert.Equal(t, "gin-wallet", cfg.MFA.Issuer)
+	assert.Empty(t, cfg.MFA.EncryptionKey)
+	assert.True(t, cfg.MFA.StepUpAmount.Equal(models.MustParseMoney("1000")))
+	assert.Equal(t, "host=localhost port=5432 user=postgres password= dbname=wallet sslmode=require", cfg.DB.DSN())
+}
+
+func TestParseOverrides(t *testing.T) {
+	env := validEnv()
+	env["PORT"] = "9090"
+	env["GIN_MODE"] = "debug"
+	env["LOG_LEVEL"] = "warn"
+	env["LOG_FORMAT"] = "Console"
+	env["IDEMPOTENCY_TTL"] = "1h"
+	env["JWT_EXPIRATION"] = "5m"
+	env["DB_SSL_MODE"] = "disable"
+	env["DB_AUTO_MIGRATE"] = "true"
+	env["SHUTDOWN_TIMEOUT"] = "5s"
+	env["TRACING_EXPORTER"] = "OTLP"
+	env["TRACING_SAMPLE_RATIO"] = "0.25"
+	env["RATE_LIMIT_AUTH"] = "5/30s"
+	env["RATE_LIMIT_WALLET"] = "off"
+	env["TRUSTED_PROXIES"] = "10.0.0.0/8, 172.16.0.1"
+	env["LOGIN_MAX_FAILURES"] = "3"
+	env["LOGIN_IP_MAX_FAILURES"] = "0"
+	env["PASSWORD_HASH"] = "Argon2id"
+	env["ARGON2_MEMORY_KIB"] = "65536"
+	env["MFA_ENCRYPTION_KEY"] = testSecret
+	env["STEP_UP_AMOUNT"] = "250.50"
+
+	cfg, err := Parse(lookupMap(env))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9090", cfg.Addr())
+	assert.Equal(t, "debug", cfg.GinMode)
+	assert.Equal(t, zerolog.WarnLevel, cfg.LogLevel)
+	assert.Equal(t, "console", cfg.LogFormat)
+	assert.Equal(t, time.Hour, cfg.IdempotencyTTL)
+	assert.Equal(t, 5*time.Minute, cfg.JWT.AccessTTL)
+	assert.Equal(t, "disable", cfg.DB.SSLMode)
+	assert.True(t, cfg.DB.AutoMigrate)
+	assert.Equal(t, 5*time.Second, cfg.HTTP.ShutdownTimeout)
+	assert.Equal(t, "otlp", cfg.Tracing.Exporter)
+	assert.Equal(t, 0.25, cfg.Tracing.SampleRatio)
+	assert.Equal(t, Limit{Burst: 5, Period: 30 * time.Second}, cfg.RateLimit.Auth)
+	assert.Equal(t, Limit{}, cfg.RateLimit.Wallet)
+	assert.Equal(t, []string{"10.0.0.0/8", "172.16.0.1"}, cfg.TrustedProxies)
+	assert.Equal(t, 3, cfg.Lockout.MaxFailures)
+	assert.Equal(t, 0, cfg.Lockout.IPMaxFailures)
+	assert.Equal(t, "argon2id", cfg.Password.Hash)
+	assert.Equal(t, 65536, cfg.Password.Argon2Memory)
+	assert.Equal(t, []byte(testSecret), cfg.MFA.EncryptionKey)
+	assert.Equal(t, "250.50", cfg.MFA.StepUpAmount.String())
+
+	env["STEP_UP_AMOUNT"] = "off"
+	cfg, err = Parse(lookupMap(env))
+	require.NoError(t, err)
+	assert.True(t, cfg.MFA.StepUpAmount.IsZero())
+
+	// a signing key replaces the HS256 secret
+	delete(env, "JWT_SECRET")
+	env["JWT_SIGNING_KEY_FILE"] = "/run/secrets/jwt-2024.pem"
+	env["JWT_VERIFICATION_KEY_FILES"] = "/run/secrets/jwt-2023.pub.pem,/run/secrets/jwt-2025.pub.pem"
+	env["JWT_ISSUER"] = "https://wallet.example.com"
+	env["JWT_AUDIENCE"] = "wallet-api"
+	cfg, err = Parse(lookupMap(env))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.JWT.Secret)
+	assert.Equal(t, "/run/secrets/jwt-2024.pem", cfg.JWT.SigningKeyFile)
+	assert.Equal(t, []string{"/run/secrets/jwt-2023.pub.pem", "/run/secrets/jwt-2025.pub.pem"}, cfg.JWT.VerificationKeyFiles)
+	assert.Equal(t, "https://wallet.example.com", cfg.JWT.Issuer)
+	assert.Equal(t, "wallet-api", cfg.JWT.Audience)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		unset   []string
+		wantErr []string
+	}{
+		{
+			name:    "missing secrets and database",
+			unset:   []string{"JWT_SECRET", "DB_HOST", "DB_USER", "DB_NAME"},
+			wantErr: []string{"JWT_SECRET: required", "DB_HOST: required", "DB_USER: required", "DB_NAME: required"},
+		},
+		{
+			name:    "short secret",
+			env:     map[string]string{"JWT_SECRET": "your-secret-key"},
+			wantErr: []string{"JWT_SECRET: must be at least 32 characters"},
+		},
+		{
+			name: "invalid values",
+			env: map[string]string{
+				"PORT": "http", "GIN_MODE": "verbose", "LOG_LEVEL": "loud", "JWT_EXPIRATION": "24",
+				"DB_AUTO_MIGRATE": "sometimes", "DB_SSL_MODE": "maybe", "HTTP_READ_TIMEOUT": "-1s",
+				"TRACING_SAMPLE_RATIO": "2", "RATE_LIMIT_AUTH": "10 per minute", "LOGIN_MAX_FAILURES": "-1",
+				"BCRYPT_COST": "40", "STEP_UP_AMOUNT": "-5", "MFA_ENCRYPTION_KEY": "short",
+				"PASSWORD_MIN_LENGTH": "6",
+			},
+			wantErr: []string{
+				`PORT: invalid port "http"`,
+				`GIN_MODE: must be one of debug, release, test, got "verbose"`,
+				`LOG_LEVEL: invalid log level "loud"`,
+				`JWT_EXPIRATION: invalid duration "24"`,
+				`DB_AUTO_MIGRATE: invalid boolean "sometimes"`,
+				`DB_SSL_MODE: must be one of`,
+				`HTTP_READ_TIMEOUT: invalid duration "-1s"`,
+				`TRACING_SAMPLE_RATIO: must be a number between 0 and 1, got "2"`,
+				`RATE_LIMIT_AUTH: must look like 10/1m or off, got "10 per minute"`,
+				`LOGIN_MAX_FAILURES: must be a non-negative integer, got "-1"`,
+				`BCRYPT_COST: must be an integer between 4 and 31, got "40"`,
+				`STEP_UP_AMOUNT: must be a positive amount or off, got "-5"`,
+				`MFA_ENCRYPTION_KEY: must be at least 32 characters`,
+				`PASSWORD_MIN_LENGTH: must be an integer between 8 and 72, got "6"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := validEnv()
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			for _, k := range tt.unset {
+				delete(env, k)
+			}
+
+			_, err := Parse(lookupMap(env))
+			require.Error(t, err)
+			for _, want := range tt.wantErr {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "wallet.env")
+	require.NoError(t, os.WriteFile(file, []byte("DB_HOST=filehost\nDB_USER=fileuser\nDB_NAME=wallet\nJWT_SECRET="+testSecret+"\nPORT=7070\n"), 0o600))
+
+	t.Setenv("CONFIG_FILE", file)
+	// the environment wins over the file
+	t.Setenv("PORT", "6060")
+	for _, name := range []string{"DB_HOST", "DB_USER", "DB_NAME", "JWT_SECRET"} {
+		if _, ok := os.LookupEnv(name); ok {
+			t.Skipf("%s is set in the environment", name)
+		}
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{"DB_HOST", "DB_USER", "DB_NAME", "JWT_SECRET"} {
+			_ = os.Unsetenv(name)
+		}
+	})
+
+	cfg, err := Load()
+	require.NoError(t, err)
+	assert.Equal(t, "filehost", cfg.DB.Host)
+	assert.Equal(t, ":6060", cfg.Addr())
+}
@@ -2,45 +2,77 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
+	"gin-wallet2/config"
 	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
 var (
-	db   *sql.DB
-	once sync.Once
+	db    *sql.DB
+	dbErr error
+	once  sync.Once
 )
 
-// InitDB initializes a single database connection and returns it.
-func InitDB() *sql.DB {
+// connect retry backoff, doubled after every failed attempt
+var (
+	connectBackoff    = 250 * time.Millisecond
+	maxConnectBackoff = 5 * time.Second
+)
+
+// InitDB initializes a single database connection and returns it. An
+// unreachable database is retried for cfg.ConnectTimeout or until ctx is done.
+func InitDB(ctx context.Context, cfg config.DB) (*sql.DB, error) {
 	once.Do(func() {
+		db, dbErr = connectDB(ctx, cfg)
+	})
+	return db, dbErr
+}
 
-		dbConfig := fmt.Sprintf(
-			"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-			os.Getenv("DB_HOST"),
-			os.Getenv("DB_PORT"),
-			os.Getenv("DB_USER"),
-			os.Getenv("DB_PASSWORD"),
-			os.Getenv("DB_NAME"),
-			os.Getenv("DB_SSL_MODE"),
-		)
-
-		var err error
-		db, err = sql.Open("postgres", dbConfig)
-		if err != nil {
-			log.Fatal("Failed to connect to database:", err)
+func connectDB(ctx context.Context, cfg config.DB) (*sql.DB, error) {
+	conn, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := waitForDB(ctx, conn, cfg.ConnectTimeout); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("database is not reachable: %w", err)
+	}
+	zlog.Info().Msg("Database connection established successfully")
+
+	if err := autoMigrate(conn, cfg.AutoMigrate); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return conn, nil
+}
+
+// waitForDB pings db with exponential backoff until it answers or timeout passes
+func waitForDB(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := connectBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
 		}
+		zlog.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Dur("retry_in", backoff).
+			Msg("Database not reachable yet")
 
-		// Ping to check connection
-		if err := db.Ping(); err != nil {
-			log.Fatal("Database is not reachable:", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
 		}
-		log.Println("Database connection established successfully")
-	})
-	return db
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
 }
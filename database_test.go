@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"gin-wallet2/config"
 	"go.uber.org/goleak"
 	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
@@ -10,21 +18,26 @@ func TestMain(m *testing.M) {
 }
 
 func TestInitDB(t *testing.T) {
-	db1 := InitDB()
-	if db1 == nil {
-		t.Error("Expected database connection, got nil")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
 	}
 
-	db2 := InitDB()
-	if db2 == nil {
-		t.Error("Expected database connection, got nil")
+	db1, err := InitDB(context.Background(), cfg.DB)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	db2, err := InitDB(context.Background(), cfg.DB)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
 	}
 
 	if db1 != db2 {
 		t.Error("Expected same database instance, got different instances")
 	}
 
-	err := db1.Ping()
+	err = db1.Ping()
 	if err != nil {
 		t.Errorf("Database connection is not valid: %v", err)
 	}
@@ -32,3 +45,35 @@ func TestInitDB(t *testing.T) {
 	// Clean up
 	defer db1.Close()
 }
+
+func TestWaitForDB(t *testing.T) {
+	backoff := connectBackoff
+	connectBackoff = time.Millisecond
+	t.Cleanup(func() { connectBackoff = backoff })
+
+	t.Run("retries until the database answers", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectPing().WillReturnError(errors.New("the database system is starting up"))
+		mock.ExpectPing()
+
+		assert.NoError(t, waitForDB(context.Background(), db, time.Second))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("gives up after the timeout", func(t *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		require.NoError(t, err)
+		defer db.Close()
+
+		for i := 0; i < 100; i++ {
+			mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		}
+
+		err = waitForDB(context.Background(), db, 20*time.Millisecond)
+		assert.Error(t, err)
+	})
+}
@@ -1,105 +0,0 @@
-/*
- Navicat Premium Data Transfer
-
- Source Server         : localPs
- Source Server Type    : PostgreSQL
- Source Server Version : 150010 (150010)
- Source Host           : localhost:5432
- Source Catalog        : wallet
- Source Schema         : public
-
- Target Server Type    : PostgreSQL
- Target Server Version : 150010 (150010)
- File Encoding         : 65001
-
- Date: 29/11/2024 19:09:48
-*/
-
-
--- ----------------------------
--- Sequence structure for transactions_id_seq
--- ----------------------------
-DROP SEQUENCE IF EXISTS "public"."transactions_id_seq";
-CREATE SEQUENCE "public"."transactions_id_seq" 
-INCREMENT 1
-MINVALUE  1
-MAXVALUE 2147483647
-START 1
-CACHE 1;
-
--- ----------------------------
--- Sequence structure for users_id_seq
--- ----------------------------
-DROP SEQUENCE IF EXISTS "public"."users_id_seq";
-CREATE SEQUENCE "public"."users_id_seq" 
-INCREMENT 1
-MINVALUE  1
-MAXVALUE 2147483647
-START 1
-CACHE 1;
-
--- ----------------------------
--- Table structure for transactions
--- ----------------------------
-DROP TABLE IF EXISTS "public"."transactions";
-CREATE TABLE "public"."transactions" (
-  "id" int4 NOT NULL DEFAULT nextval('transactions_id_seq'::regclass),
-  "user_id" int4 NOT NULL,
-  "type" varchar(50) COLLATE "pg_catalog"."default" NOT NULL,
-  "amount" numeric(10,2) NOT NULL,
-  "description" text COLLATE "pg_catalog"."default",
-  "created_at" timestamp(6) DEFAULT CURRENT_TIMESTAMP
-)
-;
-
--- ----------------------------
--- Table structure for users
--- ----------------------------
-DROP TABLE IF EXISTS "public"."users";
-CREATE TABLE "public"."users" (
-  "id" int4 NOT NULL DEFAULT nextval('users_id_seq'::regclass),
-  "name" varchar(100) COLLATE "pg_catalog"."default" NOT NULL,
-  "balance" numeric(10,2) DEFAULT 0,
-  "created_at" timestamp(6) DEFAULT CURRENT_TIMESTAMP,
-  "password_hash" text COLLATE "pg_catalog"."default" NOT NULL
-)
-;
-
--- ----------------------------
--- Alter sequences owned by
--- ----------------------------
-ALTER SEQUENCE "public"."transactions_id_seq"
-OWNED BY "public"."transactions"."id";
-SELECT setval('"public"."transactions_id_seq"', 5, true);
-
--- ----------------------------
--- Alter sequences owned by
--- ----------------------------
-ALTER SEQUENCE "public"."users_id_seq"
-OWNED BY "public"."users"."id";
-SELECT setval('"public"."users_id_seq"', 3, true);
-
--- ----------------------------
--- Checks structure for table transactions
--- ----------------------------
-ALTER TABLE "public"."transactions" ADD CONSTRAINT "transactions_amount_check" CHECK (amount > 0::numeric);
-
--- ----------------------------
--- Primary Key structure for table transactions
--- ----------------------------
-ALTER TABLE "public"."transactions" ADD CONSTRAINT "transactions_pkey" PRIMARY KEY ("id");
-
--- ----------------------------
--- Checks structure for table users
--- ----------------------------
-ALTER TABLE "public"."users" ADD CONSTRAINT "users_balance_check" CHECK (balance >= 0::numeric);
-
--- ----------------------------
--- Primary Key structure for table users
--- ----------------------------
-ALTER TABLE "public"."users" ADD CONSTRAINT "users_pkey" PRIMARY KEY ("id");
-
--- ----------------------------
--- Foreign Keys structure for table transactions
--- ----------------------------
-ALTER TABLE "public"."transactions" ADD CONSTRAINT "transactions_user_id_fkey" FOREIGN KEY ("user_id") REFERENCES "public"."users" ("id") ON DELETE NO ACTION ON UPDATE NO ACTION;
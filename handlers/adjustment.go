@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReasonLength longest accepted adjustment reason
+const maxReasonLength = 500
+
+// adjustmentsLimit adjustments returned by GET /admin/wallet/adjustments
+const adjustmentsLimit = 100
+
+// Adjust credits or, with a negative amount, debits user_id's wallet against
+// the adjustments account. The acting admin and the reason are recorded with
+// the journal entry; admins cannot adjust their own wallet.
+func (h *WalletHandler) Adjust(c *gin.Context) {
+	var req struct {
+		UserID int          `json:"user_id"`
+		Amount models.Money `json:"amount"`
+		Reason string       `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserID <= 0 || req.Amount.IsZero() {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > maxReasonLength {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+
+	adminID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+	if req.UserID == adminID {
+		respondError(c, models.ErrOwnWalletAdjustment)
+		return
+	}
+
+	moved := req.Amount
+	txType := models.TxTypeAdjustmentCredit
+	if req.Amount.IsNegative() {
+		moved = req.Amount.Neg()
+		txType = models.TxTypeAdjustmentDebit
+	}
+	if err := h.stepUp(c, moved); err != nil {
+		h.Metrics.WalletOperation(models.TxTypeAdjustment, moved, err)
+		respondError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	annotateSpan(ctx, models.TxTypeAdjustment, req.UserID)
+	adjustment := models.Adjustment{UserID: req.UserID, AdminID: adminID, Amount: req.Amount, Reason: req.Reason}
+	err := h.Store.WithinTx(ctx, func(tx store.WalletTx) error {
+		balances, err := tx.LockBalances(ctx, req.UserID)
+		if err != nil {
+			return storeError("Failed to query balance", err)
+		}
+		if balances[req.UserID].Add(req.Amount).IsNegative() {
+			return models.ErrInsufficientFunds
+		}
+		if !balances[req.UserID].Add(req.Amount).InRange() {
+			return models.ErrBalanceLimit
+		}
+
+		balance, err := tx.AdjustBalance(ctx, req.UserID, req.Amount)
+		if err != nil {
+			return storeError("Failed to update balance", err)
+		}
+
+		description := "Manual adjustment: " + req.Reason
+		entryID, err := tx.PostEntry(ctx, models.JournalEntry{
+			Kind:        models.TxTypeAdjustment,
+			Description: description,
+			Postings: []models.Posting{
+				{Account: models.AdjustmentsAccount, Amount: req.Amount.Neg()},
+				{Account: models.UserAccount(req.UserID), Amount: req.Amount},
+			},
+		})
+		if err != nil {
+			return storeError("Failed to post ledger entry", err)
+		}
+
+		err = tx.RecordTransactions(ctx, models.Transaction{
+			UserID: req.UserID, Type: txType, Amount: moved, Description: description, EntryID: entryID,
+		}.WithBalance(balance))
+		if err != nil {
+			return storeError("Failed to record transaction", err)
+		}
+
+		adjustment.EntryID = entryID
+		return storeError("Failed to record adjustment", tx.RecordAdjustment(ctx, &adjustment))
+	})
+	h.Metrics.WalletOperation(models.TxTypeAdjustment, moved, err)
+	if err != nil {
+		respondError(c, storeError("Failed to complete transaction", err))
+		return
+	}
+	requestLogger(c).Info().Int("admin_id", adminID).Int("target_user_id", req.UserID).
+		Str("amount", req.Amount.String()).Int("adjustment_id", adjustment.ID).Msg("Manual adjustment")
+
+	c.JSON(http.StatusCreated, gin.H{"adjustment": adjustment})
+}
+
+// Adjustments lists the most recent manual adjustments, of ?user_id= if given
+func (h *WalletHandler) Adjustments(c *gin.Context) {
+	userID := 0
+	if v := c.Query("user_id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(c, models.ErrInvalidUserID)
+			return
+		}
+		userID = n
+	}
+
+	adjustments, err := h.Store.Adjustments(c.Request.Context(), userID, adjustmentsLimit)
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"adjustments": adjustments})
+}
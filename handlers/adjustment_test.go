@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"gin-wallet2/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletHandler_Adjust(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name            string
+		body            string
+		failOn          string
+		expectedStatus  int
+		expectedBody    string
+		expectedBalance string
+	}{
+		{
+			name:            "credit",
+			body:            `{"user_id":2,"amount":"10.50","reason":"goodwill"}`,
+			expectedStatus:  http.StatusCreated,
+			expectedBalance: "30.50",
+		},
+		{
+			name:            "debit",
+			body:            `{"user_id":2,"amount":"-20","reason":" duplicate deposit "}`,
+			expectedStatus:  http.StatusCreated,
+			expectedBalance: "0.00",
+		},
+		{
+			name:            "debit below zero",
+			body:            `{"user_id":2,"amount":"-20.01","reason":"fee"}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":"Insufficient balance","code":"insufficient_funds"}`,
+			expectedBalance: "20.00",
+		},
+		{
+			name:            "zero amount",
+			body:            `{"user_id":2,"amount":"0","reason":"nothing"}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":"Invalid input","code":"invalid_input"}`,
+			expectedBalance: "20.00",
+		},
+		{
+			name:            "missing reason",
+			body:            `{"user_id":2,"amount":"1","reason":"  "}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":"Invalid input","code":"invalid_input"}`,
+			expectedBalance: "20.00",
+		},
+		{
+			name:            "reason too long",
+			body:            `{"user_id":2,"amount":"1","reason":"` + strings.Repeat("x", maxReasonLength+1) + `"}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":"Invalid input","code":"invalid_input"}`,
+			expectedBalance: "20.00",
+		},
+		{
+			name:            "own wallet",
+			body:            `{"user_id":1,"amount":"1","reason":"bonus"}`,
+			expectedStatus:  http.StatusForbidden,
+			expectedBody:    `{"error":"Admins cannot adjust their own wallet","code":"forbidden"}`,
+			expectedBalance: "20.00",
+		},
+		{
+			name:           "user not found",
+			body:           `{"user_id":99,"amount":"1","reason":"bonus"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"User not found","code":"user_not_found"}`,
+		},
+		{
+			name:            "adjustment not recorded",
+			body:            `{"user_id":2,"amount":"1","reason":"bonus"}`,
+			failOn:          "RecordAdjustment",
+			expectedStatus:  http.StatusInternalServerError,
+			expectedBody:    `{"error":"Failed to record adjustment","code":"internal_error"}`,
+			expectedBalance: "20.00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, "0", "20")
+			handler := NewWalletHandler(&faultyStore{Memory: s, failOn: tt.failOn, err: sql.ErrConnDone})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			c.Set("userID", 1)
+			c.Set("adminOverride", true)
+
+			handler.Adjust(c)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			if tt.expectedBalance != "" {
+				balance, _ := s.Balance(context.Background(), 2)
+				assert.Equal(t, tt.expectedBalance, balance.String())
+			}
+			assertStoreBalanced(t, s)
+		})
+	}
+}
+
+func TestWalletHandler_AdjustRecordsAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	s := newTestStore(t, "0", "20")
+	handler := NewWalletHandler(s)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"user_id":2,"amount":"-5","reason":"chargeback"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("userID", 1)
+	handler.Adjust(c)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var resp struct {
+		Adjustment models.Adjustment `json:"adjustment"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Adjustment.UserID)
+	assert.Equal(t, 1, resp.Adjustment.AdminID)
+	assert.Equal(t, "-5.00", resp.Adjustment.Amount.String())
+	assert.Equal(t, "chargeback", resp.Adjustment.Reason)
+	assert.NotZero(t, resp.Adjustment.EntryID)
+
+	transactions, _ := s.Transactions(ctx, 2, models.TransactionQuery{})
+	if assert.Len(t, transactions, 1) {
+		assert.Equal(t, models.TxTypeAdjustmentDebit, transactions[0].Type)
+		assert.Equal(t, "5.00", transactions[0].Amount.String())
+		assert.Equal(t, "Manual adjustment: chargeback", transactions[0].Description)
+		assert.Equal(t, resp.Adjustment.EntryID, transactions[0].EntryID)
+		assert.Equal(t, "20.00", transactions[0].BalanceBefore.String())
+		assert.Equal(t, "15.00", transactions[0].BalanceAfter.String())
+	}
+
+	for _, tt := range []struct {
+		query        string
+		expectedCode int
+		expectedLen  int
+	}{
+		{query: "", expectedCode: http.StatusOK, expectedLen: 1},
+		{query: "?user_id=2", expectedCode: http.StatusOK, expectedLen: 1},
+		{query: "?user_id=1", expectedCode: http.StatusOK, expectedLen: 0},
+		{query: "?user_id=abc", expectedCode: http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+		handler.Adjustments(c)
+
+		assert.Equal(t, tt.expectedCode, w.Code, tt.query)
+		if tt.expectedCode == http.StatusOK {
+			var list struct {
+				Adjustments []models.Adjustment `json:"adjustments"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+			assert.NotNil(t, list.Adjustments, tt.query)
+			assert.Len(t, list.Adjustments, tt.expectedLen, tt.query)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchLimit default and maximum number of users returned by GET /admin/users
+const (
+	searchLimit    = 20
+	maxSearchLimit = 100
+)
+
+// AdminHandler user lookup and role management for staff. Changing a role
+// revokes the user's sessions so the new role applies from the next login.
+type AdminHandler struct {
+	Users   store.UserStore
+	Wallets store.WalletStore
+	Tokens  store.TokenStore
+}
+
+// NewAdminHandler new admin handler
+func NewAdminHandler(users store.UserStore, wallets store.WalletStore, tokens store.TokenStore) *AdminHandler {
+	return &AdminHandler{Users: users, Wallets: wallets, Tokens: tokens}
+}
+
+// adminUserResponse a user as staff see it, Balance only on single user lookups
+type adminUserResponse struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+	models.Profile
+	Balance   *models.Money `json:"balance,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+func newAdminUserResponse(u *models.User) adminUserResponse {
+	return adminUserResponse{ID: u.ID, Name: u.Name, Role: u.Role, Profile: u.Profile, CreatedAt: u.CreatedAt}
+}
+
+// SearchUsers finds users by name, display name or email with ?q=, up to ?limit=
+func (h *AdminHandler) SearchUsers(c *gin.Context) {
+	limit := searchLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			respondError(c, models.ErrInvalidInput)
+			return
+		}
+		limit = n
+	}
+
+	users, err := h.Users.SearchUsers(c.Request.Context(), c.Query("q"), limit)
+	if err != nil {
+		respondError(c, storeError("Failed to search users", err))
+		return
+	}
+
+	resp := make([]adminUserResponse, len(users))
+	for i := range users {
+		resp[i] = newAdminUserResponse(&users[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"users": resp})
+}
+
+// User returns :userID with its balance
+func (h *AdminHandler) User(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.Users.UserByID(ctx, userID)
+	if err != nil {
+		respondError(c, storeError("Failed to query user", err))
+		return
+	}
+	balance, err := h.Wallets.Balance(ctx, userID)
+	if err != nil {
+		respondError(c, storeError("Failed to query balance", err))
+		return
+	}
+
+	resp := newAdminUserResponse(user)
+	resp.Balance = &balance
+	c.JSON(http.StatusOK, resp)
+}
+
+// SetRole changes the role of :userID. Admins cannot change their own role,
+// so the last admin cannot lock everyone out by accident.
+func (h *AdminHandler) SetRole(c *gin.Context) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+	if !models.ValidRole(req.Role) {
+		respondError(c, models.ErrInvalidRole)
+		return
+	}
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+	adminID, _ := currentUserID(c)
+	if adminID == userID {
+		respondError(c, models.ErrOwnRoleChange)
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := h.Users.UpdateRole(ctx, userID, req.Role); err != nil {
+		respondError(c, storeError("Failed to update role", err))
+		return
+	}
+	if err := h.Tokens.RevokeUserTokens(ctx, userID); err != nil {
+		respondError(c, storeError("Failed to revoke sessions", err))
+		return
+	}
+	requestLogger(c).Info().Int("admin_id", adminID).Int("target_user_id", userID).Str("role", req.Role).Msg("Role changed")
+
+	c.JSON(http.StatusOK, gin.H{"id": userID, "role": req.Role})
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gin-wallet2/middleware"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminRouter staff routes as wired in main over s, and a function issuing
+// an access token for a user after giving it role
+func adminRouter(t *testing.T, s *store.Memory) (*gin.Engine, func(userID int, role string) string) {
+	gin.SetMode(gin.TestMode)
+	tokens := token.NewManager(token.HMACKeys([]byte("test-secret")), 15*time.Minute, time.Hour, s, s)
+	staff := middleware.RequireRole(models.RoleSupport, models.RoleAdmin, models.RoleAuditor)
+	reviewers := middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)
+	admins := middleware.RequireRole(models.RoleAdmin)
+	admin := NewAdminHandler(s, s, s)
+	wallet := NewWalletHandler(s)
+
+	router := gin.New()
+	group := router.Group("/admin", middleware.AuthMiddleware(tokens))
+	group.GET("/users", staff, admin.SearchUsers)
+	group.GET("/users/:userID", staff, admin.User)
+	group.PUT("/users/:userID/role", admins, admin.SetRole)
+	group.POST("/wallet/adjustments", admins, wallet.Adjust)
+	group.GET("/wallet/adjustments", reviewers, wallet.Adjustments)
+	group.GET("/ledger/verify", reviewers, wallet.VerifyLedger)
+
+	issue := func(userID int, role string) string {
+		ctx := context.Background()
+		require.NoError(t, s.UpdateRole(ctx, userID, role))
+		user, err := s.UserByID(ctx, userID)
+		require.NoError(t, err)
+		pair, err := tokens.Issue(ctx, user)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	return router, issue
+}
+
+// adminRequest sends body, if any, as JSON with accessToken
+func adminRequest(router *gin.Engine, method, path, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		jsonBody, _ := json.Marshal(body)
+		reader = bytes.NewReader(jsonBody)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutes_Roles(t *testing.T) {
+	s := newTestStore(t, "0", "0", "0", "0")
+	router, issue := adminRouter(t, s)
+	tokens := map[string]string{
+		models.RoleUser:    issue(1, models.RoleUser),
+		models.RoleSupport: issue(2, models.RoleSupport),
+		models.RoleAuditor: issue(3, models.RoleAuditor),
+		models.RoleAdmin:   issue(4, models.RoleAdmin),
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		allow  []string
+	}{
+		{method: http.MethodGet, path: "/admin/users?q=user", allow: []string{models.RoleSupport, models.RoleAuditor, models.RoleAdmin}},
+		{method: http.MethodGet, path: "/admin/users/1", allow: []string{models.RoleSupport, models.RoleAuditor, models.RoleAdmin}},
+		{method: http.MethodGet, path: "/admin/wallet/adjustments", allow: []string{models.RoleAuditor, models.RoleAdmin}},
+		{method: http.MethodGet, path: "/admin/ledger/verify", allow: []string{models.RoleAuditor, models.RoleAdmin}},
+		{method: http.MethodPost, path: "/admin/wallet/adjustments", body: map[string]interface{}{"user_id": 1, "amount": "1", "reason": "test"}, allow: []string{models.RoleAdmin}},
+	}
+
+	for _, tt := range tests {
+		for _, role := range models.Roles {
+			t.Run(tt.method+" "+tt.path+" as "+role, func(t *testing.T) {
+				w := adminRequest(router, tt.method, tt.path, tokens[role], tt.body)
+				if contains(tt.allow, role) {
+					assert.Less(t, w.Code, 300, w.Body.String())
+				} else {
+					assert.Equal(t, http.StatusForbidden, w.Code)
+					assert.JSONEq(t, `{"error":"Insufficient role","code":"forbidden"}`, w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAdminHandler_Users(t *testing.T) {
+	s := newTestStore(t, "12.34", "0")
+	require.NoError(t, s.UpdateProfile(context.Background(), 2, models.Profile{Email: "b@example.com"}))
+	router, issue := adminRouter(t, s)
+	support := issue(1, models.RoleSupport)
+
+	w := adminRequest(router, http.MethodGet, "/admin/users?q=EXAMPLE", support, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var search struct {
+		Users []map[string]interface{} `json:"users"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &search))
+	if assert.Len(t, search.Users, 1) {
+		assert.Equal(t, "userb", search.Users[0]["name"])
+		assert.Equal(t, "user", search.Users[0]["role"])
+		assert.NotContains(t, search.Users[0], "balance")
+	}
+
+	w = adminRequest(router, http.MethodGet, "/admin/users?q=nobody", support, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"users":[]}`, w.Body.String())
+
+	w = adminRequest(router, http.MethodGet, "/admin/users?limit=1000", support, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(router, http.MethodGet, "/admin/users/1", support, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var user map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+	assert.Equal(t, "12.34", user["balance"])
+	assert.Equal(t, "support", user["role"])
+	assert.NotContains(t, user, "password_hash")
+
+	w = adminRequest(router, http.MethodGet, "/admin/users/99", support, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminHandler_SetRole(t *testing.T) {
+	s := newTestStore(t, "0", "0")
+	router, issue := adminRouter(t, s)
+	admin := issue(1, models.RoleAdmin)
+	userToken := issue(2, models.RoleUser)
+
+	put := func(path string, body interface{}) *httptest.ResponseRecorder {
+		return adminRequest(router, http.MethodPut, path, admin, body)
+	}
+
+	w := put("/admin/users/2/role", map[string]string{"role": "root"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"Role must be one of user, support, admin, auditor","code":"invalid_input"}`, w.Body.String())
+
+	w = put("/admin/users/1/role", map[string]string{"role": models.RoleUser})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Admins cannot change their own role","code":"forbidden"}`, w.Body.String())
+
+	w = put("/admin/users/99/role", map[string]string{"role": models.RoleSupport})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = put("/admin/users/2/role", map[string]string{"role": models.RoleAuditor})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":2,"role":"auditor"}`, w.Body.String())
+	user, _ := s.UserByID(context.Background(), 2)
+	assert.Equal(t, models.RoleAuditor, user.Role)
+
+	// the old token with the old role is revoked
+	w = adminRequest(router, http.MethodGet, "/admin/ledger/verify", userToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
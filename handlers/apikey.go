@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"gin-wallet2/apikey"
+	"gin-wallet2/mfa"
+	"gin-wallet2/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler API keys of the caller. Creating a key needs a code in
+// OTPHeader from users with two-factor authentication, and keys that withdraw
+// or transfer need two-factor authentication since key requests skip the
+// step-up check. MFA may be nil, which turns both off.
+type APIKeyHandler struct {
+	Keys *apikey.Service
+	MFA  *mfa.Service
+}
+
+// NewAPIKeyHandler new API key handler
+func NewAPIKeyHandler(keys *apikey.Service) *APIKeyHandler {
+	return &APIKeyHandler{Keys: keys}
+}
+
+// Create issues a key with the requested scopes, the key is only returned
+// this once
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req struct {
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	ctx := c.Request.Context()
+	enabled, err := h.MFA.Enabled(ctx, userID)
+	if err != nil {
+		respondError(c, storeError("Failed to check two-factor authentication", err))
+		return
+	}
+	outgoing := false
+	for _, scope := range req.Scopes {
+		outgoing = outgoing || models.OutgoingScope(scope)
+	}
+	if enabled || outgoing && h.MFA != nil {
+		if err := verifyOTP(c, h.MFA, userID); err != nil {
+			switch err {
+			case models.ErrMFARequired:
+				err = models.ErrMFARequiredForAPIKey
+			case models.ErrMFANotEnabled:
+				err = models.ErrMFANotEnabledForAPIKey
+			}
+			respondError(c, err)
+			return
+		}
+	}
+
+	raw, key, err := h.Keys.Create(ctx, userID, req.Name, req.Scopes)
+	if err != nil {
+		respondError(c, storeError("Failed to create API key", err))
+		return
+	}
+	requestLogger(c).Info().
+		Int("api_key_id", key.ID).
+		Str("prefix", key.Prefix).
+		Strs("scopes", key.Scopes).
+		Msg("API key created")
+
+	c.JSON(http.StatusCreated, gin.H{"key": raw, "api_key": key})
+}
+
+// List returns the caller's keys including revoked ones, newest first,
+// without the keys themselves
+func (h *APIKeyHandler) List(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	keys, err := h.Keys.List(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, storeError("Failed to query API keys", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// Revoke revokes the caller's key :id, requests with it fail from then on
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		respondError(c, models.ErrAPIKeyNotFound)
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	if err := h.Keys.Revoke(c.Request.Context(), userID, id); err != nil {
+		respondError(c, storeError("Failed to revoke API key", err))
+		return
+	}
+	requestLogger(c).Info().
+		Int("api_key_id", id).
+		Msg("API key revoked")
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
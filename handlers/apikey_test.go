@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gin-wallet2/apikey"
+	"gin-wallet2/mfa"
+	"gin-wallet2/middleware"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyRouter login, API key management and wallet routes for testuser, who
+// is user 1, and a second user 2
+func apiKeyRouter(t *testing.T) (*gin.Engine, *store.Memory, *mfa.Service) {
+	gin.SetMode(gin.TestMode)
+	s := newUserStore(t, "password123")
+	_, err := s.CreateUser(context.Background(), "merchant", "hash")
+	require.NoError(t, err)
+	authHandler := newAuthHandler(s)
+	twoFactor := mfa.NewService(s, "gin-wallet")
+	keys := apikey.NewService(s)
+	keyHandler := NewAPIKeyHandler(keys)
+	keyHandler.MFA = twoFactor
+	wallet := NewWalletHandler(s)
+	wallet.MFA = twoFactor
+	wallet.StepUpAmount = models.MustParseMoney("50")
+	authRequired := middleware.AuthMiddleware(authHandler.Tokens)
+
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	router.POST("/me/api-keys", authRequired, keyHandler.Create)
+	router.GET("/me/api-keys", authRequired, keyHandler.List)
+	router.DELETE("/me/api-keys/:id", authRequired, keyHandler.Revoke)
+	walletGroup := router.Group("/wallet", middleware.AuthOrAPIKey(authHandler.Tokens, keys))
+	walletGroup.POST("/deposit", middleware.RequireScope(models.ScopeWalletDeposit), wallet.Deposit)
+	walletGroup.POST("/transfer", middleware.RequireScope(models.ScopeWalletTransfer), wallet.Transfer)
+	walletGroup.GET("/me/balance", middleware.RequireScope(models.ScopeWalletRead), wallet.GetBalance)
+	return router, s, twoFactor
+}
+
+// keyRequest sends body, if any, as JSON with the Bearer credential and an
+// optional two-factor code
+func keyRequest(router *gin.Engine, method, path, credential, code string, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		jsonBody, _ := json.Marshal(body)
+		reader = bytes.NewReader(jsonBody)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+credential)
+	if code != "" {
+		req.Header.Set(OTPHeader, code)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+type createdKey struct {
+	Key    string        `json:"key"`
+	APIKey models.APIKey `json:"api_key"`
+}
+
+func TestAPIKeys(t *testing.T) {
+	router, s, _ := apiKeyRouter(t)
+	accessToken := login(t, router).AccessToken
+
+	w := keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, "", map[string]interface{}{"name": "shop", "scopes": []string{"wallet:admin"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"Scopes must be among wallet:read, wallet:deposit, wallet:withdraw, wallet:transfer","code":"invalid_input"}`, w.Body.String())
+	w = keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, "", map[string]interface{}{"name": "shop"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, "", map[string]interface{}{
+		"name": "shop", "scopes": []string{models.ScopeWalletRead, models.ScopeWalletDeposit},
+	})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "key_hash")
+	var created createdKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.True(t, apikey.IsKey(created.Key))
+	assert.Equal(t, []string{models.ScopeWalletDeposit, models.ScopeWalletRead}, created.APIKey.Scopes)
+
+	// the key acts as its owner within its scopes
+	w = keyRequest(router, http.MethodPost, "/wallet/deposit", created.Key, "", map[string]string{"amount": "100"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = keyRequest(router, http.MethodGet, "/wallet/me/balance", created.Key, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"balance":"100.00"}`, w.Body.String())
+	w = keyRequest(router, http.MethodPost, "/wallet/deposit", created.Key, "", map[string]interface{}{"user_id": 2, "amount": "1"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = keyRequest(router, http.MethodPost, "/wallet/transfer", created.Key, "", map[string]interface{}{"to_user_id": 2, "amount": "1"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"API key lacks the required scope","code":"insufficient_scope"}`, w.Body.String())
+
+	// keys cannot manage keys
+	w = keyRequest(router, http.MethodGet, "/me/api-keys", created.Key, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = keyRequest(router, http.MethodGet, "/me/api-keys", accessToken, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Key)
+	var listed struct {
+		APIKeys []models.APIKey `json:"api_keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.APIKeys, 1)
+	assert.Equal(t, created.APIKey.Prefix, listed.APIKeys[0].Prefix)
+	assert.NotNil(t, listed.APIKeys[0].LastUsedAt)
+	assert.Nil(t, listed.APIKeys[0].RevokedAt)
+
+	w = keyRequest(router, http.MethodDelete, "/me/api-keys/99", accessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = keyRequest(router, http.MethodDelete, "/me/api-keys/x", accessToken, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = keyRequest(router, http.MethodDelete, "/me/api-keys/1", accessToken, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = keyRequest(router, http.MethodGet, "/wallet/me/balance", created.Key, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error":"Invalid or revoked API key","code":"unauthorized"}`, w.Body.String())
+
+	balance, _ := s.Balance(context.Background(), 1)
+	assert.Equal(t, "100.00", balance.String())
+}
+
+func TestAPIKeys_TwoFactor(t *testing.T) {
+	router, s, twoFactor := apiKeyRouter(t)
+	ctx := context.Background()
+	accessToken := login(t, router).AccessToken
+	enrollment, err := twoFactor.Enroll(ctx, &models.User{ID: 1, Name: "testuser"})
+	require.NoError(t, err)
+	recoveryCodes, err := twoFactor.Confirm(ctx, 1, "", totpCode(t, enrollment.Secret, 0))
+	require.NoError(t, err)
+
+	body := map[string]interface{}{"name": "payouts", "scopes": []string{models.ScopeWalletDeposit, models.ScopeWalletTransfer}}
+	w := keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, "", body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Two-factor code required to create an API key","code":"mfa_required"}`, w.Body.String())
+	w = keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, "000000", body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, recoveryCodes[0], body)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created createdKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	// scopes replace the step-up code for key requests
+	w = keyRequest(router, http.MethodPost, "/wallet/deposit", created.Key, "", map[string]string{"amount": "100"})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = keyRequest(router, http.MethodPost, "/wallet/transfer", created.Key, "", map[string]interface{}{"to_user_id": 2, "amount": "80"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = keyRequest(router, http.MethodPost, "/wallet/transfer", accessToken, "", map[string]interface{}{"to_user_id": 2, "amount": "60"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	balance, _ := s.Balance(ctx, 2)
+	assert.Equal(t, "80.00", balance.String())
+}
+
+func TestAPIKeys_OutgoingScopesNeedTwoFactor(t *testing.T) {
+	router, _, twoFactor := apiKeyRouter(t)
+	ctx := context.Background()
+	accessToken := login(t, router).AccessToken
+
+	for _, scope := range []string{models.ScopeWalletWithdraw, models.ScopeWalletTransfer} {
+		body := map[string]interface{}{"name": "payouts", "scopes": []string{models.ScopeWalletRead, scope}}
+		for _, code := range []string{"", "123456"} {
+			w := keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, code, body)
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.JSONEq(t, `{"error":"Enable two-factor authentication to create an API key that withdraws or transfers","code":"mfa_required"}`, w.Body.String())
+		}
+	}
+	w := keyRequest(router, http.MethodGet, "/me/api-keys", accessToken, "", nil)
+	assert.JSONEq(t, `{"api_keys":[]}`, w.Body.String())
+
+	enrollment, err := twoFactor.Enroll(ctx, &models.User{ID: 1, Name: "testuser"})
+	require.NoError(t, err)
+	_, err = twoFactor.Confirm(ctx, 1, "", totpCode(t, enrollment.Secret, 0))
+	require.NoError(t, err)
+	body := map[string]interface{}{"name": "payouts", "scopes": []string{models.ScopeWalletTransfer}}
+	w = keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, "", body)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = keyRequest(router, http.MethodPost, "/me/api-keys", accessToken, totpCode(t, enrollment.Secret, 1), body)
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
@@ -2,100 +2,427 @@
 package handlers
 
 import (
-	"database/sql"
-	"log"
+	"errors"
+	"gin-wallet2/lockout"
+	"gin-wallet2/metrics"
+	"gin-wallet2/mfa"
+	"gin-wallet2/models"
+	"gin-wallet2/password"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// loginHistoryLimit attempts returned by GET /me/logins
+const loginHistoryLimit = 50
 
-// AuthHandler auth handler
+// defaultMinPasswordLength minimum password length of NewAuthHandler
+const defaultMinPasswordLength = 8
+
+// AuthHandler auth handler. New passwords must satisfy Policy and are hashed
+// by Hasher. Lockout is optional, without it logins are never locked and no
+// history is kept. Without MFA logins never ask for a two-factor code.
 type AuthHandler struct {
-	DB *sql.DB
+	Users   store.UserStore
+	Tokens  *token.Manager
+	Policy  password.Policy
+	Hasher  password.Hasher
+	Lockout *lockout.Guard
+	MFA     *mfa.Service
+	Metrics *metrics.Metrics
 }
 
-// NewAuthHandler new auth handler
-func NewAuthHandler(db *sql.DB) *AuthHandler {
-	return &AuthHandler{DB: db}
+// NewAuthHandler new auth handler with the default password policy, hashing
+// with bcrypt at bcrypt.DefaultCost
+func NewAuthHandler(users store.UserStore, tokens *token.Manager) *AuthHandler {
+	return &AuthHandler{Users: users, Tokens: tokens, Policy: password.NewPolicy(defaultMinPasswordLength)}
 }
 
-// Register register user
+// Register register user, returns the new user's id. Names are unique
+// ignoring case, a taken name is a 409.
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req struct {
-		Name     string `json:"name" binding:"required"`
+		Name     string `json:"name" binding:"required,max=100"`
 		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		h.fail(c, "register", models.ErrInvalidInput)
+		return
+	}
+	if err := h.Policy.Validate(req.Name, req.Password); err != nil {
+		h.fail(c, "register", err)
 		return
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hash, err := h.hash(c, req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		h.fail(c, "register", internalError("Failed to hash password", err))
 		return
 	}
 
-	log.Println("Name", req.Name, "Password", req.Password, "Password_hash", string(hash))
-
-	// 将用户存储到数据库
-	_, err = h.DB.Exec("INSERT INTO users (name, password_hash) VALUES ($1, $2)", req.Name, string(hash))
+	// 将用户存储到数据库, together with the user's ledger account
+	userID, err := h.Users.CreateUser(c.Request.Context(), req.Name, hash)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		h.fail(c, "register", storeError("Failed to create user", err))
 		return
 	}
+	requestLogger(c).Info().Int("user_id", userID).Msg("User registered")
 
-	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
+	h.Metrics.AuthOperation("register", nil)
+	c.JSON(http.StatusCreated, gin.H{"message": "User created successfully", "id": userID})
 }
 
-// Login login user
+// Login login user. Repeated failures lock the account and the client IP out
+// for a while, every attempt on a known account is kept in its login history.
+// Users with two-factor authentication get a challenge instead of tokens, to
+// be answered with a code at LoginMFA.
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
 		Name     string `json:"name" binding:"required"`
 		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		h.fail(c, "login", models.ErrInvalidInput)
 		return
 	}
 
-	var userID int
-	var passwordHash string
-	err := h.DB.QueryRow("SELECT id, password_hash FROM users WHERE name = $1", req.Name).Scan(&userID, &passwordHash)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+	if h.lockedOut(c, lockout.IPKey(ip)) {
+		return
+	}
+
+	user, err := h.Users.UserByName(ctx, req.Name)
+	if errors.Is(err, models.ErrUserNotFound) {
+		h.loginFailed(c, 0, ip)
+		h.fail(c, "login", models.ErrInvalidCredentials)
 		return
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query user"})
+		h.fail(c, "login", storeError("Failed to query user", err))
+		return
+	}
+
+	if h.lockedOut(c, lockout.UserKey(user.ID)) {
+		h.recordLogin(c, user.ID, models.LoginLocked)
+		return
+	}
+
+	if !h.verify(c, user, req.Password) {
+		h.loginFailed(c, user.ID, ip)
+		h.recordLogin(c, user.ID, models.LoginFailed)
+		h.fail(c, "login", models.ErrInvalidCredentials)
+		return
+	}
+
+	enabled, err := h.MFA.Enabled(ctx, user.ID)
+	if err != nil {
+		h.fail(c, "login", storeError("Failed to check two-factor authentication", err))
+		return
+	}
+	if enabled {
+		// failures are only reset once the code is right too
+		challenge, err := h.Tokens.IssueChallenge(user)
+		if err != nil {
+			h.fail(c, "login", internalError("Failed to generate token", err))
+			return
+		}
+		h.recordLogin(c, user.ID, models.LoginChallenged)
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"challenge":    challenge,
+			"expires_in":   int(h.Tokens.ChallengeTTL / time.Second),
+		})
+		return
+	}
+
+	h.loginSucceeded(c, user)
+}
+
+// LoginMFA second step of a two-factor login, exchanges the challenge from
+// Login and a TOTP or recovery code for a token pair. Wrong codes count as
+// failed logins.
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req struct {
+		Challenge string `json:"challenge" binding:"required"`
+		Code      string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.fail(c, "login", models.ErrInvalidInput)
+		return
+	}
+
+	ctx := c.Request.Context()
+	ip := c.ClientIP()
+	if h.lockedOut(c, lockout.IPKey(ip)) {
+		return
+	}
+	claims, err := h.Tokens.VerifyChallenge(ctx, req.Challenge)
+	if err != nil {
+		h.fail(c, "login", storeError("Failed to verify challenge", err))
+		return
+	}
+	if h.lockedOut(c, lockout.UserKey(claims.UserID)) {
+		h.recordLogin(c, claims.UserID, models.LoginLocked)
 		return
 	}
 
-	if err1 := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err1 != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	if err := h.MFA.Verify(ctx, claims.UserID, ip, req.Code); err != nil {
+		if errors.Is(err, models.ErrInvalidOTP) {
+			h.recordLogin(c, claims.UserID, models.LoginInvalidOTP)
+		}
+		h.fail(c, "login", storeError("Failed to verify two-factor code", err))
+		return
+	}
+	// a challenge answers one login
+	if err := h.Tokens.Logout(ctx, claims); err != nil {
+		h.fail(c, "login", storeError("Failed to revoke challenge", err))
 		return
 	}
 
-	token, err := generateJWT(userID)
+	user, err := h.Users.UserByID(ctx, claims.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		h.fail(c, "login", storeError("Failed to query user", err))
 		return
 	}
+	h.loginSucceeded(c, user)
+}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+// loginSucceeded resets the user's failures and responds with a new session
+func (h *AuthHandler) loginSucceeded(c *gin.Context, user *models.User) {
+	ctx := c.Request.Context()
+	if err := h.Lockout.Succeeded(ctx, user.ID); err != nil {
+		requestLogger(c).Error().Err(err).Msg("Failed to reset login failures")
+	}
+	pair, err := h.Tokens.Issue(ctx, user)
+	if err != nil {
+		h.fail(c, "login", internalError("Failed to generate token", err))
+		return
+	}
+	h.recordLogin(c, user.ID, models.LoginSucceeded)
+
+	h.Metrics.AuthOperation("login", nil)
+	respondTokens(c, pair)
+}
+
+// lockedOut rejects the login with 429 and Retry-After while key is locked.
+// On true the response has already been written.
+func (h *AuthHandler) lockedOut(c *gin.Context, key string) bool {
+	retryAfter, err := h.Lockout.Locked(c.Request.Context(), key)
+	if err != nil {
+		h.fail(c, "login", storeError("Failed to check login lockout", err))
+		return true
+	}
+	if retryAfter <= 0 {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	h.fail(c, "login", models.ErrAccountLocked)
+	return true
 }
 
-// generateJWT generate jwt token
-func generateJWT(userID int) (string, error) {
-	claims := jwt.MapClaims{
-		"userID": userID,
-		"exp":    jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // Token 24 小时有效
+// loginFailed counts the failure, bookkeeping errors are only logged so the
+// client still gets its 401
+func (h *AuthHandler) loginFailed(c *gin.Context, userID int, ip string) {
+	locked, err := h.Lockout.Failed(c.Request.Context(), userID, ip)
+	if err != nil {
+		requestLogger(c).Error().Err(err).Msg("Failed to count login failure")
+	} else if locked {
+		requestLogger(c).Warn().Int("user_id", userID).Str("client_ip", ip).Msg("Login locked after repeated failures")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+}
+
+// recordLogin adds the attempt to the user's login history, errors are only logged
+func (h *AuthHandler) recordLogin(c *gin.Context, userID int, outcome string) {
+	err := h.Lockout.Record(c.Request.Context(), userID, c.ClientIP(), c.Request.UserAgent(), outcome)
+	if err != nil {
+		requestLogger(c).Error().Err(err).Msg("Failed to record login attempt")
+	}
+}
+
+// ChangePassword replaces the caller's password after checking the current
+// one. Every session, including the current one, is revoked and the caller
+// gets a new token pair.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.fail(c, "change_password", models.ErrInvalidInput)
+		return
+	}
+	claims, ok := currentClaims(c)
+	if !ok {
+		h.fail(c, "change_password", models.ErrUnauthorized)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.Users.UserByID(ctx, claims.UserID)
+	if err != nil {
+		h.fail(c, "change_password", storeError("Failed to query user", err))
+		return
+	}
+	if !h.verify(c, user, req.CurrentPassword) {
+		h.fail(c, "change_password", models.ErrWrongPassword)
+		return
+	}
+	if err := h.Policy.Validate(user.Name, req.NewPassword); err != nil {
+		h.fail(c, "change_password", err)
+		return
+	}
+
+	hash, err := h.hash(c, req.NewPassword)
+	if err != nil {
+		h.fail(c, "change_password", internalError("Failed to hash password", err))
+		return
+	}
+	if err := h.Users.UpdatePassword(ctx, user.ID, hash); err != nil {
+		h.fail(c, "change_password", storeError("Failed to update password", err))
+		return
+	}
+	// whoever else knew the old password loses their sessions
+	if err := h.Tokens.LogoutAll(ctx, claims); err != nil {
+		h.fail(c, "change_password", storeError("Failed to revoke sessions", err))
+		return
+	}
+	pair, err := h.Tokens.Issue(ctx, user)
+	if err != nil {
+		h.fail(c, "change_password", internalError("Failed to generate token", err))
+		return
+	}
+	requestLogger(c).Info().Msg("Password changed")
+
+	h.Metrics.AuthOperation("change_password", nil)
+	respondTokens(c, pair)
+}
+
+// hash hashes a new password in its own span
+func (h *AuthHandler) hash(c *gin.Context, pw string) (hash string, err error) {
+	err = traced(c.Request.Context(), "password.Hash", func() error {
+		hash, err = h.Hasher.Hash(pw)
+		return err
+	})
+	return hash, err
+}
+
+// verify checks pw against the user's hash in its own span. A hash made with
+// outdated parameters is replaced, failures to do so are only logged.
+func (h *AuthHandler) verify(c *gin.Context, user *models.User, pw string) bool {
+	var rehash bool
+	err := traced(c.Request.Context(), "password.Verify", func() (err error) {
+		rehash, err = h.Hasher.Verify(user.PasswordHash, pw)
+		return err
+	})
+	if errors.Is(err, password.ErrMismatch) {
+		return false
+	} else if err != nil {
+		requestLogger(c).Error().Err(err).Int("user_id", user.ID).Msg("Unreadable password hash")
+		return false
+	}
+	if rehash {
+		hash, err := h.hash(c, pw)
+		if err == nil {
+			err = h.Users.UpdatePassword(c.Request.Context(), user.ID, hash)
+		}
+		if err != nil {
+			requestLogger(c).Error().Err(err).Int("user_id", user.ID).Msg("Failed to rehash password")
+		}
+	}
+	return true
+}
+
+// Logins returns the caller's most recent login attempts, newest first
+func (h *AuthHandler) Logins(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	logins, err := h.Lockout.History(c.Request.Context(), userID, loginHistoryLimit)
+	if err != nil {
+		respondError(c, storeError("Failed to load login history", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"logins": logins})
+}
+
+// Refresh exchanges a refresh token for a new token pair, the old refresh
+// token can not be used again
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.fail(c, "refresh", models.ErrInvalidInput)
+		return
+	}
+
+	pair, err := h.Tokens.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		h.fail(c, "refresh", storeError("Failed to refresh token", err))
+		return
+	}
+
+	h.Metrics.AuthOperation("refresh", nil)
+	respondTokens(c, pair)
+}
+
+// Logout revokes the caller's current session
+func (h *AuthHandler) Logout(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		h.fail(c, "logout", models.ErrUnauthorized)
+		return
+	}
+
+	if err := h.Tokens.Logout(c.Request.Context(), claims); err != nil {
+		h.fail(c, "logout", storeError("Failed to log out", err))
+		return
+	}
+
+	h.Metrics.AuthOperation("logout", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutAll revokes every session of the caller
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		h.fail(c, "logout_all", models.ErrUnauthorized)
+		return
+	}
+
+	if err := h.Tokens.LogoutAll(c.Request.Context(), claims); err != nil {
+		h.fail(c, "logout_all", storeError("Failed to log out", err))
+		return
+	}
+
+	h.Metrics.AuthOperation("logout_all", nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// respondTokens writes a token pair, "token" repeats the access token for
+// clients written against the single token login response
+func respondTokens(c *gin.Context, pair *token.Pair) {
+	c.JSON(http.StatusOK, gin.H{
+		"token":         pair.AccessToken,
+		"access_token":  pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"token_type":    pair.TokenType,
+		"expires_in":    pair.ExpiresIn,
+	})
+}
+
+// fail records the failed operation and writes the error response
+func (h *AuthHandler) fail(c *gin.Context, operation string, err error) {
+	h.Metrics.AuthOperation(operation, err)
+	respondError(c, err)
 }
@@ -2,14 +2,25 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"gin-wallet2/lockout"
+	"gin-wallet2/metrics"
+	"gin-wallet2/middleware"
+	"gin-wallet2/models"
+	"gin-wallet2/password"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
 	"golang.org/x/crypto/bcrypt"
@@ -19,24 +30,68 @@ func TestMain(m *testing.M) {
 	goleak.VerifyTestMain(m)
 }
 
+// failingUserStore UserStore whose every call fails with err
+type failingUserStore struct {
+	err error
+}
+
+func (s failingUserStore) CreateUser(_ context.Context, _, _ string) (int, error) {
+	return 0, s.err
+}
+
+func (s failingUserStore) UserByName(_ context.Context, _ string) (*models.User, error) {
+	return nil, s.err
+}
+
+func (s failingUserStore) UserByID(_ context.Context, _ int) (*models.User, error) {
+	return nil, s.err
+}
+
+func (s failingUserStore) UpdatePassword(_ context.Context, _ int, _ string) error {
+	return s.err
+}
+
+func (s failingUserStore) UpdateProfile(_ context.Context, _ int, _ models.Profile) error {
+	return s.err
+}
+
+func (s failingUserStore) UpdateRole(_ context.Context, _ int, _ string) error {
+	return s.err
+}
+
+func (s failingUserStore) SearchUsers(_ context.Context, _ string, _ int) ([]models.User, error) {
+	return nil, s.err
+}
+
+// newAuthHandler auth handler over users with an in-memory token store
+func newAuthHandler(users store.UserStore) *AuthHandler {
+	tokens := token.NewManager(token.HMACKeys([]byte("test-secret")), 15*time.Minute, time.Hour, store.NewMemory(), users)
+	return NewAuthHandler(users, tokens)
+}
+
+// newUserStore in-memory store holding testuser with the given password
+func newUserStore(t *testing.T, password string) *store.Memory {
+	t.Helper()
+	s := store.NewMemory()
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if _, err := s.CreateUser(context.Background(), "testuser", string(hashedPassword)); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	return s
+}
+
 func TestRegister(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	users := store.NewMemory()
+	authHandler := newAuthHandler(users)
 
 	router := gin.Default()
 	router.POST("/register", authHandler.Register)
 
-	mock.ExpectExec("INSERT INTO users").WithArgs("testuser", sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-
 	body := map[string]string{
 		"name":     "testuser",
-		"password": "password123",
+		"password": "correct horse battery",
 	}
 	jsonBody, _ := json.Marshal(body)
 
@@ -46,26 +101,48 @@ func TestRegister(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusCreated, w.Code)
-	assert.JSONEq(t, `{"message": "User created successfully"}`, w.Body.String())
+	assert.JSONEq(t, `{"message": "User created successfully", "id": 1}`, w.Body.String())
 
-	assert.NoError(t, mock.ExpectationsWereMet())
+	user, err := users.UserByName(context.Background(), "testuser")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, user.ID)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("correct horse battery")))
 }
 
-func TestLogin(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+func TestRegisterNameTaken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/register", newAuthHandler(newUserStore(t, "password123")).Register)
+
+	w := postJSON(router, "/register", "", map[string]string{"name": "TestUser", "password": "correct horse battery"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"User name already taken","code":"conflict"}`, w.Body.String())
+
+	w = postJSON(router, "/register", "", map[string]string{"name": strings.Repeat("a", 101), "password": "correct horse battery"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRegisterLogsNoSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.Logger(zerolog.New(&logs)))
+	router.POST("/register", newAuthHandler(store.NewMemory()).Register)
+
+	w := postJSON(router, "/register", "", map[string]string{"name": "testuser", "password": "correct horse battery"})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, logs.String(), `"message":"User registered"`)
+	assert.NotContains(t, logs.String(), "correct horse battery")
+	assert.NotContains(t, logs.String(), "$2a$")
+}
 
-	authHandler := NewAuthHandler(db)
+func TestLogin(t *testing.T) {
+	authHandler := newAuthHandler(newUserStore(t, "password123"))
 
 	router := gin.Default()
 	router.POST("/login", authHandler.Login)
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
-	mock.ExpectQuery("SELECT id, password_hash FROM users WHERE name = \\$1").
-		WithArgs("testuser").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "password_hash"}).AddRow(1, string(hashedPassword)))
-
 	body := map[string]string{
 		"name":     "testuser",
 		"password": "password123",
@@ -80,20 +157,35 @@ func TestLogin(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	var respBody map[string]interface{}
-	err = json.Unmarshal(w.Body.Bytes(), &respBody)
+	err := json.Unmarshal(w.Body.Bytes(), &respBody)
 	assert.NoError(t, err)
 	assert.Contains(t, respBody, "token")
+	assert.Contains(t, respBody, "refresh_token")
+	assert.Equal(t, respBody["token"], respBody["access_token"])
+	assert.Equal(t, float64(900), respBody["expires_in"])
+}
+
+func TestLoginMetrics(t *testing.T) {
+	authHandler := newAuthHandler(newUserStore(t, "password123"))
+	authHandler.Metrics = metrics.New()
 
-	assert.NoError(t, mock.ExpectationsWereMet())
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	for _, password := range []string{"password123", "wrong", "password123"} {
+		postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": password})
+	}
+
+	assert.NoError(t, testutil.GatherAndCompare(authHandler.Metrics.Registry, strings.NewReader(`
+# HELP wallet_auth_operations_total Registrations, logins, token refreshes, logouts and password changes by outcome.
+# TYPE wallet_auth_operations_total counter
+wallet_auth_operations_total{operation="login",outcome="success"} 2
+wallet_auth_operations_total{operation="login",outcome="unauthorized"} 1
+`), "wallet_auth_operations_total"))
 }
 
 func TestRegisterInvalidInput(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, _, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	authHandler := newAuthHandler(store.NewMemory())
 	router := gin.Default()
 	router.POST("/register", authHandler.Register)
 
@@ -113,20 +205,13 @@ func TestRegisterInvalidInput(t *testing.T) {
 
 func TestRegisterDBError(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	authHandler := newAuthHandler(failingUserStore{err: sql.ErrConnDone})
 	router := gin.Default()
 	router.POST("/register", authHandler.Register)
 
-	mock.ExpectExec("INSERT INTO users").WithArgs("testuser", sqlmock.AnyArg()).
-		WillReturnError(sql.ErrConnDone)
-
 	body := map[string]string{
 		"name":     "testuser",
-		"password": "password123",
+		"password": "correct horse battery",
 	}
 	jsonBody, _ := json.Marshal(body)
 
@@ -140,11 +225,7 @@ func TestRegisterDBError(t *testing.T) {
 
 func TestLoginInvalidInput(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, _, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	authHandler := newAuthHandler(store.NewMemory())
 	router := gin.Default()
 	router.POST("/login", authHandler.Login)
 
@@ -164,18 +245,10 @@ func TestLoginInvalidInput(t *testing.T) {
 
 func TestLoginUserNotFound(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	authHandler := newAuthHandler(store.NewMemory())
 	router := gin.Default()
 	router.POST("/login", authHandler.Login)
 
-	mock.ExpectQuery("SELECT id, password_hash FROM users WHERE name = \\$1").
-		WithArgs("testuser").
-		WillReturnError(sql.ErrNoRows)
-
 	body := map[string]string{
 		"name":     "testuser",
 		"password": "password123",
@@ -192,20 +265,11 @@ func TestLoginUserNotFound(t *testing.T) {
 
 func TestLoginWrongPassword(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	// stored hash is for a different password
+	authHandler := newAuthHandler(newUserStore(t, "differentpassword"))
 	router := gin.Default()
 	router.POST("/login", authHandler.Login)
 
-	// Generate hash for a different password
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("differentpassword"), bcrypt.DefaultCost)
-	mock.ExpectQuery("SELECT id, password_hash FROM users WHERE name = \\$1").
-		WithArgs("testuser").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "password_hash"}).AddRow(1, string(hashedPassword)))
-
 	body := map[string]string{
 		"name":     "testuser",
 		"password": "password123",
@@ -222,18 +286,10 @@ func TestLoginWrongPassword(t *testing.T) {
 
 func TestLoginDBError(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	authHandler := NewAuthHandler(db)
+	authHandler := newAuthHandler(failingUserStore{err: sql.ErrConnDone})
 	router := gin.Default()
 	router.POST("/login", authHandler.Login)
 
-	mock.ExpectQuery("SELECT id, password_hash FROM users WHERE name = \\$1").
-		WithArgs("testuser").
-		WillReturnError(sql.ErrConnDone)
-
 	body := map[string]string{
 		"name":     "testuser",
 		"password": "password123",
@@ -247,3 +303,265 @@ func TestLoginDBError(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
+
+// sessionRouter router with login, refresh and logout routes behind the real
+// AuthMiddleware
+func sessionRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	authHandler := newAuthHandler(newUserStore(t, "password123"))
+	authRequired := middleware.AuthMiddleware(authHandler.Tokens)
+
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	router.POST("/token/refresh", authHandler.Refresh)
+	router.POST("/logout", authRequired, authHandler.Logout)
+	router.POST("/logout/all", authRequired, authHandler.LogoutAll)
+	router.POST("/me/password", authRequired, authHandler.ChangePassword)
+	router.GET("/protected", authRequired, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return router
+}
+
+func postJSON(router *gin.Engine, path, accessToken string, body interface{}) *httptest.ResponseRecorder {
+	jsonBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func protectedStatus(router *gin.Engine, accessToken string) int {
+	req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func login(t *testing.T, router *gin.Engine) token.Pair {
+	w := postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "password123"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var pair token.Pair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &pair))
+	return pair
+}
+
+func TestRefreshToken(t *testing.T) {
+	router := sessionRouter(t)
+	first := login(t, router)
+
+	w := postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": first.RefreshToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var second token.Pair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.Equal(t, http.StatusNoContent, protectedStatus(router, second.AccessToken))
+
+	// replaying the rotated token revokes the whole family
+	w = postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": first.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error":"Refresh token reuse detected, session revoked","code":"refresh_token_reused"}`, w.Body.String())
+
+	w = postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": second.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, protectedStatus(router, first.AccessToken))
+	assert.Equal(t, http.StatusUnauthorized, protectedStatus(router, second.AccessToken))
+
+	w = postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": "unknown"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(router, "/token/refresh", "", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLogout(t *testing.T) {
+	router := sessionRouter(t)
+	current := login(t, router)
+	other := login(t, router)
+
+	w := postJSON(router, "/logout", current.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, protectedStatus(router, current.AccessToken))
+	w = postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": current.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// other sessions are untouched
+	assert.Equal(t, http.StatusNoContent, protectedStatus(router, other.AccessToken))
+}
+
+func TestLogoutAll(t *testing.T) {
+	router := sessionRouter(t)
+	current := login(t, router)
+	other := login(t, router)
+
+	w := postJSON(router, "/logout/all", current.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, pair := range []token.Pair{current, other} {
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(router, pair.AccessToken))
+		w = postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+}
+
+// lockoutRouter login and login history routes with a guard locking after
+// three failures
+func lockoutRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	users := newUserStore(t, "password123")
+	authHandler := newAuthHandler(users)
+	policy := lockout.Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+	authHandler.Lockout = lockout.NewGuard(users, policy, lockout.Policy{MaxFailures: 5, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour})
+
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	router.GET("/me/logins", middleware.AuthMiddleware(authHandler.Tokens), authHandler.Logins)
+	return router
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Run("account locked after repeated failures", func(t *testing.T) {
+		router := lockoutRouter(t)
+
+		for i := 0; i < 3; i++ {
+			w := postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "wrong"})
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+
+		// even the right password is rejected while locked
+		w := postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "password123"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.JSONEq(t, `{"error":"Too many failed login attempts, try again later","code":"account_locked"}`, w.Body.String())
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("client ip locked after failures on unknown names", func(t *testing.T) {
+		router := lockoutRouter(t)
+
+		for i := 0; i < 5; i++ {
+			w := postJSON(router, "/login", "", map[string]string{"name": "nobody", "password": "wrong"})
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		}
+		w := postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "password123"})
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("success resets the account failures", func(t *testing.T) {
+		router := lockoutRouter(t)
+
+		for _, password := range []string{"wrong", "wrong", "password123", "wrong", "wrong"} {
+			postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": password})
+		}
+		w := postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "password123"})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestLogins(t *testing.T) {
+	router := lockoutRouter(t)
+
+	postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "wrong"})
+	pair := login(t, router)
+
+	req, _ := http.NewRequest(http.MethodGet, "/me/logins", nil)
+	req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Logins []models.LoginAttempt `json:"logins"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Logins, 2) {
+		assert.Equal(t, models.LoginSucceeded, resp.Logins[0].Outcome)
+		assert.Equal(t, models.LoginFailed, resp.Logins[1].Outcome)
+		assert.NotZero(t, resp.Logins[0].CreatedAt)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/me/logins", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/register", newAuthHandler(store.NewMemory()).Register)
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"too short", "Xk9#mQ2", "Password must be at least 8 characters"},
+		{"common", "Password123", "Password is too common"},
+		{"same as name", "TestUser-1", "Password must not be the user name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postJSON(router, "/register", "", map[string]string{"name": "testuser-1", "password": tt.password})
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, `{"error":"`+tt.want+`","code":"weak_password"}`, w.Body.String())
+		})
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	// stored at bcrypt.DefaultCost, the handler now wants argon2id
+	users := newUserStore(t, "password123")
+	authHandler := newAuthHandler(users)
+	authHandler.Hasher = password.Hasher{Algorithm: password.Argon2id, Argon2: password.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}}
+
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	login(t, router)
+
+	user, err := users.UserByName(context.Background(), "testuser")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.PasswordHash, "$argon2id$"), user.PasswordHash)
+
+	// the upgraded hash keeps working and is left alone
+	login(t, router)
+	again, _ := users.UserByName(context.Background(), "testuser")
+	assert.Equal(t, user.PasswordHash, again.PasswordHash)
+}
+
+func TestChangePassword(t *testing.T) {
+	router := sessionRouter(t)
+
+	current := login(t, router)
+	other := login(t, router)
+
+	w := postJSON(router, "/me/password", current.AccessToken, map[string]string{"current_password": "wrong", "new_password": "correct horse battery"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Current password is incorrect","code":"forbidden"}`, w.Body.String())
+
+	w = postJSON(router, "/me/password", current.AccessToken, map[string]string{"current_password": "password123", "new_password": "qwertyuiop"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, "/me/password", current.AccessToken, map[string]string{"current_password": "password123", "new_password": "correct horse battery"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var fresh token.Pair
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &fresh))
+
+	// every earlier session is gone, the caller continues with the new pair
+	for _, pair := range []token.Pair{current, other} {
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(router, pair.AccessToken))
+		w = postJSON(router, "/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	assert.Equal(t, http.StatusNoContent, protectedStatus(router, fresh.AccessToken))
+
+	w = postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "password123"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "correct horse battery"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = postJSON(router, "/me/password", "", map[string]string{"current_password": "x", "new_password": "y"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
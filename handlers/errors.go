@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"gin-wallet2/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// statusByCode HTTP status of each domain error code
+var statusByCode = map[string]int{
+	models.CodeInvalidInput:      http.StatusBadRequest,
+	models.CodeWeakPassword:      http.StatusBadRequest,
+	models.CodeUnauthorized:      http.StatusUnauthorized,
+	models.CodeForbidden:         http.StatusForbidden,
+	models.CodeNotFound:          http.StatusNotFound,
+	models.CodeUserNotFound:      http.StatusNotFound,
+	models.CodeInsufficientFunds: http.StatusBadRequest,
+	models.CodeConflict:          http.StatusConflict,
+	models.CodeTokenReused:       http.StatusUnauthorized,
+	models.CodeRateLimited:       http.StatusTooManyRequests,
+	models.CodeAccountLocked:     http.StatusTooManyRequests,
+	models.CodeMFARequired:       http.StatusForbidden,
+	models.CodeInvalidOTP:        http.StatusForbidden,
+	models.CodeInsufficientScope: http.StatusForbidden,
+	models.CodeBalanceLimit:      http.StatusUnprocessableEntity,
+}
+
+// internalErr unexpected failure, the message is shown to the client and the
+// cause is only logged
+type internalErr struct {
+	message string
+	cause   error
+}
+
+func (e *internalErr) Error() string {
+	return e.message + ": " + e.cause.Error()
+}
+
+func (e *internalErr) Unwrap() error {
+	return e.cause
+}
+
+// internalError wraps an unexpected error, it is reported as 500
+func internalError(message string, cause error) error {
+	return &internalErr{message: message, cause: cause}
+}
+
+// storeError passes nil, domain and already wrapped errors through and wraps
+// any other store failure as an internal error with message
+func storeError(message string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var domainErr *models.Error
+	var internal *internalErr
+	if errors.As(err, &domainErr) || errors.As(err, &internal) {
+		return err
+	}
+	return internalError(message, err)
+}
+
+// respondError writes the JSON error response for err. Domain errors map to
+// their status and code, everything else is logged and reported as 500.
+func respondError(c *gin.Context, err error) {
+	var domainErr *models.Error
+	if errors.As(err, &domainErr) {
+		status, ok := statusByCode[domainErr.Code]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": domainErr.Message, "code": domainErr.Code})
+		return
+	}
+
+	message := "Internal server error"
+	var internal *internalErr
+	if errors.As(err, &internal) {
+		message = internal.message
+	}
+	requestLogger(c).Error().Err(err).Msg(message)
+	_ = c.Error(err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message, "code": models.CodeInternal})
+}
+
+// requestLogger logger from the request context, falling back to
+// zerolog.DefaultContextLogger
+func requestLogger(c *gin.Context) *zerolog.Logger {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	return zerolog.Ctx(ctx)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"gin-wallet2/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRespondError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "insufficient funds",
+			err:            models.ErrInsufficientFunds,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"Insufficient balance","code":"insufficient_funds"}`,
+		},
+		{
+			name:           "wrapped user not found",
+			err:            fmt.Errorf("lookup recipient: %w", models.ErrUserNotFound),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"User not found","code":"user_not_found"}`,
+		},
+		{
+			name:           "conflict",
+			err:            models.ErrConflict,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"Conflict","code":"conflict"}`,
+		},
+		{
+			name:           "internal error keeps its message",
+			err:            internalError("Failed to commit transaction", errors.New("connection reset")),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"Failed to commit transaction","code":"internal_error"}`,
+		},
+		{
+			name:           "unknown error is not leaked",
+			err:            errors.New("pq: relation does not exist"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"Internal server error","code":"internal_error"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			respondError(c, tt.err)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Check a dependency probed by /readyz, nil means ready
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// CheckResult outcome of one readiness check
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// HealthHandler liveness and readiness probes
+type HealthHandler struct {
+	Checks  []Check
+	Timeout time.Duration
+}
+
+// NewHealthHandler new health handler, every check gets at most timeout
+func NewHealthHandler(timeout time.Duration, checks ...Check) *HealthHandler {
+	return &HealthHandler{Checks: checks, Timeout: timeout}
+}
+
+// Live the process is up and serving requests
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready runs every check concurrently and answers 503 if any of them fails
+func (h *HealthHandler) Ready(c *gin.Context) {
+	results := make(map[string]CheckResult, len(h.Checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range h.Checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), h.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			res := CheckResult{Status: "ok"}
+			if err := check.Check(ctx); err != nil {
+				res = CheckResult{Status: "error", Error: err.Error()}
+			}
+			res.DurationMS = time.Since(start).Milliseconds()
+
+			mu.Lock()
+			results[check.Name] = res
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, res := range results {
+		if res.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{"status": status, "checks": results})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func okCheck(context.Context) error { return nil }
+
+func TestHealthHandler_Live(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewHealthHandler(time.Second, Check{Name: "database", Check: func(context.Context) error {
+		return errors.New("down")
+	}})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	handler.Live(c)
+
+	// liveness does not depend on the database
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestHealthHandler_Ready(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantStatus int
+		want       string
+		wantErrors map[string]string
+	}{
+		{
+			name:       "all ready",
+			checks:     []Check{{Name: "database", Check: okCheck}, {Name: "migrations", Check: okCheck}},
+			wantStatus: http.StatusOK,
+			want:       "ok",
+		},
+		{
+			name: "failing check",
+			checks: []Check{{Name: "database", Check: okCheck}, {Name: "migrations", Check: func(context.Context) error {
+				return errors.New("at version 5, expected 7")
+			}}},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       "unavailable",
+			wantErrors: map[string]string{"migrations": "at version 5, expected 7"},
+		},
+		{
+			name: "check timed out",
+			checks: []Check{{Name: "database", Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}}},
+			wantStatus: http.StatusServiceUnavailable,
+			want:       "unavailable",
+			wantErrors: map[string]string{"database": context.DeadlineExceeded.Error()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHealthHandler(20*time.Millisecond, tt.checks...)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			handler.Ready(c)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			var response struct {
+				Status string                 `json:"status"`
+				Checks map[string]CheckResult `json:"checks"`
+			}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.want, response.Status)
+			assert.Len(t, response.Checks, len(tt.checks))
+			for _, check := range tt.checks {
+				res := response.Checks[check.Name]
+				if msg, ok := tt.wantErrors[check.Name]; ok {
+					assert.Equal(t, "error", res.Status)
+					assert.Equal(t, msg, res.Error)
+				} else {
+					assert.Equal(t, "ok", res.Status, check.Name)
+				}
+			}
+		})
+	}
+}
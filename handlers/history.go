@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"gin-wallet2/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyLimit default and maximum page size of the transaction history
+const (
+	historyLimit    = 50
+	maxHistoryLimit = 100
+)
+
+// dateLayout day without time, from and to also accept it
+const dateLayout = "2006-01-02"
+
+// GetTransactions get a page of the transaction history of the caller, or of
+// :userID on admin routes. Filters: ?type= (repeated or comma separated),
+// ?min_amount=, ?max_amount=, ?from= and ?to= (RFC 3339 or a date, to is
+// exclusive and a date includes that day); ?order=asc|desc, ?limit= and
+// ?cursor= from the previous page's next_cursor.
+func (h *WalletHandler) GetTransactions(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+	q, err := parseHistoryQuery(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	limit := q.Limit
+	// one more row tells whether there is a next page
+	q.Limit++
+	transactions, err := h.Store.Transactions(ctx, userID, q)
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+	total, err := h.Store.CountTransactions(ctx, userID, q.TransactionFilter)
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+
+	var nextCursor *string
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+		cursor := encodeCursor(transactions[limit-1])
+		nextCursor = &cursor
+	}
+	c.JSON(http.StatusOK, gin.H{"transactions": transactions, "total": total, "next_cursor": nextCursor})
+}
+
+// GetTransaction get the caller's transaction :id, or :userID's on admin
+// routes, with its counterparty and the balance after it
+func (h *WalletHandler) GetTransaction(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		respondError(c, models.ErrTransactionNotFound)
+		return
+	}
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+
+	transaction, err := h.Store.Transaction(c.Request.Context(), userID, id)
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transaction": transaction})
+}
+
+// parseHistoryQuery reads the filters, order and page of GetTransactions
+func parseHistoryQuery(c *gin.Context) (models.TransactionQuery, error) {
+	q := models.TransactionQuery{Limit: historyLimit}
+
+	for _, v := range c.QueryArray("type") {
+		for _, t := range strings.Split(v, ",") {
+			if !models.ValidHistoryType(t) {
+				return q, models.ErrInvalidInput
+			}
+			q.Types = append(q.Types, t)
+		}
+	}
+	for param, dest := range map[string]**models.Money{"min_amount": &q.MinAmount, "max_amount": &q.MaxAmount} {
+		if v := c.Query(param); v != "" {
+			amount, err := models.ParseMoney(v)
+			if err != nil {
+				return q, models.ErrInvalidInput
+			}
+			*dest = &amount
+		}
+	}
+	for param, dest := range map[string]**time.Time{"from": &q.From, "to": &q.To} {
+		if v := c.Query(param); v != "" {
+			t, err := parseHistoryTime(v, param == "to")
+			if err != nil {
+				return q, models.ErrInvalidInput
+			}
+			*dest = &t
+		}
+	}
+	if q.MinAmount != nil && q.MaxAmount != nil && q.MinAmount.Cmp(*q.MaxAmount) > 0 {
+		return q, models.ErrInvalidInput
+	}
+
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		q.Ascending = true
+	case "desc":
+	default:
+		return q, models.ErrInvalidInput
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHistoryLimit {
+			return q, models.ErrInvalidInput
+		}
+		q.Limit = n
+	}
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return q, err
+		}
+		q.After = cursor
+	}
+	return q, nil
+}
+
+// parseHistoryTime parses an RFC 3339 time or a date, which as the end of a
+// range means the start of the next day
+func parseHistoryTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(dateLayout, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// encodeCursor opaque cursor continuing after t
+func encodeCursor(t models.Transaction) string {
+	raw := strconv.FormatInt(t.CreatedAt.UnixNano(), 10) + "." + strconv.Itoa(t.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor, models.ErrInvalidCursor if v is malformed
+func decodeCursor(v string) (*models.TransactionCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, models.ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, models.ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, models.ErrInvalidCursor
+	}
+	i, err := strconv.Atoi(id)
+	if err != nil || i <= 0 {
+		return nil, models.ErrInvalidCursor
+	}
+	return &models.TransactionCursor{CreatedAt: time.Unix(0, n).UTC(), ID: i}, nil
+}
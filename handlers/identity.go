@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"gin-wallet2/models"
+	"gin-wallet2/token"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentUserID returns the authenticated user id set by AuthMiddleware
+func currentUserID(c *gin.Context) (int, bool) {
+	v, exists := c.Get("userID")
+	userID, ok := v.(int)
+	return userID, exists && ok && userID > 0
+}
+
+// currentClaims returns the verified access token claims set by AuthMiddleware
+func currentClaims(c *gin.Context) (*token.Claims, bool) {
+	v, _ := c.Get("tokenClaims")
+	claims, ok := v.(*token.Claims)
+	return claims, ok && claims != nil
+}
+
+// actingUserID resolves the account a request acts on. requested is the id
+// supplied in the body or path, 0 meaning the caller's own account. Acting on
+// another account is only allowed on admin override routes. On failure the
+// response has already been written.
+func actingUserID(c *gin.Context, requested int) (int, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return 0, false
+	}
+	if requested == 0 || requested == userID {
+		return userID, true
+	}
+	if c.GetBool("adminOverride") {
+		return requested, true
+	}
+	respondError(c, models.ErrForbidden)
+	return 0, false
+}
+
+// pathUserID resolves the account from the :userID path param, /me routes
+// have no param and resolve to the caller
+func pathUserID(c *gin.Context) (int, bool) {
+	param := c.Param("userID")
+	if param == "" {
+		return actingUserID(c, 0)
+	}
+	requested, err := strconv.Atoi(param)
+	if err != nil || requested <= 0 {
+		respondError(c, models.ErrInvalidUserID)
+		return 0, false
+	}
+	return actingUserID(c, requested)
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jwksMaxAge seconds verifiers may cache the key set. A new key must be
+// published at least this long before it starts signing.
+const jwksMaxAge = 300
+
+// JWKS serves the public token verification keys as a JSON Web Key Set, for
+// services that verify access tokens but must not be able to issue them
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(jwksMaxAge))
+	c.JSON(http.StatusOK, h.Tokens.Keys.JWKS())
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("asymmetric keys are published", func(t *testing.T) {
+		private, _, kid, err := token.GenerateKey(token.AlgEdDSA)
+		require.NoError(t, err)
+		file := filepath.Join(t.TempDir(), "signing.pem")
+		require.NoError(t, os.WriteFile(file, private, 0o600))
+		keys, err := token.LoadKeys(file)
+		require.NoError(t, err)
+
+		users := store.NewMemory()
+		authHandler := NewAuthHandler(users, token.NewManager(keys, 15*time.Minute, time.Hour, users, users))
+		router := gin.New()
+		router.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+		var jwks token.JWKS
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+		if assert.Len(t, jwks.Keys, 1) {
+			assert.Equal(t, kid, jwks.Keys[0].KeyID)
+			assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+			assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+		}
+		assert.NotContains(t, w.Body.String(), `"d"`)
+	})
+
+	t.Run("HS256 secrets are not", func(t *testing.T) {
+		authHandler := newAuthHandler(store.NewMemory())
+		router := gin.New()
+		router.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// postingsLimit postings returned by GET /admin/wallet/postings/:userID
+const postingsLimit = 100
+
+// VerifyLedger reports journal entries that do not sum to zero and wallets
+// whose users.balance differs from the sum of their postings
+func (h *WalletHandler) VerifyLedger(c *gin.Context) {
+	report, err := h.Store.VerifyLedger(c.Request.Context())
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"balanced":            report.Balanced(),
+		"unbalanced_entries":  report.UnbalancedEntries,
+		"mismatched_balances": report.MismatchedBalances,
+	})
+}
+
+// VerifyHistory replays every wallet's transaction history and reports the
+// wallets it does not end at the balance of, or whose recorded balances drift
+// from the replay
+func (h *WalletHandler) VerifyHistory(c *gin.Context) {
+	report, err := h.Store.VerifyHistory(c.Request.Context())
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"consistent":           report.Consistent(),
+		"mismatched_histories": report.Mismatches,
+		"unverified_wallets":   report.Unverified,
+		"legacy_rows":          report.LegacyRows,
+	})
+}
+
+// Postings lists the most recent postings to :userID's ledger account with
+// the journal entries they belong to
+func (h *WalletHandler) Postings(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
+	}
+
+	postings, err := h.Store.Postings(c.Request.Context(), userID, postingsLimit)
+	if err != nil {
+		respondError(c, storeError("Database error", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"postings": postings})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalletHandler_VerifyLedger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("ledger in balance", func(t *testing.T) {
+		handler := NewWalletHandler(newTestStore(t, "100", "20"))
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		handler.VerifyLedger(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"balanced":true,"unbalanced_entries":[],"mismatched_balances":[]}`, w.Body.String())
+	})
+
+	t.Run("drift reported", func(t *testing.T) {
+		s := newTestStore(t, "100")
+		// a balance change with no matching postings
+		ctx := context.Background()
+		err := s.WithinTx(ctx, func(tx store.WalletTx) error {
+			_, err := tx.AdjustBalance(ctx, 1, models.MustParseMoney("-10"))
+			return err
+		})
+		assert.NoError(t, err)
+		handler := NewWalletHandler(s)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		handler.VerifyLedger(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, false, response["balanced"])
+		assert.Equal(t, []interface{}{}, response["unbalanced_entries"])
+		assert.Equal(t, []interface{}{map[string]interface{}{
+			"user_id": float64(1), "balance": "90.00", "ledger_balance": "100.00",
+		}}, response["mismatched_balances"])
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		handler := NewWalletHandler(&faultyStore{Memory: store.NewMemory(), failOn: "VerifyLedger", err: sql.ErrConnDone})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		handler.VerifyLedger(c)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestWalletHandler_VerifyHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	verify := func(h *WalletHandler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		h.VerifyHistory(c)
+		return w
+	}
+
+	s := historyStore(t)
+	w := verify(NewWalletHandler(s))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"consistent":true,"mismatched_histories":[],"unverified_wallets":[],"legacy_rows":0}`, w.Body.String())
+
+	// a balance change without a history row
+	ctx := context.Background()
+	err := s.WithinTx(ctx, func(tx store.WalletTx) error {
+		_, err := tx.AdjustBalance(ctx, 2, models.MustParseMoney("7"))
+		return err
+	})
+	assert.NoError(t, err)
+	w = verify(NewWalletHandler(s))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"consistent":false,"mismatched_histories":[{"user_id":2,"balance":"12.00","replayed_balance":"5.00"}],"unverified_wallets":[],"legacy_rows":0}`, w.Body.String())
+
+	w = verify(NewWalletHandler(&faultyStore{Memory: s, failOn: "VerifyHistory", err: sql.ErrConnDone}))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestWalletHandler_Postings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewWalletHandler(historyStore(t))
+	postings := func(userID string, admin bool) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("userID", 1); c.Set("adminOverride", admin) })
+		router.GET("/postings/:userID", handler.Postings)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/postings/"+userID, nil))
+		return w
+	}
+
+	w := postings("2", true)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Postings []models.WalletPosting `json:"postings"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Postings, 1) {
+		assert.Equal(t, models.TxTypeTransfer, response.Postings[0].Kind)
+		assert.Equal(t, "5.00", response.Postings[0].Amount.String())
+	}
+
+	w = postings("1", true)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Postings, 6) {
+		assert.Equal(t, "-5.00", response.Postings[0].Amount.String())
+		assert.Equal(t, "10.00", response.Postings[5].Amount.String())
+	}
+
+	assert.Equal(t, http.StatusForbidden, postings("2", false).Code)
+	assert.Equal(t, http.StatusNotFound, postings("99", true).Code)
+	assert.Equal(t, http.StatusBadRequest, postings("x", true).Code)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"gin-wallet2/mfa"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MFAHandler two-factor enrollment of the caller
+type MFAHandler struct {
+	MFA   *mfa.Service
+	Users store.UserStore
+}
+
+// NewMFAHandler new two-factor handler
+func NewMFAHandler(s *mfa.Service, users store.UserStore) *MFAHandler {
+	return &MFAHandler{MFA: s, Users: users}
+}
+
+// Enroll starts enrollment with a new secret and returns it with its otpauth
+// URI, starting again replaces a pending secret
+func (h *MFAHandler) Enroll(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.Users.UserByID(ctx, userID)
+	if err != nil {
+		respondError(c, storeError("Failed to query user", err))
+		return
+	}
+	enrollment, err := h.MFA.Enroll(ctx, user)
+	if err != nil {
+		respondError(c, storeError("Failed to start two-factor enrollment", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm enables two-factor authentication with a first code from the
+// authenticator app and returns the recovery codes, shown only this once
+func (h *MFAHandler) Confirm(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	codes, err := h.MFA.Confirm(c.Request.Context(), userID, c.ClientIP(), req.Code)
+	if err != nil {
+		respondError(c, storeError("Failed to confirm two-factor authentication", err))
+		return
+	}
+	requestLogger(c).Info().Msg("Two-factor authentication enabled")
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Disable turns two-factor authentication off, it takes a current TOTP or
+// recovery code
+func (h *MFAHandler) Disable(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	if err := h.MFA.Disable(c.Request.Context(), userID, c.ClientIP(), req.Code); err != nil {
+		respondError(c, storeError("Failed to disable two-factor authentication", err))
+		return
+	}
+	requestLogger(c).Info().Msg("Two-factor authentication disabled")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
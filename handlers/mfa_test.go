@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gin-wallet2/mfa"
+	"gin-wallet2/middleware"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mfaRouter login and two-factor routes for testuser
+func mfaRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	users := newUserStore(t, "password123")
+	authHandler := newAuthHandler(users)
+	authHandler.MFA = mfa.NewService(users, "gin-wallet")
+	mfaHandler := NewMFAHandler(authHandler.MFA, users)
+	authRequired := middleware.AuthMiddleware(authHandler.Tokens)
+
+	router := gin.New()
+	router.POST("/login", authHandler.Login)
+	router.POST("/login/2fa", authHandler.LoginMFA)
+	router.POST("/me/2fa", authRequired, mfaHandler.Enroll)
+	router.POST("/me/2fa/confirm", authRequired, mfaHandler.Confirm)
+	router.POST("/me/2fa/disable", authRequired, mfaHandler.Disable)
+	router.GET("/protected", authRequired, func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return router
+}
+
+// totpCode code of secret steps time steps from now
+func totpCode(t *testing.T, secret string, steps int64) string {
+	code, err := mfa.Code(secret, mfa.Counter(time.Now())+steps)
+	require.NoError(t, err)
+	return code
+}
+
+// challenge logs testuser in and returns the two-factor challenge
+func challenge(t *testing.T, router *gin.Engine) string {
+	w := postJSON(router, "/login", "", map[string]string{"name": "testuser", "password": "password123"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, true, resp["mfa_required"])
+	assert.Equal(t, float64(300), resp["expires_in"])
+	assert.NotContains(t, resp, "access_token")
+	return resp["challenge"].(string)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	router := mfaRouter(t)
+	accessToken := login(t, router).AccessToken
+
+	w := postJSON(router, "/me/2fa", accessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var enrollment mfa.Enrollment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	assert.Equal(t, mfa.URI("gin-wallet", "testuser", enrollment.Secret), enrollment.URI)
+
+	// tokens until confirmed
+	login(t, router)
+
+	w = postJSON(router, "/me/2fa/confirm", accessToken, map[string]string{"code": "000000"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Invalid two-factor code","code":"invalid_otp"}`, w.Body.String())
+
+	w = postJSON(router, "/me/2fa/confirm", accessToken, map[string]string{"code": totpCode(t, enrollment.Secret, 0)})
+	require.Equal(t, http.StatusOK, w.Code)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &confirmed))
+	assert.Len(t, confirmed.RecoveryCodes, mfa.RecoveryCodes)
+
+	w = postJSON(router, "/me/2fa", accessToken, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	t.Run("totp code", func(t *testing.T) {
+		c := challenge(t, router)
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(router, c), "challenge is no access token")
+
+		w := postJSON(router, "/login/2fa", "", map[string]string{"challenge": c, "code": "000000"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = postJSON(router, "/login/2fa", "", map[string]string{"challenge": c, "code": totpCode(t, enrollment.Secret, 1)})
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, http.StatusNoContent, protectedStatus(router, resp["access_token"].(string)))
+
+		// the challenge answers one login
+		w = postJSON(router, "/login/2fa", "", map[string]string{"challenge": c, "code": confirmed.RecoveryCodes[0]})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		w := postJSON(router, "/login/2fa", "", map[string]string{"challenge": challenge(t, router), "code": confirmed.RecoveryCodes[0]})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = postJSON(router, "/login/2fa", "", map[string]string{"challenge": challenge(t, router), "code": confirmed.RecoveryCodes[0]})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		w := postJSON(router, "/me/2fa/disable", accessToken, map[string]string{"code": "000000"})
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w = postJSON(router, "/me/2fa/disable", accessToken, map[string]string{"code": confirmed.RecoveryCodes[1]})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		login(t, router)
+		w = postJSON(router, "/me/2fa/disable", accessToken, map[string]string{"code": confirmed.RecoveryCodes[2]})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+}
+
+func TestLoginMFAInvalidInput(t *testing.T) {
+	router := mfaRouter(t)
+
+	w := postJSON(router, "/login/2fa", "", map[string]string{"challenge": "x"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, "/login/2fa", "", map[string]string{"challenge": "not a token", "code": "123456"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error":"Invalid or expired token","code":"unauthorized"}`, w.Body.String())
+}
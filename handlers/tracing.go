@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer("gin-wallet2/handlers")
+}
+
+// annotateSpan tags the request span with the wallet operation and the
+// account it acts on
+func annotateSpan(ctx context.Context, operation string, userID int, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(append(attrs,
+		attribute.String("wallet.operation", operation),
+		attribute.Int("wallet.user_id", userID))...)
+}
+
+// traced runs fn in a child span named name, for CPU heavy steps such as
+// password hashing that would otherwise hide between store spans
+func traced(ctx context.Context, name string, fn func() error) error {
+	_, span := tracer().Start(ctx, name)
+	defer span.End()
+	return fn()
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandler the caller's own profile
+type UserHandler struct {
+	Users store.UserStore
+}
+
+// NewUserHandler new user handler
+func NewUserHandler(users store.UserStore) *UserHandler {
+	return &UserHandler{Users: users}
+}
+
+// profileResponse body of GET and PATCH /me
+type profileResponse struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	models.Profile
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newProfileResponse(u *models.User) profileResponse {
+	return profileResponse{ID: u.ID, Name: u.Name, Profile: u.Profile, CreatedAt: u.CreatedAt}
+}
+
+// Me returns the caller's profile
+func (h *UserHandler) Me(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	user, err := h.Users.UserByID(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, storeError("Failed to query user", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, newProfileResponse(user))
+}
+
+// UpdateMe changes the profile fields present in the body, an empty string
+// clears a field
+func (h *UserHandler) UpdateMe(c *gin.Context) {
+	var req struct {
+		DisplayName *string `json:"display_name"`
+		Email       *string `json:"email"`
+		Phone       *string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, models.ErrInvalidInput)
+		return
+	}
+	userID, ok := currentUserID(c)
+	if !ok {
+		respondError(c, models.ErrUnauthorized)
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.Users.UserByID(ctx, userID)
+	if err != nil {
+		respondError(c, storeError("Failed to query user", err))
+		return
+	}
+
+	profile := user.Profile
+	if req.DisplayName != nil {
+		profile.DisplayName = *req.DisplayName
+	}
+	if req.Email != nil {
+		profile.Email = *req.Email
+	}
+	if req.Phone != nil {
+		profile.Phone = *req.Phone
+	}
+	profile, err = profile.Normalize()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if err := h.Users.UpdateProfile(ctx, userID, profile); err != nil {
+		respondError(c, storeError("Failed to update profile", err))
+		return
+	}
+	user.Profile = profile
+
+	c.JSON(http.StatusOK, newProfileResponse(user))
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gin-wallet2/middleware"
+	"gin-wallet2/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func profileRouter(t *testing.T) (*gin.Engine, string) {
+	gin.SetMode(gin.TestMode)
+	users := newUserStore(t, "password123")
+	auth := newAuthHandler(users)
+	profile := NewUserHandler(users)
+	authRequired := middleware.AuthMiddleware(auth.Tokens)
+
+	router := gin.New()
+	router.POST("/login", auth.Login)
+	router.GET("/me", authRequired, profile.Me)
+	router.PATCH("/me", authRequired, profile.UpdateMe)
+	return router, login(t, router).AccessToken
+}
+
+func doProfile(router *gin.Engine, method, accessToken, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "/me", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMe(t *testing.T) {
+	router, accessToken := profileRouter(t)
+
+	w := doProfile(router, http.MethodGet, accessToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, float64(1), resp["id"])
+	assert.Equal(t, "testuser", resp["name"])
+	assert.Equal(t, "", resp["email"])
+	assert.NotContains(t, resp, "password_hash")
+	assert.NotContains(t, resp, "balance")
+
+	assert.Equal(t, http.StatusUnauthorized, doProfile(router, http.MethodGet, "", "").Code)
+}
+
+func TestUpdateMe(t *testing.T) {
+	router, accessToken := profileRouter(t)
+
+	w := doProfile(router, http.MethodPatch, accessToken, `{"display_name":" Test User ","email":"Test@Example.com","phone":"+49 151 1234567"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got struct {
+		models.Profile
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, models.Profile{DisplayName: "Test User", Email: "Test@example.com", Phone: "+491511234567"}, got.Profile)
+
+	// absent fields are kept, empty ones cleared
+	w = doProfile(router, http.MethodPatch, accessToken, `{"phone":""}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doProfile(router, http.MethodGet, accessToken, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, models.Profile{DisplayName: "Test User", Email: "Test@example.com"}, got.Profile)
+
+	tests := []struct {
+		name string
+		body string
+		want *models.Error
+	}{
+		{"invalid email", `{"email":"not-an-email"}`, models.ErrInvalidEmail},
+		{"invalid phone", `{"phone":"12345"}`, models.ErrInvalidPhone},
+		{"malformed body", `{"email":`, models.ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doProfile(router, http.MethodPatch, accessToken, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.want.Message)
+		})
+	}
+
+	// rejected updates change nothing
+	w = doProfile(router, http.MethodGet, accessToken, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "Test@example.com", got.Email)
+}
+
+func TestUpdateMeStoreError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/me", func(c *gin.Context) { c.Set("userID", 1) }, NewUserHandler(failingUserStore{err: context.DeadlineExceeded}).UpdateMe)
+
+	w := doProfile(router, http.MethodPatch, "", `{"email":"a@example.com"}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -1,72 +1,89 @@
 package handlers
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"gin-wallet2/metrics"
+	"gin-wallet2/mfa"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var zlog zerolog.Logger
+// OTPHeader carries the two-factor code for withdrawals and transfers above
+// the step-up amount
+const OTPHeader = "X-OTP-Code"
 
-// WalletHandler wallet handler
+// WalletHandler wallet handler. Withdrawals and transfers above StepUpAmount
+// need a two-factor code of the caller, a zero amount or nil MFA turns that off.
 type WalletHandler struct {
-	DB *sql.DB
+	Store        store.WalletStore
+	MFA          *mfa.Service
+	StepUpAmount models.Money
+	Metrics      *metrics.Metrics
 }
 
 // NewWalletHandler new wallet handler
-func NewWalletHandler(db *sql.DB) *WalletHandler {
-	return &WalletHandler{DB: db}
+func NewWalletHandler(s store.WalletStore) *WalletHandler {
+	return &WalletHandler{Store: s}
 }
 
 // Deposit deposit money to wallet
 func (h *WalletHandler) Deposit(c *gin.Context) {
 	var req struct {
-		UserID int     `json:"user_id"`
-		Amount float64 `json:"amount"`
+		UserID int          `json:"user_id"`
+		Amount models.Money `json:"amount"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Amount.IsPositive() {
+		respondError(c, models.ErrInvalidInput)
 		return
 	}
 
-	tx, err := h.DB.Begin()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+	userID, ok := actingUserID(c, req.UserID)
+	if !ok {
 		return
 	}
 
-	_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE id = $2", req.Amount, req.UserID)
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
+	ctx := c.Request.Context()
+	annotateSpan(ctx, models.TxTypeDeposit, userID)
+	err := h.Store.WithinTx(ctx, func(tx store.WalletTx) error {
+		balances, err := tx.LockBalances(ctx, userID)
+		if err != nil {
+			return storeError("Failed to query balance", err)
+		}
+		if !balances[userID].Add(req.Amount).InRange() {
+			return models.ErrBalanceLimit
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance"})
-		return
-	}
 
-	_, err = tx.Exec("INSERT INTO transactions (user_id, type, amount, description) VALUES ($1, $2, $3, $4)",
-		req.UserID, "deposit", req.Amount, "Deposit to wallet")
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
+		balance, err := tx.AdjustBalance(ctx, userID, req.Amount)
+		if err != nil {
+			return storeError("Failed to update balance", err)
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record transaction"})
-		return
-	}
 
-	if err := tx.Commit(); err != nil {
-		zlog.Fatal().
-			Err(err).
-			Msg("Failed to commit transaction")
-		log.Printf(": %v", err)
+		entryID, err := tx.PostEntry(ctx, models.JournalEntry{
+			Kind:        models.TxTypeDeposit,
+			Description: "Deposit to wallet",
+			Postings: []models.Posting{
+				{Account: models.CashAccount, Amount: req.Amount.Neg()},
+				{Account: models.UserAccount(userID), Amount: req.Amount},
+			},
+		})
+		if err != nil {
+			return storeError("Failed to post ledger entry", err)
+		}
+
+		err = tx.RecordTransactions(ctx, models.Transaction{
+			UserID: userID, Type: models.TxTypeDeposit, Amount: req.Amount, Description: "Deposit to wallet", EntryID: entryID,
+		}.WithBalance(balance))
+		return storeError("Failed to record transaction", err)
+	})
+	h.Metrics.WalletOperation(models.TxTypeDeposit, req.Amount, err)
+	if err != nil {
+		respondError(c, storeError("Failed to complete transaction", err))
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Deposit successful"})
@@ -75,60 +92,62 @@ func (h *WalletHandler) Deposit(c *gin.Context) {
 // Withdraw withdraw money from wallet
 func (h *WalletHandler) Withdraw(c *gin.Context) {
 	var req struct {
-		UserID int     `json:"user_id"`
-		Amount float64 `json:"amount"`
+		UserID int          `json:"user_id"`
+		Amount models.Money `json:"amount"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Amount.IsPositive() {
+		respondError(c, models.ErrInvalidInput)
 		return
 	}
 
-	tx, err := h.DB.Begin()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+	userID, ok := actingUserID(c, req.UserID)
+	if !ok {
 		return
 	}
 
-	var balance float64
-	err = tx.QueryRow("SELECT balance FROM users WHERE id = $1", req.UserID).Scan(&balance)
-	if err != nil || balance < req.Amount {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+	if err := h.stepUp(c, req.Amount); err != nil {
+		h.Metrics.WalletOperation(models.TxTypeWithdraw, req.Amount, err)
+		respondError(c, err)
 		return
 	}
 
-	_, err = tx.Exec("UPDATE users SET balance = balance - $1 WHERE id = $2", req.Amount, req.UserID)
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
+	ctx := c.Request.Context()
+	annotateSpan(ctx, models.TxTypeWithdraw, userID)
+	err := h.Store.WithinTx(ctx, func(tx store.WalletTx) error {
+		balances, err := tx.LockBalances(ctx, userID)
+		if err != nil {
+			return storeError("Failed to query balance", err)
+		}
+		if balances[userID].Cmp(req.Amount) < 0 {
+			return models.ErrInsufficientFunds
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update balance"})
-		return
-	}
 
-	_, err = tx.Exec("INSERT INTO transactions (user_id, type, amount, description) VALUES ($1, $2, $3, $4)",
-		req.UserID, "withdraw", req.Amount, "Withdraw from wallet")
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
+		balance, err := tx.AdjustBalance(ctx, userID, req.Amount.Neg())
+		if err != nil {
+			return storeError("Failed to update balance", err)
+		}
+
+		entryID, err := tx.PostEntry(ctx, models.JournalEntry{
+			Kind:        models.TxTypeWithdraw,
+			Description: "Withdraw from wallet",
+			Postings: []models.Posting{
+				{Account: models.UserAccount(userID), Amount: req.Amount.Neg()},
+				{Account: models.CashAccount, Amount: req.Amount},
+			},
+		})
+		if err != nil {
+			return storeError("Failed to post ledger entry", err)
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record transaction"})
-		return
-	}
 
-	if err := tx.Commit(); err != nil {
-		zlog.Fatal().
-			Err(err).
-			Msg("Failed to commit transaction")
-		log.Printf(": %v", err)
+		err = tx.RecordTransactions(ctx, models.Transaction{
+			UserID: userID, Type: models.TxTypeWithdraw, Amount: req.Amount, Description: "Withdraw from wallet", EntryID: entryID,
+		}.WithBalance(balance))
+		return storeError("Failed to record transaction", err)
+	})
+	h.Metrics.WalletOperation(models.TxTypeWithdraw, req.Amount, err)
+	if err != nil {
+		respondError(c, storeError("Failed to complete transaction", err))
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Withdraw successful"})
@@ -137,152 +156,140 @@ func (h *WalletHandler) Withdraw(c *gin.Context) {
 // Transfer transfer money from one user to another
 func (h *WalletHandler) Transfer(c *gin.Context) {
 	var req struct {
-		FromUserID int     `json:"from_user_id"`
-		ToUserID   int     `json:"to_user_id"`
-		Amount     float64 `json:"amount"`
+		FromUserID int          `json:"from_user_id"`
+		ToUserID   int          `json:"to_user_id"`
+		Amount     models.Money `json:"amount"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+	if err := c.ShouldBindJSON(&req); err != nil || !req.Amount.IsPositive() {
+		respondError(c, models.ErrInvalidInput)
 		return
 	}
 
-	var toUser int
-	err := h.DB.QueryRow("SELECT id FROM users WHERE id = $1", req.ToUserID).Scan(&toUser)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		return
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query user"})
+	fromUserID, ok := actingUserID(c, req.FromUserID)
+	if !ok {
 		return
 	}
-
-	tx, err := h.DB.Begin()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+	if req.ToUserID == fromUserID {
+		respondError(c, models.ErrSameWallet)
 		return
 	}
 
-	var balance float64
-	err = tx.QueryRow("SELECT balance FROM users WHERE id = $1", req.FromUserID).Scan(&balance)
-	if err != nil || balance < req.Amount {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
-			return
-		}
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
+	if err := h.stepUp(c, req.Amount); err != nil {
+		h.Metrics.WalletOperation(models.TxTypeTransfer, req.Amount, err)
+		respondError(c, err)
 		return
 	}
 
-	_, err = tx.Exec("UPDATE users SET balance = balance - $1 WHERE id = $2", req.Amount, req.FromUserID)
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
-			return
+	ctx := c.Request.Context()
+	annotateSpan(ctx, models.TxTypeTransfer, fromUserID, attribute.Int("wallet.to_user_id", req.ToUserID))
+	err := h.Store.WithinTx(ctx, func(tx store.WalletTx) error {
+		// lock both wallets so concurrent transfers cannot both pass the balance
+		// check, a missing recipient fails here with ErrUserNotFound
+		balances, err := tx.LockBalances(ctx, fromUserID, req.ToUserID)
+		if err != nil {
+			return storeError("Failed to query balance", err)
+		}
+		if balances[fromUserID].Cmp(req.Amount) < 0 {
+			return models.ErrInsufficientFunds
+		}
+		if !balances[req.ToUserID].Add(req.Amount).InRange() {
+			return models.ErrBalanceLimit
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deduct balance"})
-		return
-	}
 
-	_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE id = $2", req.Amount, req.ToUserID)
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Error failed to rollback transaction")
-			return
+		fromBalance, err := tx.AdjustBalance(ctx, fromUserID, req.Amount.Neg())
+		if err != nil {
+			return storeError("Failed to deduct balance", err)
+		}
+		toBalance, err := tx.AdjustBalance(ctx, req.ToUserID, req.Amount)
+		if err != nil {
+			return storeError("Failed to credit balance", err)
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to credit balance"})
-		return
-	}
 
-	_, err = tx.Exec("INSERT INTO transactions (user_id, type, amount, description) VALUES ($1, $2, $3, $4)",
-		req.FromUserID, "transfer", req.Amount, "Transfer to user "+fmt.Sprint(req.ToUserID))
-	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
-			zlog.Fatal().
-				Err(rbErr).
-				Msg("Failed to rollback transaction")
-			return
+		description := "Transfer to user " + fmt.Sprint(req.ToUserID)
+		entryID, err := tx.PostEntry(ctx, models.JournalEntry{
+			Kind:        models.TxTypeTransfer,
+			Description: description,
+			Postings: []models.Posting{
+				{Account: models.UserAccount(fromUserID), Amount: req.Amount.Neg()},
+				{Account: models.UserAccount(req.ToUserID), Amount: req.Amount},
+			},
+		})
+		if err != nil {
+			return storeError("Failed to post ledger entry", err)
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record transaction"})
-		return
-	}
 
-	if err := tx.Commit(); err != nil {
-		zlog.Fatal().
-			Err(err).
-			Msg("Failed to commit transaction")
-		log.Printf(": %v", err)
+		// both sides get a history row linked to the same journal entry
+		err = tx.RecordTransactions(ctx,
+			models.Transaction{UserID: fromUserID, Type: models.TxTypeTransfer, Amount: req.Amount, Description: description, EntryID: entryID}.
+				WithBalance(fromBalance),
+			models.Transaction{UserID: req.ToUserID, Type: models.TxTypeTransferIn, Amount: req.Amount, Description: "Transfer from user " + fmt.Sprint(fromUserID), EntryID: entryID}.
+				WithBalance(toBalance),
+		)
+		return storeError("Failed to record transaction", err)
+	})
+	h.Metrics.WalletOperation(models.TxTypeTransfer, req.Amount, err)
+	if err != nil {
+		respondError(c, storeError("Failed to complete transaction", err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Transfer successful"})
 }
 
-// GetBalance get balance by userID
-func (h *WalletHandler) GetBalance(c *gin.Context) {
-	userID := c.Param("userID")
-	var balance float64
+// stepUp checks the caller's two-factor code in OTPHeader when amount is
+// above StepUpAmount. Admins acting on other wallets need their own code. API
+// key requests are limited by the key's scopes instead, a service cannot
+// answer a two-factor prompt.
+func (h *WalletHandler) stepUp(c *gin.Context, amount models.Money) error {
+	if h.MFA == nil || !h.StepUpAmount.IsPositive() || amount.Cmp(h.StepUpAmount) <= 0 {
+		return nil
+	}
+	if _, viaKey := c.Get("apiKey"); viaKey {
+		return nil
+	}
+	callerID, ok := currentUserID(c)
+	if !ok {
+		return models.ErrUnauthorized
+	}
+	return verifyOTP(c, h.MFA, callerID)
+}
 
-	err := h.DB.QueryRow("SELECT balance FROM users WHERE id = $1", userID).Scan(&balance)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-		return
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+// verifyOTP checks the user's two-factor code in OTPHeader,
+// models.ErrMFARequired if there is none and models.ErrMFANotEnabled if the
+// user has no two-factor authentication
+func verifyOTP(c *gin.Context, s *mfa.Service, userID int) error {
+	ctx := c.Request.Context()
+	code := c.GetHeader(OTPHeader)
+	if code == "" {
+		enabled, err := s.Enabled(ctx, userID)
+		if err != nil {
+			return storeError("Failed to check two-factor authentication", err)
+		}
+		if !enabled {
+			return models.ErrMFANotEnabled
+		}
+		return models.ErrMFARequired
 	}
 
-	c.JSON(http.StatusOK, gin.H{"balance": balance})
+	err := s.Verify(ctx, userID, c.ClientIP(), code)
+	if errors.Is(err, models.ErrMFANotEnrolled) {
+		return models.ErrMFANotEnabled
+	}
+	return storeError("Failed to verify two-factor code", err)
 }
 
-// GetTransactions get transactions by userID
-func (h *WalletHandler) GetTransactions(c *gin.Context) {
-	userID := c.Param("userID")
-	var transactions []struct {
-		ID          int     `json:"id"`
-		Type        string  `json:"type"`
-		Amount      float64 `json:"amount"`
-		Description string  `json:"description"`
-		CreatedAt   string  `json:"created_at"`
+// GetBalance get balance of the caller, or of :userID on admin routes
+func (h *WalletHandler) GetBalance(c *gin.Context) {
+	userID, ok := pathUserID(c)
+	if !ok {
+		return
 	}
 
-	rows, err := h.DB.Query("SELECT id, type, amount, description, created_at FROM transactions WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	balance, err := h.Store.Balance(c.Request.Context(), userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "No transactions found for user"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
+		respondError(c, storeError("Database error", err))
 		return
 	}
 
-	defer func() {
-		if err := rows.Close(); err != nil {
-			zlog.Fatal().
-				Err(err).
-				Msg("Error closing database connection")
-		}
-	}()
-
-	for rows.Next() {
-		var tx struct {
-			ID          int     `json:"id"`
-			Type        string  `json:"type"`
-			Amount      float64 `json:"amount"`
-			Description string  `json:"description"`
-			CreatedAt   string  `json:"created_at"`
-		}
-		if err := rows.Scan(&tx.ID, &tx.Type, &tx.Amount, &tx.Description, &tx.CreatedAt); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error reading transaction data"})
-			return
-		}
-		transactions = append(transactions, tx)
-	}
-
-	c.JSON(http.StatusOK, gin.H{"transactions": transactions})
+	c.JSON(http.StatusOK, gin.H{"balance": balance})
 }
@@ -2,59 +2,193 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
-
-	// "gin-wallet2/models"
+	"gin-wallet2/metrics"
+	"gin-wallet2/mfa"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestWalletHandler_Deposit(t *testing.T) {
-	gin.SetMode(gin.TestMode)
-	// Create a new mock database
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Failed to create mock database: %v", err)
+// faultyStore wraps the in-memory store and fails the named step with err
+type faultyStore struct {
+	*store.Memory
+	failOn string
+	err    error
+}
+
+func (s *faultyStore) WithinTx(ctx context.Context, fn func(tx store.WalletTx) error) error {
+	if s.failOn == "WithinTx" {
+		return s.err
 	}
-	defer db.Close()
+	return s.Memory.WithinTx(ctx, func(tx store.WalletTx) error {
+		return fn(&faultyTx{WalletTx: tx, failOn: s.failOn, err: s.err})
+	})
+}
 
-	// Create handler with mock DB
-	handler := NewWalletHandler(db)
+func (s *faultyStore) Balance(ctx context.Context, userID int) (models.Money, error) {
+	if s.failOn == "Balance" {
+		return models.Money{}, s.err
+	}
+	return s.Memory.Balance(ctx, userID)
+}
 
-	// Create test cases
-	tests := []struct {
-		name           string
-		requestBody    map[string]interface{}
-		setupMock      func(sqlmock.Sqlmock)
-		expectedStatus int
-		expectedBody   map[string]interface{}
-	}{
+func (s *faultyStore) Transactions(ctx context.Context, userID int, q models.TransactionQuery) ([]models.Transaction, error) {
+	if s.failOn == "Transactions" {
+		return nil, s.err
+	}
+	return s.Memory.Transactions(ctx, userID, q)
+}
+
+func (s *faultyStore) CountTransactions(ctx context.Context, userID int, f models.TransactionFilter) (int, error) {
+	if s.failOn == "CountTransactions" {
+		return 0, s.err
+	}
+	return s.Memory.CountTransactions(ctx, userID, f)
+}
+
+func (s *faultyStore) VerifyLedger(ctx context.Context) (*models.LedgerReport, error) {
+	if s.failOn == "VerifyLedger" {
+		return nil, s.err
+	}
+	return s.Memory.VerifyLedger(ctx)
+}
+
+func (s *faultyStore) VerifyHistory(ctx context.Context) (*models.HistoryReport, error) {
+	if s.failOn == "VerifyHistory" {
+		return nil, s.err
+	}
+	return s.Memory.VerifyHistory(ctx)
+}
+
+type faultyTx struct {
+	store.WalletTx
+	failOn string
+	err    error
+}
+
+func (t *faultyTx) LockBalances(ctx context.Context, userIDs ...int) (map[int]models.Money, error) {
+	if t.failOn == "LockBalances" {
+		return nil, t.err
+	}
+	return t.WalletTx.LockBalances(ctx, userIDs...)
+}
+
+func (t *faultyTx) AdjustBalance(ctx context.Context, userID int, delta models.Money) (models.Money, error) {
+	if t.failOn == "AdjustBalance" {
+		return models.Money{}, t.err
+	}
+	return t.WalletTx.AdjustBalance(ctx, userID, delta)
+}
+
+func (t *faultyTx) PostEntry(ctx context.Context, entry models.JournalEntry) (int, error) {
+	if t.failOn == "PostEntry" {
+		return 0, t.err
+	}
+	return t.WalletTx.PostEntry(ctx, entry)
+}
+
+func (t *faultyTx) RecordTransactions(ctx context.Context, txs ...models.Transaction) error {
+	if t.failOn == "RecordTransactions" {
+		return t.err
+	}
+	return t.WalletTx.RecordTransactions(ctx, txs...)
+}
+
+func (t *faultyTx) RecordAdjustment(ctx context.Context, a *models.Adjustment) error {
+	if t.failOn == "RecordAdjustment" {
+		return t.err
+	}
+	return t.WalletTx.RecordAdjustment(ctx, a)
+}
+
+// newTestStore creates users 1..n funded with the given balances through
+// balanced deposit entries
+func newTestStore(t *testing.T, balances ...string) *store.Memory {
+	t.Helper()
+	ctx := context.Background()
+	s := store.NewMemory()
+	for i, b := range balances {
+		id, err := s.CreateUser(ctx, "user"+string(rune('a'+i)), "hash")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		amount := models.MustParseMoney(b)
+		if amount.IsZero() {
+			continue
+		}
+		err = s.WithinTx(ctx, func(tx store.WalletTx) error {
+			if _, err := tx.AdjustBalance(ctx, id, amount); err != nil {
+				return err
+			}
+			_, err := tx.PostEntry(ctx, models.JournalEntry{Kind: models.TxTypeDeposit, Postings: []models.Posting{
+				{Account: models.CashAccount, Amount: amount.Neg()},
+				{Account: models.UserAccount(id), Amount: amount},
+			}})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to fund user: %v", err)
+		}
+	}
+	return s
+}
+
+// assertStoreBalanced checks that every posting still reconciles
+func assertStoreBalanced(t *testing.T, s store.WalletStore) {
+	t.Helper()
+	report, err := s.VerifyLedger(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, report.Balanced(), "ledger report: %+v", report)
+}
+
+type walletTestCase struct {
+	name            string
+	requestBody     map[string]interface{}
+	admin           bool
+	failOn          string
+	expectedStatus  int
+	expectedBody    map[string]interface{}
+	expectedBalance map[int]string
+}
+
+func TestWalletHandler_Deposit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []walletTestCase{
 		{
 			name: "successful deposit",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  100.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE users").
-					WithArgs(100.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "deposit", 100.0, "Deposit to wallet").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": "Deposit successful",
+			},
+			expectedBalance: map[int]string{1: "100.00"},
+		},
+		{
+			name: "deposit to own wallet without user id",
+			requestBody: map[string]interface{}{
+				"amount": "0.10",
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": "Deposit successful",
 			},
+			expectedBalance: map[int]string{1: "0.10"},
 		},
 		{
 			name: "invalid amount",
@@ -62,260 +196,300 @@ func TestWalletHandler_Deposit(t *testing.T) {
 				"user_id": 1,
 				"amount":  -100.0,
 			},
-			setupMock:      func(_ sqlmock.Sqlmock) {},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: map[string]interface{}{
 				"error": "Invalid input",
+				"code":  "invalid_input",
 			},
+			expectedBalance: map[int]string{1: "0.00"},
 		},
 		{
-			name: "update balance rollback",
+			name: "amount out of range",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  99999999999999999,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE users").
-					WithArgs(99999999999999999.0, 1).
-					WillReturnError(sql.ErrConnDone) // Simulate insert error
-				mock.ExpectRollback()
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error": "Invalid input",
+				"code":  "invalid_input",
+			},
+		},
+		{
+			name: "too many decimal places",
+			requestBody: map[string]interface{}{
+				"user_id": 1,
+				"amount":  "10.005",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error": "Invalid input",
+				"code":  "invalid_input",
+			},
+		},
+		{
+			name: "another user's wallet",
+			requestBody: map[string]interface{}{
+				"user_id": 2,
+				"amount":  100.0,
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody: map[string]interface{}{
+				"error": "Forbidden",
+				"code":  "forbidden",
+			},
+			expectedBalance: map[int]string{2: "0.00"},
+		},
+		{
+			name: "admin deposit to another user's wallet",
+			requestBody: map[string]interface{}{
+				"user_id": 2,
+				"amount":  100.0,
+			},
+			admin:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": "Deposit successful",
 			},
+			expectedBalance: map[int]string{1: "0.00", 2: "100.00"},
+		},
+		{
+			name: "user not found",
+			requestBody: map[string]interface{}{
+				"user_id": 999,
+				"amount":  100.0,
+			},
+			admin:          true,
+			expectedStatus: http.StatusNotFound,
+			expectedBody: map[string]interface{}{
+				"error": "User not found",
+				"code":  "user_not_found",
+			},
+		},
+		{
+			name: "update balance error",
+			requestBody: map[string]interface{}{
+				"user_id": 1,
+				"amount":  "0.10",
+			},
+			failOn:         "AdjustBalance",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Failed to update balance",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "0.00"},
 		},
 		{
-			name: "transaction rollback",
+			name: "ledger entry error",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  100.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE users").
-					WithArgs(100.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "deposit", 100.0, "Deposit to wallet").
-					WillReturnError(sql.ErrConnDone) // Simulate insert error
-				mock.ExpectRollback()
+			failOn:         "PostEntry",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: map[string]interface{}{
+				"error": "Failed to post ledger entry",
+				"code":  "internal_error",
+			},
+			expectedBalance: map[int]string{1: "0.00"},
+		},
+		{
+			name: "record transaction error",
+			requestBody: map[string]interface{}{
+				"user_id": 1,
+				"amount":  100.0,
 			},
+			failOn:         "RecordTransactions",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Failed to record transaction",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "0.00"},
 		},
 		{
-			name: "begin transaction error",
+			name: "begin or commit error",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  100.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
-			},
+			failOn:         "WithinTx",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
-				"error": "Failed to start transaction",
+				"error": "Failed to complete transaction",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "0.00"},
 		},
 	}
 
-	// runHandlerTests(t, handler.Transfer, tests, mock)
-
-	// Run tests
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Setup mock expectations
-			tt.setupMock(mock)
-
-			// Create request
-			jsonBody, _ := json.Marshal(tt.requestBody)
-			req := httptest.NewRequest(http.MethodPost, "/deposit", bytes.NewBuffer(jsonBody))
-			req.Header.Set("Content-Type", "application/json")
-
-			// Create response recorder
-			w := httptest.NewRecorder()
-
-			// Setup Gin context
-			gin.SetMode(gin.TestMode)
-			c, _ := gin.CreateTestContext(w)
-			c.Request = req
-
-			// Call the handler
-			handler.Deposit(c)
+	runWalletTests(t, func(h *WalletHandler) gin.HandlerFunc { return h.Deposit }, []string{"0", "0"}, tests)
+}
 
-			// Assert response
-			assert.Equal(t, tt.expectedStatus, w.Code)
+func TestWalletHandler_BalanceLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestStore(t, "99999999.00", "99999999.00")
+	handler := NewWalletHandler(s)
+	send := func(h gin.HandlerFunc, userID int, body map[string]interface{}) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonBody))
+		c.Set("userID", userID)
+		c.Set("adminOverride", true)
+		h(c)
+		return w
+	}
 
-			var response map[string]interface{}
-			err := json.Unmarshal(w.Body.Bytes(), &response)
-			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedBody, response)
+	w := send(handler.Deposit, 1, map[string]interface{}{"amount": "0.99"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send(handler.Deposit, 1, map[string]interface{}{"amount": "0.01"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error":"Balance would exceed the wallet limit","code":"balance_limit_exceeded"}`, w.Body.String())
+	w = send(handler.Transfer, 2, map[string]interface{}{"to_user_id": 1, "amount": "1"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = send(handler.Adjust, 2, map[string]interface{}{"user_id": 1, "amount": "0.01", "reason": "bonus"})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 
-			assert.NoError(t, mock.ExpectationsWereMet())
-		})
-	}
+	balance, _ := s.Balance(context.Background(), 1)
+	assert.Equal(t, "99999999.99", balance.String())
+	balance, _ = s.Balance(context.Background(), 2)
+	assert.Equal(t, "99999999.00", balance.String())
+	assertStoreBalanced(t, s)
 }
 
 func TestWalletHandler_Withdraw(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Failed to create mock database: %v", err)
-	}
-	defer db.Close()
-
-	_ = mock
 
-	handler := NewWalletHandler(db)
-
-	tests := []struct {
-		name           string
-		requestBody    map[string]interface{}
-		setupMock      func(sqlmock.Sqlmock)
-		expectedStatus int
-		expectedBody   map[string]interface{}
-	}{
+	tests := []walletTestCase{
 		{
 			name: "successful withdraw",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  50.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
-				mock.ExpectExec("UPDATE users").
-					WithArgs(50.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "withdraw", 50.0, "Withdraw from wallet").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": "Withdraw successful",
+			},
+			expectedBalance: map[int]string{1: "50.00"},
+		},
+		{
+			name: "withdraw entire balance",
+			requestBody: map[string]interface{}{
+				"amount": "100.00",
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": "Withdraw successful",
 			},
+			expectedBalance: map[int]string{1: "0.00"},
 		},
 		{
 			name: "invalid amount",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
-				"amount":  -50.0,
+				"amount":  0,
 			},
-			setupMock:      func(_ sqlmock.Sqlmock) {},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: map[string]interface{}{
 				"error": "Invalid input",
+				"code":  "invalid_input",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
+		},
+		{
+			name: "another user's wallet",
+			requestBody: map[string]interface{}{
+				"user_id": 2,
+				"amount":  50.0,
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody: map[string]interface{}{
+				"error": "Forbidden",
+				"code":  "forbidden",
+			},
+			expectedBalance: map[int]string{2: "20.00"},
 		},
 		{
 			name: "insufficient balance",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
-				"amount":  150.0,
-			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
-				mock.ExpectRollback()
+				"amount":  "100.01",
 			},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: map[string]interface{}{
 				"error": "Insufficient balance",
+				"code":  "insufficient_funds",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
 		},
 		{
-			name: "update balance rollback",
+			name: "balance query error",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  50.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
-				mock.ExpectExec("UPDATE users").
-					WithArgs(50.0, 1).
-					WillReturnError(sql.ErrConnDone) // Simulate insert error
-				mock.ExpectRollback()
+			failOn:         "LockBalances",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: map[string]interface{}{
+				"error": "Failed to query balance",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
+		},
+		{
+			name: "update balance error",
+			requestBody: map[string]interface{}{
+				"user_id": 1,
+				"amount":  50.0,
+			},
+			failOn:         "AdjustBalance",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Failed to update balance",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
 		},
 		{
-			name: "transaction rollback",
+			name: "record transaction error",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  50.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin()
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
-				mock.ExpectExec("UPDATE users").
-					WithArgs(50.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "withdraw", 50.0, "Withdraw from wallet").
-					WillReturnError(sql.ErrConnDone) // Simulate insert error
-				mock.ExpectRollback()
-			},
+			failOn:         "RecordTransactions",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Failed to record transaction",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
 		},
 		{
-			name: "begin transaction error",
+			name: "begin or commit error",
 			requestBody: map[string]interface{}{
 				"user_id": 1,
 				"amount":  50.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
-			},
+			failOn:         "WithinTx",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
-				"error": "Failed to start transaction",
+				"error": "Failed to complete transaction",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
 		},
 	}
 
-	runHandlerTests(t, handler.Withdraw, tests, mock)
+	runWalletTests(t, func(h *WalletHandler) gin.HandlerFunc { return h.Withdraw }, []string{"100", "20"}, tests)
 }
 
 func TestWalletHandler_Transfer(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Failed to create mock database: %v", err)
-	}
-	defer db.Close()
-
-	_ = mock
 
-	handler := NewWalletHandler(db)
-
-	tests := []struct {
-		name           string
-		requestBody    map[string]interface{}
-		setupMock      func(sqlmock.Sqlmock)
-		expectedStatus int
-		expectedBody   map[string]interface{}
-	}{
+	tests := []walletTestCase{
 		{
 			name: "successful transfer",
 			requestBody: map[string]interface{}{
@@ -323,170 +497,308 @@ func TestWalletHandler_Transfer(t *testing.T) {
 				"to_user_id":   2,
 				"amount":       50.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT id FROM users").
-					WithArgs(2).
-					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
-				mock.ExpectBegin()
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
-				mock.ExpectExec("UPDATE users").
-					WithArgs(50.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("UPDATE users").
-					WithArgs(50.0, 2).
-					WillReturnResult(sqlmock.NewResult(0, 1))
-				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "transfer", 50.0, "Transfer to user 2").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-				mock.ExpectCommit()
-			},
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
 				"message": "Transfer successful",
 			},
+			expectedBalance: map[int]string{1: "50.00", 2: "70.00"},
 		},
 		{
 			name: "invalid amount",
 			requestBody: map[string]interface{}{
-				"user_id": 1,
-				"amount":  -100.0,
+				"from_user_id": 1,
+				"to_user_id":   2,
+				"amount":       -50.0,
 			},
-			setupMock:      func(_ sqlmock.Sqlmock) {},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: map[string]interface{}{
 				"error": "Invalid input",
+				"code":  "invalid_input",
+			},
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
+		},
+		{
+			name: "transfer from another user's wallet",
+			requestBody: map[string]interface{}{
+				"from_user_id": 2,
+				"to_user_id":   1,
+				"amount":       10.0,
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody: map[string]interface{}{
+				"error": "Forbidden",
+				"code":  "forbidden",
+			},
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
+		},
+		{
+			name: "admin transfer between other wallets",
+			requestBody: map[string]interface{}{
+				"from_user_id": 2,
+				"to_user_id":   1,
+				"amount":       "20",
 			},
+			admin:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"message": "Transfer successful",
+			},
+			expectedBalance: map[int]string{1: "120.00", 2: "0.00"},
+		},
+		{
+			name: "transfer to the same wallet",
+			requestBody: map[string]interface{}{
+				"to_user_id": 1,
+				"amount":     10.0,
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error": "Cannot transfer to the same wallet",
+				"code":  "invalid_input",
+			},
+			expectedBalance: map[int]string{1: "100.00"},
 		},
 		{
 			name: "recipient user not found",
 			requestBody: map[string]interface{}{
 				"from_user_id": 1,
 				"to_user_id":   999,
-				"amount":       50.0,
-			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT id FROM users").
-					WithArgs(999).
-					WillReturnError(sql.ErrNoRows)
+				"amount":       10.0,
 			},
-			expectedStatus: http.StatusUnauthorized,
+			expectedStatus: http.StatusNotFound,
 			expectedBody: map[string]interface{}{
-				"error": "Invalid credentials",
+				"error": "User not found",
+				"code":  "user_not_found",
 			},
+			expectedBalance: map[int]string{1: "100.00"},
 		},
 		{
-			name: "Insufficient balance",
+			name: "insufficient balance",
 			requestBody: map[string]interface{}{
 				"from_user_id": 1,
 				"to_user_id":   2,
 				"amount":       150.0,
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT id FROM users").
-					WithArgs(2).
-					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
-				mock.ExpectBegin()
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs(1).
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0)).
-					WillReturnError(sql.ErrConnDone)
-				mock.ExpectRollback()
-			},
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: map[string]interface{}{
 				"error": "Insufficient balance",
+				"code":  "insufficient_funds",
 			},
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
 		},
 		{
-			name: "begin transaction error",
+			name: "balance query error",
 			requestBody: map[string]interface{}{
 				"from_user_id": 1,
 				"to_user_id":   2,
-				"amount":       50.0,
+				"amount":       10.0,
+			},
+			failOn:         "LockBalances",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: map[string]interface{}{
+				"error": "Failed to query balance",
+				"code":  "internal_error",
 			},
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT id FROM users").
-					WithArgs(2).
-					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
-				mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
+		},
+		{
+			name: "deduct balance error",
+			requestBody: map[string]interface{}{
+				"from_user_id": 1,
+				"to_user_id":   2,
+				"amount":       10.0,
+			},
+			failOn:         "AdjustBalance",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: map[string]interface{}{
+				"error": "Failed to deduct balance",
+				"code":  "internal_error",
+			},
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
+		},
+		{
+			name: "ledger entry error",
+			requestBody: map[string]interface{}{
+				"from_user_id": 1,
+				"to_user_id":   2,
+				"amount":       10.0,
+			},
+			failOn:         "PostEntry",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: map[string]interface{}{
+				"error": "Failed to post ledger entry",
+				"code":  "internal_error",
+			},
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
+		},
+		{
+			name: "begin or commit error",
+			requestBody: map[string]interface{}{
+				"from_user_id": 1,
+				"to_user_id":   2,
+				"amount":       10.0,
 			},
+			failOn:         "WithinTx",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
-				"error": "Failed to start transaction",
+				"error": "Failed to complete transaction",
+				"code":  "internal_error",
 			},
+			expectedBalance: map[int]string{1: "100.00", 2: "20.00"},
 		},
 	}
 
-	runHandlerTests(t, handler.Transfer, tests, mock)
+	runWalletTests(t, func(h *WalletHandler) gin.HandlerFunc { return h.Transfer }, []string{"100", "20"}, tests)
 }
 
-func TestWalletHandler_GetBalance(t *testing.T) {
+func TestWalletHandler_TransferHistory(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Failed to create mock database: %v", err)
+	s := newTestStore(t, "100", "0")
+	handler := NewWalletHandler(s)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"to_user_id": 2, "amount": "12.50"})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonBody))
+	c.Set("userID", 1)
+	handler.Transfer(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// sender and receiver both see the transfer, linked to one journal entry
+	sent, err := s.Transactions(context.Background(), 1, models.TransactionQuery{})
+	assert.NoError(t, err)
+	received, err := s.Transactions(context.Background(), 2, models.TransactionQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, sent, 1) && assert.Len(t, received, 1) {
+		assert.Equal(t, models.TxTypeTransfer, sent[0].Type)
+		assert.Equal(t, "Transfer to user 2", sent[0].Description)
+		assert.Equal(t, models.TxTypeTransferIn, received[0].Type)
+		assert.Equal(t, "Transfer from user 1", received[0].Description)
+		assert.Equal(t, sent[0].EntryID, received[0].EntryID)
+		assert.Equal(t, "100.00", sent[0].BalanceBefore.String())
+		assert.Equal(t, "87.50", sent[0].BalanceAfter.String())
+		assert.Equal(t, "0.00", received[0].BalanceBefore.String())
+		assert.Equal(t, "12.50", received[0].BalanceAfter.String())
 	}
-	defer db.Close()
+	assertStoreBalanced(t, s)
+}
 
-	handler := NewWalletHandler(db)
+func TestWalletHandler_Metrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewWalletHandler(newTestStore(t, "100", "0"))
+	handler.Metrics = metrics.New()
+
+	for _, amount := range []string{"30", "500", "70"} {
+		jsonBody, _ := json.Marshal(map[string]interface{}{"amount": amount})
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonBody))
+		c.Set("userID", 1)
+		handler.Withdraw(c)
+	}
+
+	assert.NoError(t, testutil.GatherAndCompare(handler.Metrics.Registry, strings.NewReader(`
+# HELP wallet_operations_total Deposits, withdrawals and transfers by outcome.
+# TYPE wallet_operations_total counter
+wallet_operations_total{operation="withdraw",outcome="insufficient_funds"} 1
+wallet_operations_total{operation="withdraw",outcome="success"} 2
+# HELP wallet_amount_moved_total Total amount moved by successful operations.
+# TYPE wallet_amount_moved_total counter
+wallet_amount_moved_total{operation="withdraw"} 100
+`), "wallet_operations_total", "wallet_amount_moved_total"))
+}
+
+func TestWalletHandler_GetBalance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
 
 	tests := []struct {
 		name           string
 		userID         string
-		setupMock      func(sqlmock.Sqlmock)
+		admin          bool
+		failOn         string
 		expectedStatus int
 		expectedBody   map[string]interface{}
 	}{
 		{
-			name:   "successful balance query",
-			userID: "1",
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs("1").
-					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
+			name:           "successful balance query",
+			userID:         "1",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"balance": "100.00",
 			},
+		},
+		{
+			name:           "own balance via me route",
+			userID:         "",
 			expectedStatus: http.StatusOK,
 			expectedBody: map[string]interface{}{
-				"balance": float64(100.0),
+				"balance": "100.00",
 			},
 		},
 		{
-			name:   "user not found",
-			userID: "999",
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs("999").
-					WillReturnError(sql.ErrNoRows)
+			name:           "other user's balance is forbidden",
+			userID:         "2",
+			expectedStatus: http.StatusForbidden,
+			expectedBody: map[string]interface{}{
+				"error": "Forbidden",
+				"code":  "forbidden",
 			},
+		},
+		{
+			name:           "admin reads other user's balance",
+			userID:         "2",
+			admin:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"balance": "12.34",
+			},
+		},
+		{
+			name:           "invalid user id",
+			userID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: map[string]interface{}{
+				"error": "Invalid user ID",
+				"code":  "invalid_input",
+			},
+		},
+		{
+			name:           "user not found",
+			userID:         "999",
+			admin:          true,
 			expectedStatus: http.StatusNotFound,
 			expectedBody: map[string]interface{}{
 				"error": "User not found",
+				"code":  "user_not_found",
 			},
 		},
 		{
-			name:   "database error",
-			userID: "999",
-			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT balance FROM users").
-					WithArgs("999").
-					WillReturnError(sql.ErrConnDone)
-			},
+			name:           "database error",
+			userID:         "1",
+			failOn:         "Balance",
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Database error",
+				"code":  "internal_error",
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.setupMock(mock)
+			handler := NewWalletHandler(&faultyStore{
+				Memory: newTestStore(t, "100", "12.34"),
+				failOn: tt.failOn,
+				err:    sql.ErrConnDone,
+			})
 
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
-			c.Params = []gin.Param{{Key: "userID", Value: tt.userID}}
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.userID != "" {
+				c.Params = []gin.Param{{Key: "userID", Value: tt.userID}}
+			}
+			c.Set("userID", 1)
+			c.Set("adminOverride", tt.admin)
 
 			handler.GetBalance(c)
 
@@ -496,97 +808,270 @@ func TestWalletHandler_GetBalance(t *testing.T) {
 			err := json.Unmarshal(w.Body.Bytes(), &response)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedBody, response)
-
-			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
 func TestWalletHandler_GetTransactions(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("Failed to create mock database: %v", err)
+
+	t.Run("successful transactions query", func(t *testing.T) {
+		s := newTestStore(t, "0")
+		ctx := context.Background()
+		err := s.WithinTx(ctx, func(tx store.WalletTx) error {
+			return tx.RecordTransactions(ctx,
+				models.Transaction{UserID: 1, Type: "deposit", Amount: models.MustParseMoney("100"), Description: "Deposit to wallet"},
+				models.Transaction{UserID: 1, Type: "withdraw", Amount: models.MustParseMoney("50"), Description: "Withdraw from wallet"},
+			)
+		})
+		assert.NoError(t, err)
+		handler := NewWalletHandler(s)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Params = []gin.Param{{Key: "userID", Value: "1"}}
+		c.Set("userID", 1)
+
+		handler.GetTransactions(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Transactions []map[string]interface{} `json:"transactions"`
+			Total        int                      `json:"total"`
+			NextCursor   *string                  `json:"next_cursor"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 2, response.Total)
+		assert.Nil(t, response.NextCursor)
+		if assert.Len(t, response.Transactions, 2) {
+			// newest first
+			assert.Equal(t, float64(2), response.Transactions[0]["id"])
+			assert.Equal(t, "withdraw", response.Transactions[0]["type"])
+			assert.Equal(t, "50.00", response.Transactions[0]["amount"])
+			assert.Equal(t, "Withdraw from wallet", response.Transactions[0]["description"])
+			assert.Contains(t, response.Transactions[0], "created_at")
+			// rows recorded without balances
+			assert.Contains(t, response.Transactions[0], "balance_after")
+			assert.Nil(t, response.Transactions[0]["balance_after"])
+			assert.NotContains(t, response.Transactions[0], "user_id")
+		}
+	})
+
+	t.Run("empty history", func(t *testing.T) {
+		w := historyRequest(NewWalletHandler(newTestStore(t, "0")).GetTransactions, "/")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"transactions":[],"total":0,"next_cursor":null}`, w.Body.String())
+	})
+
+	for _, failOn := range []string{"Transactions", "CountTransactions"} {
+		t.Run("database error in "+failOn, func(t *testing.T) {
+			handler := NewWalletHandler(&faultyStore{Memory: newTestStore(t, "0"), failOn: failOn, err: sql.ErrConnDone})
+
+			w := historyRequest(handler.GetTransactions, "/")
+
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			assert.JSONEq(t, `{"error":"Database error","code":"internal_error"}`, w.Body.String())
+		})
 	}
-	defer db.Close()
+}
 
-	_ = mock
+// historyRequest GET target as user 1, with handler behind /transactions/:id
+// and every other path
+func historyRequest(handler gin.HandlerFunc, target string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", 1) })
+	router.GET("/transactions/:id", handler)
+	router.NoRoute(handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
 
-	handler := NewWalletHandler(db)
+// historyStore user 1 with deposits of 10 to 50 and a transfer of 5 to user 2
+func historyStore(t *testing.T) *store.Memory {
+	s := newTestStore(t, "0", "0")
+	handler := NewWalletHandler(s)
+	send := func(h gin.HandlerFunc, body map[string]interface{}) {
+		jsonBody, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonBody))
+		c.Set("userID", 1)
+		h(c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	for _, amount := range []string{"10", "20", "30", "40", "50"} {
+		send(handler.Deposit, map[string]interface{}{"amount": amount})
+	}
+	send(handler.Transfer, map[string]interface{}{"to_user_id": 2, "amount": "5"})
+	return s
+}
+
+type historyPage struct {
+	Transactions []models.Transaction `json:"transactions"`
+	Total        int                  `json:"total"`
+	NextCursor   *string              `json:"next_cursor"`
+}
+
+func (p historyPage) ids() []int {
+	ids := []int{}
+	for _, t := range p.Transactions {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+func TestWalletHandler_GetTransactions_Query(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewWalletHandler(historyStore(t))
+	today := time.Now().UTC().Format(dateLayout)
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(dateLayout)
 
 	tests := []struct {
-		name           string
-		userID         string
-		setupMock      func(sqlmock.Sqlmock)
-		expectedStatus int
-		expectedBody   map[string]interface{}
+		name      string
+		query     string
+		wantIDs   []int
+		wantTotal int
 	}{
-		{
-			name:   "successful transactions query",
-			userID: "1",
-			setupMock: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "type", "amount", "description", "created_at"}).
-					AddRow(1, "deposit", 100.0, "Deposit to wallet", "2024-01-01 10:00:00").
-					AddRow(2, "withdraw", 50.0, "Withdraw from wallet", "2024-01-02 10:00:00")
-				mock.ExpectQuery("SELECT (.+) FROM transactions").
-					WithArgs("1").
-					WillReturnRows(rows)
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody: map[string]interface{}{
-				"transactions": []interface{}{
-					map[string]interface{}{
-						"id":          float64(1),
-						"type":        "deposit",
-						"amount":      float64(100.0),
-						"description": "Deposit to wallet",
-						"created_at":  "2024-01-01 10:00:00",
-					},
-					map[string]interface{}{
-						"id":          float64(2),
-						"type":        "withdraw",
-						"amount":      float64(50.0),
-						"description": "Withdraw from wallet",
-						"created_at":  "2024-01-02 10:00:00",
-					},
-				},
-			},
-		},
+		{"newest first", "", []int{6, 5, 4, 3, 2, 1}, 6},
+		{"oldest first", "?order=asc&limit=2", []int{1, 2}, 6},
+		{"type", "?type=transfer", []int{6}, 1},
+		{"types comma separated", "?type=transfer,withdraw", []int{6}, 1},
+		{"types repeated", "?type=transfer&type=deposit&limit=3", []int{6, 5, 4}, 6},
+		{"amount range", "?min_amount=20&max_amount=40", []int{4, 3, 2}, 3},
+		{"date range includes the day", "?from=" + today + "&to=" + today, []int{6, 5, 4, 3, 2, 1}, 6},
+		{"after range", "?from=" + tomorrow, []int{}, 0},
 	}
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.setupMock(mock)
+			w := historyRequest(handler.GetTransactions, "/"+tt.query)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var page historyPage
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			assert.Equal(t, tt.wantIDs, page.ids())
+			assert.Equal(t, tt.wantTotal, page.Total)
+		})
+	}
 
-			w := httptest.NewRecorder()
-			c, _ := gin.CreateTestContext(w)
-			c.Params = []gin.Param{{Key: "userID", Value: tt.userID}}
+	t.Run("running balance", func(t *testing.T) {
+		w := historyRequest(handler.GetTransactions, "/?order=asc")
+		require.Equal(t, http.StatusOK, w.Code)
+		var page historyPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		var balance models.Money
+		for _, tx := range page.Transactions {
+			require.NotNil(t, tx.BalanceBefore)
+			assert.Equal(t, balance.String(), tx.BalanceBefore.String(), "transaction %d", tx.ID)
+			balance = balance.Add(tx.Delta())
+			assert.Equal(t, balance.String(), tx.BalanceAfter.String(), "transaction %d", tx.ID)
+		}
+		assert.Equal(t, "145.00", balance.String())
+	})
 
-			handler.GetTransactions(c)
+	invalid := []string{
+		"?type=bonus", "?min_amount=abc", "?max_amount=1.001", "?min_amount=5&max_amount=1",
+		"?from=yesterday", "?to=2024-13-01", "?order=random", "?limit=0", "?limit=101", "?limit=x",
+	}
+	for _, query := range invalid {
+		t.Run("invalid "+query, func(t *testing.T) {
+			w := historyRequest(handler.GetTransactions, "/"+query)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, `{"error":"Invalid input","code":"invalid_input"}`, w.Body.String())
+		})
+	}
 
-			assert.Equal(t, tt.expectedStatus, w.Code)
+	for _, cursor := range []string{"!!", "bm9kb3Q", "MTIzLng", "MTIzLjA"} {
+		t.Run("invalid cursor "+cursor, func(t *testing.T) {
+			w := historyRequest(handler.GetTransactions, "/?cursor="+cursor)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, `{"error":"Invalid cursor","code":"invalid_input"}`, w.Body.String())
+		})
+	}
+}
 
-			var response map[string]interface{}
-			err := json.Unmarshal(w.Body.Bytes(), &response)
-			assert.NoError(t, err)
-			assert.Equal(t, tt.expectedBody, response)
+func TestWalletHandler_GetTransactions_Pages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewWalletHandler(historyStore(t))
 
-			assert.NoError(t, mock.ExpectationsWereMet())
+	for _, order := range []string{"desc", "asc"} {
+		t.Run(order, func(t *testing.T) {
+			var seen []int
+			target := "/?limit=4&order=" + order
+			for pages := 0; pages < 3; pages++ {
+				w := historyRequest(handler.GetTransactions, target)
+				require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+				var page historyPage
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+				assert.Equal(t, 6, page.Total)
+				seen = append(seen, page.ids()...)
+				if page.NextCursor == nil {
+					break
+				}
+				target = "/?limit=4&order=" + order + "&cursor=" + *page.NextCursor
+			}
+			want := []int{6, 5, 4, 3, 2, 1}
+			if order == "asc" {
+				want = []int{1, 2, 3, 4, 5, 6}
+			}
+			assert.Equal(t, want, seen)
 		})
 	}
 }
 
-// Helper function to run handler tests
-func runHandlerTests(t *testing.T, handlerFunc func(*gin.Context), tests []struct {
-	name           string
-	requestBody    map[string]interface{}
-	setupMock      func(sqlmock.Sqlmock)
-	expectedStatus int
-	expectedBody   map[string]interface{}
-}, mock sqlmock.Sqlmock) {
+func TestWalletHandler_GetTransaction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := historyStore(t)
+	handler := NewWalletHandler(s)
+
+	w := historyRequest(handler.GetTransaction, "/transactions/6")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Transaction map[string]interface{} `json:"transaction"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "transfer", response.Transaction["type"])
+	assert.Equal(t, "5.00", response.Transaction["amount"])
+	assert.Equal(t, "150.00", response.Transaction["balance_before"])
+	assert.Equal(t, "145.00", response.Transaction["balance_after"])
+	assert.Equal(t, map[string]interface{}{"account": "user:2", "user_id": float64(2), "name": "userb"}, response.Transaction["counterparty"])
+
+	w = historyRequest(handler.GetTransaction, "/transactions/2")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "30.00", response.Transaction["balance_after"])
+	assert.Equal(t, map[string]interface{}{"account": models.CashAccount}, response.Transaction["counterparty"])
+
+	// the receiving side of the transfer belongs to user 2
+	for _, id := range []string{"7", "99", "0", "x"} {
+		w = historyRequest(handler.GetTransaction, "/transactions/"+id)
+		assert.Equal(t, http.StatusNotFound, w.Code, id)
+		assert.JSONEq(t, `{"error":"Transaction not found","code":"not_found"}`, w.Body.String())
+	}
+
+	// staff reading user 1's transaction from the admin route
+	staffRequest := func(admin bool) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("userID", 2); c.Set("adminOverride", admin) })
+		router.GET("/admin/wallet/transactions/:userID/:id", handler.GetTransaction)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/wallet/transactions/1/6", nil))
+		return w
+	}
+	w = staffRequest(true)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "145.00", response.Transaction["balance_after"])
+	assert.Equal(t, http.StatusForbidden, staffRequest(false).Code)
+}
+
+// runWalletTests runs each case against a fresh store funded with balances,
+// acting as user 1, and checks the resulting balances and ledger
+func runWalletTests(t *testing.T, handlerFunc func(*WalletHandler) gin.HandlerFunc, balances []string, tests []walletTestCase) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.setupMock(mock)
+			s := newTestStore(t, balances...)
+			handler := NewWalletHandler(&faultyStore{Memory: s, failOn: tt.failOn, err: sql.ErrConnDone})
 
 			jsonBody, _ := json.Marshal(tt.requestBody)
 			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonBody))
@@ -595,8 +1080,10 @@ func runHandlerTests(t *testing.T, handlerFunc func(*gin.Context), tests []struc
 			w := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(w)
 			c.Request = req
+			c.Set("userID", 1)
+			c.Set("adminOverride", tt.admin)
 
-			handlerFunc(c)
+			handlerFunc(handler)(c)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
 
@@ -605,7 +1092,68 @@ func runHandlerTests(t *testing.T, handlerFunc func(*gin.Context), tests []struc
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expectedBody, response)
 
-			assert.NoError(t, mock.ExpectationsWereMet())
+			for id, want := range tt.expectedBalance {
+				balance, err := s.Balance(context.Background(), id)
+				assert.NoError(t, err)
+				assert.Equal(t, want, balance.String(), "balance of user %d", id)
+			}
+			assertStoreBalanced(t, s)
 		})
 	}
 }
+
+func TestWalletHandler_StepUp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	s := newTestStore(t, "200", "100")
+	twoFactor := mfa.NewService(s, "gin-wallet")
+	enrollment, err := twoFactor.Enroll(ctx, &models.User{ID: 1, Name: "usera"})
+	require.NoError(t, err)
+	recoveryCodes, err := twoFactor.Confirm(ctx, 1, "", totpCode(t, enrollment.Secret, 0))
+	require.NoError(t, err)
+
+	handler := NewWalletHandler(s)
+	handler.MFA = twoFactor
+	handler.StepUpAmount = models.MustParseMoney("50")
+
+	do := func(h gin.HandlerFunc, userID int, body, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if code != "" {
+			req.Header.Set(OTPHeader, code)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		c.Set("userID", userID)
+		h(c)
+		return w
+	}
+
+	// up to the amount no code is needed
+	w := do(handler.Withdraw, 1, `{"amount":"50"}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(handler.Transfer, 1, `{"to_user_id":2,"amount":"50.01"}`, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Two-factor code required for this amount","code":"mfa_required"}`, w.Body.String())
+
+	w = do(handler.Transfer, 1, `{"to_user_id":2,"amount":"50.01"}`, "000000")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Invalid two-factor code","code":"invalid_otp"}`, w.Body.String())
+
+	w = do(handler.Transfer, 1, `{"to_user_id":2,"amount":"60"}`, recoveryCodes[0])
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// users without two-factor authentication can not move large amounts
+	w = do(handler.Withdraw, 2, `{"amount":"60"}`, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error":"Enable two-factor authentication to move this amount","code":"mfa_required"}`, w.Body.String())
+	w = do(handler.Withdraw, 2, `{"amount":"60"}`, "123456")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	balance, _ := s.Balance(ctx, 1)
+	assert.Equal(t, "90.00", balance.String())
+	balance, _ = s.Balance(ctx, 2)
+	assert.Equal(t, "160.00", balance.String())
+}
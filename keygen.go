@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gin-wallet2/token"
+	"io"
+	"os"
+	"strings"
+)
+
+const keygenUsage = "usage: main keygen EdDSA|RS256 <private key file>"
+
+// runKeygen handles the `keygen` subcommand: it writes a new token signing
+// key and its public key next to it as .pub.pem, refusing to overwrite files
+func runKeygen(args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errors.New(keygenUsage)
+	}
+	private, public, kid, err := token.GenerateKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	privateFile := args[1]
+	publicFile := strings.TrimSuffix(privateFile, ".pem") + ".pub.pem"
+	if err := writeNewFile(privateFile, private, 0o600); err != nil {
+		return err
+	}
+	if err := writeNewFile(publicFile, public, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "kid %s\nprivate key %s\npublic key %s\n", kid, privateFile, publicFile)
+	return nil
+}
+
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"gin-wallet2/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunKeygen(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "jwt-2024.pem")
+
+	var out bytes.Buffer
+	assert.EqualError(t, runKeygen(nil, &out), keygenUsage)
+	assert.ErrorContains(t, runKeygen([]string{"HS256", file}, &out), `unsupported algorithm "HS256"`)
+
+	require.NoError(t, runKeygen([]string{"EdDSA", file}, &out))
+	keys, err := token.LoadKeys(file, filepath.Join(dir, "jwt-2024.pub.pem"))
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "kid "+keys.Signing().ID)
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// existing keys are never overwritten
+	assert.ErrorIs(t, runKeygen([]string{"EdDSA", file}, &out), os.ErrExist)
+}
@@ -0,0 +1,137 @@
+// Package lockout counts failed logins per user and per client IP, locks them
+// out with exponential backoff and keeps the users' login history
+package lockout
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxUserAgent longest user agent kept in the login history
+const maxUserAgent = 512
+
+// Policy when to lock a user or client IP. After MaxFailures consecutive
+// failures logins are rejected for Lockout, doubled with every further failure
+// up to MaxLockout. Failures are forgotten once the last one is older than Window.
+type Policy struct {
+	// MaxFailures zero disables the policy
+	MaxFailures int
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+	Window      time.Duration
+}
+
+// lockout how long to lock after the given number of consecutive failures,
+// zero if not yet
+func (p Policy) lockout(failures int) time.Duration {
+	if p.MaxFailures <= 0 || failures < p.MaxFailures {
+		return 0
+	}
+	d := p.Lockout
+	for i := p.MaxFailures; i < failures && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxLockout)
+}
+
+// Guard applies the User policy to accounts and the IP policy to client IPs.
+// A nil Guard never locks and records nothing.
+type Guard struct {
+	Store store.LoginStore
+	User  Policy
+	IP    Policy
+
+	now func() time.Time
+}
+
+// NewGuard new login guard
+func NewGuard(s store.LoginStore, user, ip Policy) *Guard {
+	return &Guard{Store: s, User: user, IP: ip, now: time.Now}
+}
+
+// UserKey counter key of a user
+func UserKey(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// IPKey counter key of a client IP
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Locked returns how long logins for key stay rejected, zero if they are not
+func (g *Guard) Locked(ctx context.Context, key string) (time.Duration, error) {
+	if g == nil {
+		return 0, nil
+	}
+	f, err := g.Store.LoginFailures(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := f.LockedUntil.Sub(g.now()); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// Failed counts a failed login from ip, and for the user unless userID is 0
+// because the name is unknown. It reports whether this locked either of them.
+func (g *Guard) Failed(ctx context.Context, userID int, ip string) (bool, error) {
+	if g == nil {
+		return false, nil
+	}
+	locked, err := g.fail(ctx, IPKey(ip), g.IP)
+	if err != nil || userID == 0 {
+		return locked, err
+	}
+	userLocked, err := g.fail(ctx, UserKey(userID), g.User)
+	return locked || userLocked, err
+}
+
+func (g *Guard) fail(ctx context.Context, key string, p Policy) (bool, error) {
+	if p.MaxFailures <= 0 {
+		return false, nil
+	}
+	now := g.now()
+	failures, err := g.Store.AddLoginFailure(ctx, key, now.Add(-p.Window))
+	if err != nil {
+		return false, err
+	}
+	d := p.lockout(failures)
+	if d == 0 {
+		return false, nil
+	}
+	return true, g.Store.LockLogin(ctx, key, now.Add(d))
+}
+
+// Succeeded clears the user's failures. The IP's are kept, a valid login to
+// one account says nothing about guesses against others.
+func (g *Guard) Succeeded(ctx context.Context, userID int) error {
+	if g == nil {
+		return nil
+	}
+	return g.Store.ResetLoginFailures(ctx, UserKey(userID))
+}
+
+// Record adds an attempt to the user's login history
+func (g *Guard) Record(ctx context.Context, userID int, ip, userAgent, outcome string) error {
+	if g == nil {
+		return nil
+	}
+	if len(userAgent) > maxUserAgent {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgent], "")
+	}
+	return g.Store.RecordLoginAttempt(ctx, &models.LoginAttempt{UserID: userID, IP: ip, UserAgent: userAgent, Outcome: outcome})
+}
+
+// History returns the user's most recent login attempts, newest first
+func (g *Guard) History(ctx context.Context, userID, limit int) ([]models.LoginAttempt, error) {
+	if g == nil {
+		return []models.LoginAttempt{}, nil
+	}
+	return g.Store.LoginAttempts(ctx, userID, limit)
+}
@@ -0,0 +1,162 @@
+package lockout
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestPolicy_Lockout(t *testing.T) {
+	p := Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: 10 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.lockout(tt.failures), "%d failures", tt.failures)
+	}
+	assert.Zero(t, Policy{}.lockout(100))
+}
+
+// newTestGuard guard over an in-memory store whose clock starts at the real
+// time, the store stamps failures with it
+func newTestGuard(user, ip Policy) (*Guard, *time.Time) {
+	g := NewGuard(store.NewMemory(), user, ip)
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	policy := Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour, Window: 15 * time.Minute}
+
+	t.Run("locks after max failures with backoff", func(t *testing.T) {
+		g, now := newTestGuard(policy, Policy{})
+
+		for i := 0; i < 2; i++ {
+			locked, err := g.Failed(ctx, 1, "10.0.0.1")
+			require.NoError(t, err)
+			assert.False(t, locked)
+		}
+		locked, err := g.Failed(ctx, 1, "10.0.0.1")
+		require.NoError(t, err)
+		assert.True(t, locked)
+
+		remaining, err := g.Locked(ctx, UserKey(1))
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, remaining)
+		remaining, _ = g.Locked(ctx, UserKey(2))
+		assert.Zero(t, remaining)
+
+		*now = now.Add(time.Minute)
+		remaining, _ = g.Locked(ctx, UserKey(1))
+		assert.Zero(t, remaining)
+
+		// the next failure after the lock doubles it
+		_, err = g.Failed(ctx, 1, "10.0.0.1")
+		require.NoError(t, err)
+		remaining, _ = g.Locked(ctx, UserKey(1))
+		assert.Equal(t, 2*time.Minute, remaining)
+	})
+
+	t.Run("success resets the user but not the ip", func(t *testing.T) {
+		g, _ := newTestGuard(policy, policy)
+
+		for i := 0; i < 2; i++ {
+			_, err := g.Failed(ctx, 1, "10.0.0.1")
+			require.NoError(t, err)
+		}
+		require.NoError(t, g.Succeeded(ctx, 1))
+		locked, err := g.Failed(ctx, 1, "10.0.0.1")
+		require.NoError(t, err)
+		assert.True(t, locked)
+
+		remaining, _ := g.Locked(ctx, UserKey(1))
+		assert.Zero(t, remaining)
+		remaining, _ = g.Locked(ctx, IPKey("10.0.0.1"))
+		assert.Equal(t, time.Minute, remaining)
+	})
+
+	t.Run("unknown names count against the ip", func(t *testing.T) {
+		g, _ := newTestGuard(policy, policy)
+
+		for i := 0; i < 3; i++ {
+			_, err := g.Failed(ctx, 0, "10.0.0.1")
+			require.NoError(t, err)
+		}
+		remaining, _ := g.Locked(ctx, IPKey("10.0.0.1"))
+		assert.Equal(t, time.Minute, remaining)
+		remaining, _ = g.Locked(ctx, UserKey(0))
+		assert.Zero(t, remaining)
+	})
+
+	t.Run("old failures are forgotten", func(t *testing.T) {
+		g, now := newTestGuard(policy, Policy{})
+
+		for i := 0; i < 2; i++ {
+			_, err := g.Failed(ctx, 1, "10.0.0.1")
+			require.NoError(t, err)
+		}
+		*now = now.Add(policy.Window + time.Second)
+		locked, err := g.Failed(ctx, 1, "10.0.0.1")
+		require.NoError(t, err)
+		assert.False(t, locked)
+	})
+
+	t.Run("nil guard", func(t *testing.T) {
+		var g *Guard
+		locked, err := g.Failed(ctx, 1, "10.0.0.1")
+		assert.NoError(t, err)
+		assert.False(t, locked)
+		remaining, err := g.Locked(ctx, UserKey(1))
+		assert.NoError(t, err)
+		assert.Zero(t, remaining)
+		assert.NoError(t, g.Record(ctx, 1, "10.0.0.1", "curl", models.LoginFailed))
+		history, err := g.History(ctx, 1, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+	})
+}
+
+func TestGuard_History(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard(Policy{}, Policy{})
+
+	require.NoError(t, g.Record(ctx, 1, "10.0.0.1", "curl/8.0", models.LoginFailed))
+	require.NoError(t, g.Record(ctx, 1, "10.0.0.2", strings.Repeat("é", 300), models.LoginSucceeded))
+	require.NoError(t, g.Record(ctx, 2, "10.0.0.3", "curl/8.0", models.LoginSucceeded))
+
+	history, err := g.History(ctx, 1, 10)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, models.LoginSucceeded, history[0].Outcome)
+	assert.Equal(t, "10.0.0.2", history[0].IP)
+	// cut to maxUserAgent bytes without splitting a character
+	assert.Len(t, history[0].UserAgent, maxUserAgent)
+	assert.Equal(t, "curl/8.0", history[1].UserAgent)
+
+	history, err = g.History(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
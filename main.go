@@ -1,62 +1,293 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"gin-wallet2/apikey"
+	"gin-wallet2/config"
 	"gin-wallet2/handlers"
+	"gin-wallet2/lockout"
+	"gin-wallet2/metrics"
+	"gin-wallet2/mfa"
 	"gin-wallet2/middleware"
+	"gin-wallet2/migrations"
+	"gin-wallet2/models"
+	"gin-wallet2/password"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
+	"gin-wallet2/tracing"
+	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var zlog = zerolog.New(os.Stdout).With().Timestamp().Logger()
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Error loading .env file: %v", err)
+// setupLogger configures zlog and the default context logger from cfg
+func setupLogger(cfg *config.Config) {
+	var out io.Writer = os.Stdout
+	if cfg.LogFormat == "console" {
+		out = zerolog.ConsoleWriter{Out: os.Stdout}
 	}
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	zlog = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	zerolog.SetGlobalLevel(cfg.LogLevel)
+	zlog = zerolog.New(out).With().Timestamp().Logger()
 	zerolog.DefaultContextLogger = &zlog
 }
 
 func main() {
-	db := InitDB()
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Printf("failed to close database: %v", err)
+	// keygen needs neither settings nor a database
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := runKeygen(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
 		}
-	}()
+		return
+	}
 
-	r := gin.Default()
+	// fail fast, nothing starts with missing or invalid settings
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	setupLogger(cfg)
+	gin.SetMode(cfg.GinMode)
 
-	auth := handlers.NewAuthHandler(db)
-	r.POST("/register", auth.Register)
-	r.POST("/login", auth.Login)
+	migrateCmd := len(os.Args) > 1 && os.Args[1] == "migrate"
+	if migrateCmd {
+		// the subcommand decides what to apply
+		cfg.DB.AutoMigrate = false
+	}
+	// SIGTERM stops waiting for the database, and later drains in-flight requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	wallet := handlers.NewWalletHandler(db)
-	walletGroup := r.Group("/wallet", middleware.AuthMiddleware())
-	{
-		walletGroup.POST("/deposit", wallet.Deposit)
-		walletGroup.POST("/withdraw", wallet.Withdraw)
-		walletGroup.POST("/transfer", wallet.Transfer)
-		walletGroup.GET("/balance/:userID", wallet.GetBalance)
-		walletGroup.GET("/transactions/:userID", wallet.GetTransactions)
+	db, err := InitDB(ctx, cfg.DB)
+	if err != nil {
+		zlog.Fatal().
+			Err(err).
+			Msg("Database unavailable")
 	}
 
-	port := ":8080"
+	if migrateCmd {
+		err := runMigrate(ctx, db, os.Args[2:], os.Stdout)
+		_ = db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	appMetrics := metrics.New()
+	appMetrics.RegisterDB(db)
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, os.Stdout)
+	if err != nil {
+		_ = db.Close()
+		zlog.Fatal().
+			Err(err).
+			Msg("Failed to set up tracing")
+	}
+
+	// structured access log through zlog instead of gin's text logger, a
+	// server span per route before it so log lines carry the trace id
+	r := gin.New()
+	r.Use(middleware.RequestID(), otelgin.Middleware(cfg.Tracing.ServiceName), middleware.Logger(zlog), middleware.Recovery(), appMetrics.Middleware())
+	r.GET("/metrics", gin.WrapH(appMetrics.Handler()))
+	// ClientIP only believes X-Forwarded-For from these, the per-IP rate limit depends on it
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		_ = db.Close()
+		zlog.Fatal().
+			Err(err).
+			Msg("Invalid TRUSTED_PROXIES")
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		_ = db.Close()
+		zlog.Fatal().
+			Err(err).
+			Msg("Failed to load migrations")
+	}
+	health := handlers.NewHealthHandler(cfg.HealthTimeout,
+		handlers.Check{Name: "database", Check: db.PingContext},
+		handlers.Check{Name: "migrations", Check: migrator.CheckCurrent},
+		shutdownCheck(ctx),
+	)
+	r.GET("/healthz", health.Live)
+	r.GET("/readyz", health.Ready)
+
+	// every store call is a child span of the request span
+	pg := store.NewPostgres(db)
+	users, tokenStore, wallets, logins, mfaStore := store.TraceUsers(pg), store.TraceTokens(pg), store.TraceWallets(pg), store.TraceLogins(pg), store.TraceMFA(pg)
+	apiKeyService := apikey.NewService(store.TraceAPIKeys(pg))
+
+	// asymmetric keys let other services verify tokens without being able to mint them
+	keys := token.HMACKeys(cfg.JWT.Secret)
+	if cfg.JWT.SigningKeyFile != "" {
+		if keys, err = token.LoadKeys(cfg.JWT.SigningKeyFile, cfg.JWT.VerificationKeyFiles...); err != nil {
+			_ = db.Close()
+			zlog.Fatal().
+				Err(err).
+				Msg("Failed to load token keys")
+		}
+	}
 	zlog.Info().
-		Str("port", port).
-		Msg("Server starting")
+		Str("kid", keys.Signing().ID).
+		Str("alg", keys.Signing().Algorithm).
+		Msg("Token signing key loaded")
+	tokens := token.NewManager(keys, cfg.JWT.AccessTTL, cfg.JWT.RefreshTTL, tokenStore, users)
+	tokens.Issuer = cfg.JWT.Issuer
+	tokens.Audience = cfg.JWT.Audience
+	authRequired := middleware.AuthMiddleware(tokens)
+
+	limiter := middleware.NewMemoryRateLimiter()
+	authLimit := middleware.RateLimitMiddleware(limiter, "auth", middleware.RateLimit(cfg.RateLimit.Auth), middleware.KeyByIP)
+	walletLimit := middleware.RateLimitMiddleware(limiter, "wallet", middleware.RateLimit(cfg.RateLimit.Wallet), middleware.KeyByUser)
+
+	auth := handlers.NewAuthHandler(users, tokens)
+	auth.Policy = password.NewPolicy(cfg.Password.MinLength)
+	auth.Hasher = password.Hasher{
+		Algorithm:  cfg.Password.Hash,
+		BcryptCost: cfg.Password.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      uint32(cfg.Password.Argon2Memory),
+			Iterations:  uint32(cfg.Password.Argon2Iterations),
+			Parallelism: uint8(cfg.Password.Argon2Parallelism),
+		},
+	}
+	auth.Lockout = lockout.NewGuard(logins,
+		lockout.Policy{MaxFailures: cfg.Lockout.MaxFailures, Lockout: cfg.Lockout.Duration, MaxLockout: cfg.Lockout.MaxDuration, Window: cfg.Lockout.Window},
+		lockout.Policy{MaxFailures: cfg.Lockout.IPMaxFailures, Lockout: cfg.Lockout.Duration, MaxLockout: cfg.Lockout.MaxDuration, Window: cfg.Lockout.Window},
+	)
+	// wrong two-factor codes count as failed logins
+	twoFactor := mfa.NewService(mfaStore, cfg.MFA.Issuer)
+	twoFactor.Key = cfg.MFA.EncryptionKey
+	twoFactor.Lockout = auth.Lockout
+	auth.MFA = twoFactor
+	auth.Metrics = appMetrics
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+	r.POST("/register", authLimit, auth.Register)
+	r.POST("/login", authLimit, auth.Login)
+	r.POST("/login/2fa", authLimit, auth.LoginMFA)
+	r.POST("/token/refresh", auth.Refresh)
+	r.POST("/logout", authRequired, auth.Logout)
+	r.POST("/logout/all", authRequired, auth.LogoutAll)
+	profile := handlers.NewUserHandler(users)
+	r.GET("/me", authRequired, profile.Me)
+	r.PATCH("/me", authRequired, profile.UpdateMe)
+	r.GET("/me/logins", authRequired, auth.Logins)
+	r.POST("/me/password", authRequired, auth.ChangePassword)
+	mfaHandler := handlers.NewMFAHandler(twoFactor, users)
+	r.POST("/me/2fa", authRequired, mfaHandler.Enroll)
+	r.POST("/me/2fa/confirm", authRequired, mfaHandler.Confirm)
+	r.POST("/me/2fa/disable", authRequired, mfaHandler.Disable)
+	// keys are managed with access tokens only, a leaked key cannot mint more
+	apiKeys := handlers.NewAPIKeyHandler(apiKeyService)
+	apiKeys.MFA = twoFactor
+	r.POST("/me/api-keys", authRequired, apiKeys.Create)
+	r.GET("/me/api-keys", authRequired, apiKeys.List)
+	r.DELETE("/me/api-keys/:id", authRequired, apiKeys.Revoke)
+
+	wallet := handlers.NewWalletHandler(wallets)
+	wallet.MFA = twoFactor
+	wallet.StepUpAmount = cfg.MFA.StepUpAmount
+	wallet.Metrics = appMetrics
+	idempotency := middleware.Idempotency(middleware.NewPostgresIdempotencyStore(db), cfg.IdempotencyTTL)
+
+	// services call the wallet with scoped API keys, users with access tokens
+	walletGroup := r.Group("/wallet", middleware.AuthOrAPIKey(tokens, apiKeyService), walletLimit)
+	{
+		// money moving endpoints, retries with the same Idempotency-Key are
+		// replayed. Scopes are checked first so a refused key is not replayed.
+		walletGroup.POST("/deposit", middleware.RequireScope(models.ScopeWalletDeposit), idempotency, wallet.Deposit)
+		walletGroup.POST("/withdraw", middleware.RequireScope(models.ScopeWalletWithdraw), idempotency, wallet.Withdraw)
+		walletGroup.POST("/transfer", middleware.RequireScope(models.ScopeWalletTransfer), idempotency, wallet.Transfer)
+		readGroup := walletGroup.Group("", middleware.RequireScope(models.ScopeWalletRead))
+		readGroup.GET("/me/balance", wallet.GetBalance)
+		readGroup.GET("/me/transactions", wallet.GetTransactions)
+		readGroup.GET("/me/transactions/:id", wallet.GetTransaction)
+		readGroup.GET("/balance/:userID", wallet.GetBalance)
+		readGroup.GET("/transactions/:userID", middleware.Deprecated("/wallet/me/transactions"), wallet.GetTransactions)
+	}
+
+	// staff routes, handlers may act on any user. Support looks users and
+	// wallets up, auditors also review the ledger, only admins move money.
+	staff := middleware.RequireRole(models.RoleSupport, models.RoleAdmin, models.RoleAuditor)
+	reviewers := middleware.RequireRole(models.RoleAdmin, models.RoleAuditor)
+	admins := middleware.RequireRole(models.RoleAdmin)
+	admin := handlers.NewAdminHandler(users, wallets, tokenStore)
+	adminGroup := r.Group("/admin", authRequired)
+	{
+		adminGroup.GET("/users", staff, admin.SearchUsers)
+		adminGroup.GET("/users/:userID", staff, admin.User)
+		adminGroup.PUT("/users/:userID/role", admins, admin.SetRole)
+
+		adminWalletGroup := adminGroup.Group("/wallet")
+		adminMoneyGroup := adminWalletGroup.Group("", admins, idempotency)
+		adminMoneyGroup.POST("/deposit", wallet.Deposit)
+		adminMoneyGroup.POST("/withdraw", wallet.Withdraw)
+		adminMoneyGroup.POST("/transfer", wallet.Transfer)
+		adminMoneyGroup.POST("/adjustments", wallet.Adjust)
+		adminWalletGroup.GET("/adjustments", reviewers, wallet.Adjustments)
+		adminWalletGroup.GET("/balance/:userID", staff, wallet.GetBalance)
+		adminWalletGroup.GET("/transactions/:userID", staff, wallet.GetTransactions)
+		adminWalletGroup.GET("/transactions/:userID/:id", staff, wallet.GetTransaction)
+
+		adminWalletGroup.GET("/postings/:userID", reviewers, wallet.Postings)
+		adminGroup.GET("/ledger/verify", reviewers, wallet.VerifyLedger)
+		adminGroup.GET("/ledger/verify-history", reviewers, wallet.VerifyHistory)
+	}
 
-	if err := r.Run(port); err != nil {
+	addr := cfg.Addr()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = db.Close()
 		zlog.Fatal().
 			Err(err).
-			Str("port", port).
+			Str("port", addr).
 			Msg("Server failed to start")
 	}
+	zlog.Info().
+		Str("port", addr).
+		Msg("Server starting")
+
+	srv, cancelRequests := newServer(cfg.HTTP, addr, r)
+	err = serve(ctx, srv, ln, cfg.HTTP.ShutdownTimeout, cancelRequests)
+	if errors.Is(err, context.DeadlineExceeded) {
+		zlog.Warn().
+			Dur("timeout", cfg.HTTP.ShutdownTimeout).
+			Msg("Shutdown timed out, cancelled remaining requests")
+	} else if err != nil {
+		zlog.Error().
+			Err(err).
+			Msg("Server failed")
+	}
+
+	// waits for queries of cancelled requests to return
+	if err := db.Close(); err != nil {
+		zlog.Error().
+			Err(err).
+			Msg("Failed to close database")
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		zlog.Error().
+			Err(err).
+			Msg("Failed to flush traces")
+	}
+	cancelFlush()
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		os.Exit(1)
+	}
+	zlog.Info().Msg("Server stopped")
 }
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"gin-wallet2/handlers"
+	"gin-wallet2/store"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -20,7 +21,7 @@ func setupTestRouter(handler *handlers.WalletHandler) *gin.Engine {
 	walletGroup := r.Group("/wallet")
 	walletGroup.Use(func(c *gin.Context) {
 		// Mock authentication middleware
-		c.Set("userID", uint(1)) // Set a default authenticated user
+		c.Set("userID", 1) // Set a default authenticated user
 		c.Next()
 	})
 	{
@@ -42,7 +43,7 @@ func TestWalletHandler_Deposit(t *testing.T) {
 	}
 	defer db.Close()
 
-	handler := handlers.NewWalletHandler(db)
+	handler := handlers.NewWalletHandler(store.NewPostgres(db))
 	router := setupTestRouter(handler)
 
 	tests := []struct {
@@ -60,11 +61,15 @@ func TestWalletHandler_Deposit(t *testing.T) {
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE users").
-					WithArgs(100.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT balance FROM users WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50.00"))
+				mock.ExpectQuery("UPDATE users").
+					WithArgs("100.00", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("150.00"))
+				expectJournalEntry(mock, 10, "deposit", "Deposit to wallet", "system:cash", "-100.00", "user:1", "100.00")
 				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "deposit", 100.0, "Deposit to wallet").
+					WithArgs(1, "deposit", "100.00", "Deposit to wallet", "50.00", "150.00", 10).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
@@ -83,6 +88,7 @@ func TestWalletHandler_Deposit(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody: map[string]interface{}{
 				"error": "Invalid input",
+				"code":  "invalid_input",
 			},
 		},
 		{
@@ -96,7 +102,8 @@ func TestWalletHandler_Deposit(t *testing.T) {
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
-				"error": "Failed to start transaction",
+				"error": "Failed to complete transaction",
+				"code":  "internal_error",
 			},
 		},
 		{
@@ -107,14 +114,18 @@ func TestWalletHandler_Deposit(t *testing.T) {
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE users").
-					WithArgs(100.0, 1).
+				mock.ExpectQuery("SELECT balance FROM users WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50.00"))
+				mock.ExpectQuery("UPDATE users").
+					WithArgs("100.00", 1).
 					WillReturnError(sql.ErrConnDone)
 				mock.ExpectRollback()
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Failed to update balance",
+				"code":  "internal_error",
 			},
 		},
 		{
@@ -125,17 +136,22 @@ func TestWalletHandler_Deposit(t *testing.T) {
 			},
 			setupMock: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE users").
-					WithArgs(100.0, 1).
-					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("SELECT balance FROM users WHERE id = \\$1 FOR UPDATE").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("50.00"))
+				mock.ExpectQuery("UPDATE users").
+					WithArgs("100.00", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("150.00"))
+				expectJournalEntry(mock, 10, "deposit", "Deposit to wallet", "system:cash", "-100.00", "user:1", "100.00")
 				mock.ExpectExec("INSERT INTO transactions").
-					WithArgs(1, "deposit", 100.0, "Deposit to wallet").
+					WithArgs(1, "deposit", "100.00", "Deposit to wallet", "50.00", "150.00", 10).
 					WillReturnError(sql.ErrConnDone)
 				mock.ExpectRollback()
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: map[string]interface{}{
 				"error": "Failed to record transaction",
+				"code":  "internal_error",
 			},
 		},
 	}
@@ -163,3 +179,16 @@ func TestWalletHandler_Deposit(t *testing.T) {
 		})
 	}
 }
+
+// expectJournalEntry expects a journal entry followed by its postings, given
+// as account code / amount pairs
+func expectJournalEntry(mock sqlmock.Sqlmock, entryID int, kind, description string, postings ...string) {
+	mock.ExpectQuery("INSERT INTO journal_entries").
+		WithArgs(kind, description).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(entryID))
+	for i := 0; i+1 < len(postings); i += 2 {
+		mock.ExpectExec("INSERT INTO postings").
+			WithArgs(entryID, postings[i+1], postings[i]).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+}
@@ -0,0 +1,139 @@
+// Package metrics Prometheus metrics for HTTP requests, the database pool and
+// wallet and auth operations
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"gin-wallet2/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wallet"
+
+// Outcomes of an operation besides the codes of domain errors
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Metrics collectors of the service, registered on their own registry.
+// WalletOperation and AuthOperation are no-ops on a nil *Metrics so handlers
+// work without metrics.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests       *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	walletOps      *prometheus.CounterVec
+	walletAmount   *prometheus.CounterVec
+	authOperations *prometheus.CounterVec
+}
+
+// New registers the service collectors together with the Go runtime and
+// process collectors
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		walletOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Deposits, withdrawals and transfers by outcome.",
+		}, []string{"operation", "outcome"}),
+		walletAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "amount_moved_total",
+			Help:      "Total amount moved by successful operations.",
+		}, []string{"operation"}),
+		authOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_operations_total",
+			Help:      "Registrations, logins, token refreshes, logouts and password changes by outcome.",
+		}, []string{"operation", "outcome"}),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.walletOps, m.walletAmount, m.authOperations,
+	)
+	return m
+}
+
+// RegisterDB exports the connection pool statistics of db
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, "wallet"))
+}
+
+// Handler serves the registry for /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Middleware counts and times requests by route template, unmatched requests
+// share one label so scanners cannot blow up the series count
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.duration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// WalletOperation records a deposit, withdrawal or transfer that finished with
+// err, and the amount moved if it succeeded
+func (m *Metrics) WalletOperation(operation string, amount models.Money, err error) {
+	if m == nil {
+		return
+	}
+	outcome := Outcome(err)
+	m.walletOps.WithLabelValues(operation, outcome).Inc()
+	if outcome == OutcomeSuccess {
+		m.walletAmount.WithLabelValues(operation).Add(amount.Float64())
+	}
+}
+
+// AuthOperation records a registration, login, refresh or logout that finished with err
+func (m *Metrics) AuthOperation(operation string, err error) {
+	if m == nil {
+		return
+	}
+	m.authOperations.WithLabelValues(operation, Outcome(err)).Inc()
+}
+
+// Outcome label for err: success, the code of a domain error such as
+// insufficient_funds, or error for anything unexpected
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	var domainErr *models.Error
+	if errors.As(err, &domainErr) && domainErr.Code != models.CodeInternal {
+		return domainErr.Code
+	}
+	return OutcomeError
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gin-wallet2/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "success"},
+		{models.ErrInsufficientFunds, "insufficient_funds"},
+		{fmt.Errorf("withdraw: %w", models.ErrUserNotFound), "user_not_found"},
+		{sql.ErrConnDone, "error"},
+		{&models.Error{Code: models.CodeInternal, Message: "boom"}, "error"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Outcome(tt.err), fmt.Sprint(tt.err))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New()
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/wallet/balance/:userID", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/wallet/balance/1", "/wallet/balance/2", "/wp-login.php"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// routes are labelled by template, not by path
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry, strings.NewReader(`
+# HELP wallet_http_requests_total HTTP requests by method, route and status.
+# TYPE wallet_http_requests_total counter
+wallet_http_requests_total{method="GET",route="/wallet/balance/:userID",status="200"} 2
+wallet_http_requests_total{method="GET",route="unmatched",status="404"} 1
+`), "wallet_http_requests_total"))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.duration))
+}
+
+func TestWalletOperation(t *testing.T) {
+	m := New()
+	m.WalletOperation(models.TxTypeDeposit, models.MustParseMoney("10.50"), nil)
+	m.WalletOperation(models.TxTypeDeposit, models.MustParseMoney("4.50"), nil)
+	m.WalletOperation(models.TxTypeWithdraw, models.MustParseMoney("100"), models.ErrInsufficientFunds)
+	m.WalletOperation(models.TxTypeTransfer, models.MustParseMoney("1"), errors.New("connection reset"))
+
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry, strings.NewReader(`
+# HELP wallet_operations_total Deposits, withdrawals and transfers by outcome.
+# TYPE wallet_operations_total counter
+wallet_operations_total{operation="deposit",outcome="success"} 2
+wallet_operations_total{operation="transfer",outcome="error"} 1
+wallet_operations_total{operation="withdraw",outcome="insufficient_funds"} 1
+# HELP wallet_amount_moved_total Total amount moved by successful operations.
+# TYPE wallet_amount_moved_total counter
+wallet_amount_moved_total{operation="deposit"} 15
+`), "wallet_operations_total", "wallet_amount_moved_total"))
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.WalletOperation(models.TxTypeDeposit, models.MustParseMoney("1"), nil)
+		m.AuthOperation("login", nil)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	m := New()
+	m.RegisterDB(db)
+	m.AuthOperation("login", models.ErrInvalidCredentials)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `wallet_auth_operations_total{operation="login",outcome="unauthorized"} 1`)
+	assert.Contains(t, w.Body.String(), `go_sql_open_connections{db_name="wallet"}`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
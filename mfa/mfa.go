@@ -0,0 +1,258 @@
+// Package mfa TOTP two-factor authentication: enrollment with an otpauth URI,
+// confirmation with a first code, single use recovery codes and verification
+// of codes for logins and large transfers
+package mfa
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"gin-wallet2/lockout"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"strings"
+	"time"
+)
+
+// RecoveryCodes number of recovery codes handed out on confirmation
+const RecoveryCodes = 10
+
+// sealedPrefix marks secrets encrypted with Service.Key
+const sealedPrefix = "enc:"
+
+// recoveryEncoding lowercase base32 without padding, easy to read out and type
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Enrollment pending secret, shown to the user once
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// Service enrolls users and verifies their codes. Key, if set, encrypts the
+// secrets at rest; secrets stored before it was set stay readable. Wrong codes
+// count as failed logins on Lockout, which may be nil. A nil Service has
+// two-factor authentication off for everyone.
+type Service struct {
+	Store   store.MFAStore
+	Issuer  string
+	Key     []byte
+	Lockout *lockout.Guard
+
+	now func() time.Time
+}
+
+// NewService new two-factor service, issuer names the app in authenticator apps
+func NewService(s store.MFAStore, issuer string) *Service {
+	return &Service{Store: s, Issuer: issuer, now: time.Now}
+}
+
+// Enabled reports whether the user has confirmed two-factor authentication
+func (s *Service) Enabled(ctx context.Context, userID int) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+	mfa, err := s.Store.MFA(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return mfa.Enabled(), nil
+}
+
+// Enroll starts enrollment for user with a new secret, replacing a pending
+// one, models.ErrMFAAlreadyEnabled if it is already on
+func (s *Service) Enroll(ctx context.Context, user *models.User) (*Enrollment, error) {
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Store.StartMFA(ctx, user.ID, sealed); err != nil {
+		return nil, err
+	}
+	return &Enrollment{Secret: secret, URI: URI(s.Issuer, user.Name, secret)}, nil
+}
+
+// Confirm enables two-factor authentication once code matches the pending
+// secret and returns the recovery codes, which are only stored hashed
+func (s *Service) Confirm(ctx context.Context, userID int, ip, code string) ([]string, error) {
+	if err := s.checkLocked(ctx, userID); err != nil {
+		return nil, err
+	}
+	mfa, err := s.Store.MFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Enabled() {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+	if mfa.Secret == "" {
+		return nil, models.ErrMFANotEnrolled
+	}
+
+	counter, ok, err := s.match(mfa, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.failed(ctx, userID, ip)
+	}
+
+	codes := make([]string, RecoveryCodes)
+	hashes := make([]string, RecoveryCodes)
+	for i := range codes {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		c := recoveryEncoding.EncodeToString(raw)
+		codes[i] = c[:4] + "-" + c[4:]
+		hashes[i] = hashRecoveryCode(c)
+	}
+	if err := s.Store.EnableMFA(ctx, userID, counter, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code of the user. Every
+// code works once, a wrong one is models.ErrInvalidOTP and counts as a failed
+// login from ip. models.ErrMFANotEnrolled if two-factor authentication is off.
+func (s *Service) Verify(ctx context.Context, userID int, ip, code string) error {
+	if s == nil {
+		return models.ErrMFANotEnrolled
+	}
+	if err := s.checkLocked(ctx, userID); err != nil {
+		return err
+	}
+	mfa, err := s.Store.MFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !mfa.Enabled() {
+		return models.ErrMFANotEnrolled
+	}
+
+	var ok bool
+	if code = strings.TrimSpace(code); len(code) == Digits {
+		var counter int64
+		counter, ok, err = s.match(mfa, code)
+		if err == nil && ok {
+			// a code seen before is a replay
+			ok, err = s.Store.UseTOTPCounter(ctx, userID, counter)
+		}
+	} else {
+		ok, err = s.Store.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.failed(ctx, userID, ip)
+	}
+	return nil
+}
+
+// Disable turns two-factor authentication off after checking code
+func (s *Service) Disable(ctx context.Context, userID int, ip, code string) error {
+	if err := s.Verify(ctx, userID, ip, code); err != nil {
+		return err
+	}
+	return s.Store.DisableMFA(ctx, userID)
+}
+
+// checkLocked refuses codes while the user is locked out
+func (s *Service) checkLocked(ctx context.Context, userID int) error {
+	remaining, err := s.Lockout.Locked(ctx, lockout.UserKey(userID))
+	if err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return models.ErrAccountLocked
+	}
+	return nil
+}
+
+// failed counts a wrong code and returns models.ErrInvalidOTP
+func (s *Service) failed(ctx context.Context, userID int, ip string) error {
+	if _, err := s.Lockout.Failed(ctx, userID, ip); err != nil {
+		return err
+	}
+	return models.ErrInvalidOTP
+}
+
+func (s *Service) match(mfa *models.MFA, code string) (int64, bool, error) {
+	secret, err := s.open(mfa.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+	return Match(secret, code, s.now())
+}
+
+// hashRecoveryCode sha256 of the code without separators, ignoring case
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// aead AES-256-GCM keyed with the sha256 of Key
+func (s *Service) aead() (cipher.AEAD, error) {
+	key := sha256.Sum256(s.Key)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts secret for storage when a Key is set
+func (s *Service) seal(secret string) (string, error) {
+	if len(s.Key) == 0 {
+		return secret, nil
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+// open reverses seal
+func (s *Service) open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	if len(s.Key) == 0 {
+		return "", errors.New("mfa: secret is encrypted but no key is set")
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", errors.New("mfa: malformed encrypted secret")
+	}
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < aead.NonceSize() {
+		return "", errors.New("mfa: malformed encrypted secret")
+	}
+	secret, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("mfa: cannot decrypt secret, wrong key")
+	}
+	return string(secret), nil
+}
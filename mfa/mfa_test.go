@@ -0,0 +1,145 @@
+package mfa
+
+import (
+	"context"
+	"gin-wallet2/lockout"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestService service over an in-memory store with a settable clock
+func newTestService(t *testing.T) (*Service, *store.Memory, *time.Time) {
+	mem := store.NewMemory()
+	s := NewService(mem, "gin-wallet")
+	now := time.Unix(1_700_000_000, 0)
+	s.now = func() time.Time { return now }
+	return s, mem, &now
+}
+
+// enroll enrolls and confirms user 1 and returns the secret and recovery codes
+func enroll(t *testing.T, s *Service, now time.Time) (string, []string) {
+	ctx := context.Background()
+	e, err := s.Enroll(ctx, &models.User{ID: 1, Name: "alice"})
+	require.NoError(t, err)
+	code, _ := Code(e.Secret, Counter(now))
+	codes, err := s.Confirm(ctx, 1, "10.0.0.1", code)
+	require.NoError(t, err)
+	return e.Secret, codes
+}
+
+func TestService_Enroll(t *testing.T) {
+	ctx := context.Background()
+	s, _, now := newTestService(t)
+
+	enabled, err := s.Enabled(ctx, 1)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+	_, err = s.Confirm(ctx, 1, "10.0.0.1", "123456")
+	assert.ErrorIs(t, err, models.ErrMFANotEnrolled)
+
+	e, err := s.Enroll(ctx, &models.User{ID: 1, Name: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, URI("gin-wallet", "alice", e.Secret), e.URI)
+
+	// pending until confirmed
+	enabled, _ = s.Enabled(ctx, 1)
+	assert.False(t, enabled)
+	assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", "123456"), models.ErrMFANotEnrolled)
+
+	_, err = s.Confirm(ctx, 1, "10.0.0.1", "000000")
+	assert.ErrorIs(t, err, models.ErrInvalidOTP)
+
+	code, _ := Code(e.Secret, Counter(*now))
+	codes, err := s.Confirm(ctx, 1, "10.0.0.1", code)
+	require.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodes)
+	assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, codes[0])
+
+	enabled, _ = s.Enabled(ctx, 1)
+	assert.True(t, enabled)
+	_, err = s.Enroll(ctx, &models.User{ID: 1, Name: "alice"})
+	assert.ErrorIs(t, err, models.ErrMFAAlreadyEnabled)
+	_, err = s.Confirm(ctx, 1, "10.0.0.1", code)
+	assert.ErrorIs(t, err, models.ErrMFAAlreadyEnabled)
+
+	// the confirmation code can not be used again
+	assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", code), models.ErrInvalidOTP)
+}
+
+func TestService_Verify(t *testing.T) {
+	ctx := context.Background()
+	s, _, now := newTestService(t)
+	secret, codes := enroll(t, s, *now)
+
+	*now = now.Add(Period)
+	code, _ := Code(secret, Counter(*now))
+	assert.NoError(t, s.Verify(ctx, 1, "10.0.0.1", code))
+	assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", code), models.ErrInvalidOTP, "replayed")
+
+	// recovery codes work once, ignoring case and separators
+	assert.NoError(t, s.Verify(ctx, 1, "10.0.0.1", strings.ToUpper(codes[0])))
+	assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", codes[0]), models.ErrInvalidOTP)
+	assert.NoError(t, s.Verify(ctx, 1, "10.0.0.1", strings.ReplaceAll(codes[1], "-", "")))
+	assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", "aaaa-aaaa"), models.ErrInvalidOTP)
+
+	var none *Service
+	enabled, err := none.Enabled(ctx, 1)
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+}
+
+func TestService_Lockout(t *testing.T) {
+	ctx := context.Background()
+	s, mem, now := newTestService(t)
+	s.Lockout = lockout.NewGuard(mem, lockout.Policy{MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}, lockout.Policy{})
+	secret, _ := enroll(t, s, *now)
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", "000000"), models.ErrInvalidOTP)
+	}
+	*now = now.Add(Period)
+	code, _ := Code(secret, Counter(*now))
+	assert.ErrorIs(t, s.Verify(ctx, 1, "10.0.0.1", code), models.ErrAccountLocked)
+}
+
+func TestService_Disable(t *testing.T) {
+	ctx := context.Background()
+	s, _, now := newTestService(t)
+	_, codes := enroll(t, s, *now)
+
+	assert.ErrorIs(t, s.Disable(ctx, 1, "10.0.0.1", "000000"), models.ErrInvalidOTP)
+	require.NoError(t, s.Disable(ctx, 1, "10.0.0.1", codes[0]))
+	enabled, _ := s.Enabled(ctx, 1)
+	assert.False(t, enabled)
+	assert.ErrorIs(t, s.Disable(ctx, 1, "10.0.0.1", codes[1]), models.ErrMFANotEnrolled)
+}
+
+func TestService_SealedSecret(t *testing.T) {
+	ctx := context.Background()
+	s, mem, now := newTestService(t)
+	s.Key = []byte("an encryption key of enough length")
+	secret, _ := enroll(t, s, *now)
+
+	stored, err := mem.MFA(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Secret, sealedPrefix))
+	assert.NotContains(t, stored.Secret, secret)
+
+	*now = now.Add(Period)
+	code, _ := Code(secret, Counter(*now))
+	assert.NoError(t, s.Verify(ctx, 1, "10.0.0.1", code))
+
+	// a different key can not read it
+	s.Key = []byte("another encryption key of enough length")
+	*now = now.Add(Period)
+	code, _ = Code(secret, Counter(*now))
+	err = s.Verify(ctx, 1, "10.0.0.1", code)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, models.ErrInvalidOTP)
+}
@@ -0,0 +1,74 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// rfcSecret SHA1 key of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode_RFC6238(t *testing.T) {
+	// last six digits of the RFC's eight digit codes
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "t=%d", tt.unix)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, _ := Code(rfcSecret, Counter(now))
+
+	counter, ok, err := Match(rfcSecret, code, now)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	// one step of drift either way
+	_, ok, _ = Match(rfcSecret, code, now.Add(Period))
+	assert.True(t, ok)
+	_, ok, _ = Match(rfcSecret, code, now.Add(-Period))
+	assert.True(t, ok)
+	_, ok, _ = Match(rfcSecret, code, now.Add(2*Period))
+	assert.False(t, ok)
+
+	_, ok, _ = Match(rfcSecret, "000000", now)
+	assert.False(t, ok)
+	_, _, err = Match("not base32!", code, now)
+	assert.Error(t, err)
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	require.NoError(t, err)
+	b, _ := NewSecret()
+	assert.Len(t, a, 32)
+	assert.NotEqual(t, a, b)
+}
+
+func TestURI(t *testing.T) {
+	assert.Equal(t,
+		"otpauth://totp/gin-wallet:alice%20smith?algorithm=SHA1&digits=6&issuer=gin-wallet&period=30&secret=JBSWY3DPEHPK3PXP",
+		URI("gin-wallet", "alice smith", "JBSWY3DPEHPK3PXP"))
+}
@@ -2,25 +2,26 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
+	"gin-wallet2/apikey"
+	"gin-wallet2/models"
+	"gin-wallet2/token"
 	"net/http"
-	"os"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
-
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-
-// AuthMiddleware auth middleware
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware auth middleware, rejects expired and revoked access tokens
+func AuthMiddleware(tokens *token.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// 从 Header 获取 Authorization 字段
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required", "code": models.CodeUnauthorized})
 			c.Abort()
 			return
 		}
@@ -28,25 +29,102 @@ func AuthMiddleware() gin.HandlerFunc {
 		// 提取 Token
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// 验证 Token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return jwtSecret, nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		// 验证 Token, including the revocation denylist
+		claims, err := tokens.Verify(c.Request.Context(), tokenString)
+		if errors.Is(err, models.ErrInvalidToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token", "code": models.CodeUnauthorized})
+			c.Abort()
+			return
+		} else if err != nil {
+			_ = c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify token", "code": models.CodeInternal})
 			c.Abort()
 			return
 		}
 
 		// 将用户 ID 存入上下文
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("userID", int(claims["userID"].(float64)))
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		c.Set("userID", claims.UserID)
+		c.Set("role", claims.Role)
+		c.Set("tokenClaims", claims)
+		addLogFields(c, func(l zerolog.Context) zerolog.Context {
+			return l.Int("user_id", claims.UserID)
+		})
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int("user.id", claims.UserID))
+	}
+}
+
+// AuthOrAPIKey AuthMiddleware that also accepts an API key as the Bearer
+// credential. Key requests act as the key's owner with role user, RequireScope
+// limits them to the key's scopes.
+func AuthOrAPIKey(tokens *token.Manager, keys *apikey.Service) gin.HandlerFunc {
+	tokenAuth := AuthMiddleware(tokens)
+	return func(c *gin.Context) {
+		credential, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || !apikey.IsKey(credential) {
+			tokenAuth(c)
+			return
+		}
+
+		key, err := keys.Authenticate(c.Request.Context(), credential)
+		if errors.Is(err, models.ErrInvalidAPIKey) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": models.ErrInvalidAPIKey.Message, "code": models.CodeUnauthorized})
+			c.Abort()
+			return
+		} else if err != nil {
+			_ = c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key", "code": models.CodeInternal})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", key.UserID)
+		c.Set("role", models.RoleUser)
+		c.Set("apiKey", key)
+		addLogFields(c, func(l zerolog.Context) zerolog.Context {
+			return l.Int("user_id", key.UserID).Int("api_key_id", key.ID)
+		})
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Int("user.id", key.UserID), attribute.Int("api_key.id", key.ID))
+	}
+}
+
+// RequireScope only lets API key requests through if the key was granted
+// scope, requests with an access token are not limited. Must be used after
+// AuthOrAPIKey.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get("apiKey")
+		if !ok {
+			return
+		}
+		if key, _ := v.(*models.APIKey); key == nil || !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": models.ErrInsufficientScope.Message, "code": models.CodeInsufficientScope})
 			c.Abort()
+			return
+		}
+	}
+}
+
+// RequireRole only lets callers with one of roles through. Staff, any role
+// but models.RoleUser, are marked as an admin override, allowing handlers to
+// act on accounts other than the caller's. Must be used after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		allowed := false
+		for _, r := range roles {
+			if r == role {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": models.ErrInsufficientRole.Message, "code": models.CodeForbidden})
+			c.Abort()
+			return
+		}
+		if role != models.RoleUser {
+			c.Set("adminOverride", true)
 		}
+		c.Next()
 	}
 }
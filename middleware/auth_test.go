@@ -1,7 +1,13 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"gin-wallet2/apikey"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
 	"net/http"
 	"net/http/httptest"
 	"runtime"
@@ -18,16 +24,27 @@ func TestAuthMiddleware(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 
+	secret := []byte("test-secret")
+	tokens := token.NewManager(token.HMACKeys(secret), 15*time.Minute, time.Hour, store.NewMemory(), store.NewMemory())
+
 	// Helper function to create a valid token
 	createToken := func(userID int) string {
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"userID": userID,
-			"exp":    time.Now().Add(time.Hour * 24).Unix(),
-		})
-		tokenString, _ := token.SignedString(jwtSecret)
+		pair, err := tokens.Issue(context.Background(), &models.User{ID: userID})
+		assert.NoError(t, err)
+		return pair.AccessToken
+	}
+
+	// Helper function to sign arbitrary claims
+	signClaims := func(claims jwt.MapClaims) string {
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
 		return tokenString
 	}
 
+	revoked := createToken(7)
+	claims, err := tokens.Verify(context.Background(), revoked)
+	assert.NoError(t, err)
+	assert.NoError(t, tokens.Logout(context.Background(), claims))
+
 	tests := []struct {
 		name           string
 		setupHeader    func() string
@@ -52,6 +69,28 @@ func TestAuthMiddleware(t *testing.T) {
 			expectedStatus: http.StatusUnauthorized,
 			expectedUserID: nil,
 		},
+		{
+			name: "Expired token",
+			setupHeader: func() string {
+				return "Bearer " + signClaims(jwt.MapClaims{"userID": 123, "jti": "a", "exp": time.Now().Add(-time.Minute).Unix()})
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedUserID: nil,
+		},
+		{
+			name: "Token without jti",
+			setupHeader: func() string {
+				return "Bearer " + signClaims(jwt.MapClaims{"userID": 123, "exp": time.Now().Add(time.Hour).Unix()})
+			},
+			expectedStatus: http.StatusUnauthorized,
+			expectedUserID: nil,
+		},
+		{
+			name:           "Revoked token",
+			setupHeader:    func() string { return "Bearer " + revoked },
+			expectedStatus: http.StatusUnauthorized,
+			expectedUserID: nil,
+		},
 		{
 			name:           "Valid token",
 			setupHeader:    func() string { return "Bearer " + createToken(123) },
@@ -67,7 +106,7 @@ func TestAuthMiddleware(t *testing.T) {
 			_, r := gin.CreateTestContext(w)
 
 			// Add test endpoint with middleware
-			r.Use(AuthMiddleware())
+			r.Use(AuthMiddleware(tokens))
 			r.GET("/test", func(c *gin.Context) {
 				userID, exists := c.Get("userID")
 				if exists {
@@ -95,3 +134,124 @@ func TestAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthOrAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	mem := store.NewMemory()
+	tokens := token.NewManager(token.HMACKeys([]byte("test-secret")), 15*time.Minute, time.Hour, mem, mem)
+	keys := apikey.NewService(mem)
+
+	readKey, _, err := keys.Create(ctx, 5, "reporting", []string{models.ScopeWalletRead})
+	assert.NoError(t, err)
+	revokedKey, revoked, err := keys.Create(ctx, 5, "old", []string{models.ScopeWalletRead, models.ScopeWalletDeposit})
+	assert.NoError(t, err)
+	assert.NoError(t, keys.Revoke(ctx, 5, revoked.ID))
+	pair, err := tokens.Issue(ctx, &models.User{ID: 9})
+	assert.NoError(t, err)
+
+	r := gin.New()
+	auth := AuthOrAPIKey(tokens, keys)
+	handler := func(c *gin.Context) {
+		_, viaKey := c.Get("apiKey")
+		c.JSON(http.StatusOK, gin.H{"userID": c.GetInt("userID"), "role": c.GetString("role"), "apiKey": viaKey})
+	}
+	r.GET("/balance", auth, RequireScope(models.ScopeWalletRead), handler)
+	r.POST("/deposit", auth, RequireScope(models.ScopeWalletDeposit), handler)
+
+	tests := []struct {
+		name           string
+		method, path   string
+		header         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "api key with scope", method: http.MethodGet, path: "/balance", header: "Bearer " + readKey,
+			expectedStatus: http.StatusOK, expectedBody: `{"userID":5,"role":"user","apiKey":true}`,
+		},
+		{
+			name: "api key without scope", method: http.MethodPost, path: "/deposit", header: "Bearer " + readKey,
+			expectedStatus: http.StatusForbidden, expectedBody: `{"error":"API key lacks the required scope","code":"insufficient_scope"}`,
+		},
+		{
+			name: "revoked api key", method: http.MethodGet, path: "/balance", header: "Bearer " + revokedKey,
+			expectedStatus: http.StatusUnauthorized, expectedBody: `{"error":"Invalid or revoked API key","code":"unauthorized"}`,
+		},
+		{
+			name: "unknown api key", method: http.MethodGet, path: "/balance", header: "Bearer gwk_0000_secret",
+			expectedStatus: http.StatusUnauthorized, expectedBody: `{"error":"Invalid or revoked API key","code":"unauthorized"}`,
+		},
+		{
+			name: "api key without Bearer", method: http.MethodGet, path: "/balance", header: readKey,
+			expectedStatus: http.StatusUnauthorized, expectedBody: `{"error":"Authorization header required","code":"unauthorized"}`,
+		},
+		{
+			name: "access token is not limited by scopes", method: http.MethodPost, path: "/deposit", header: "Bearer " + pair.AccessToken,
+			expectedStatus: http.StatusOK, expectedBody: `{"userID":9,"role":"user","apiKey":false}`,
+		},
+		{
+			name: "invalid access token", method: http.MethodGet, path: "/balance", header: "Bearer invalid.token.here",
+			expectedStatus: http.StatusUnauthorized, expectedBody: `{"error":"Invalid or expired token","code":"unauthorized"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", tt.header)
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+
+	// access tokens only routes reject API keys
+	w := httptest.NewRecorder()
+	only := gin.New()
+	only.GET("/me", AuthMiddleware(tokens), handler)
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+readKey)
+	only.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name             string
+		role             string
+		expectedStatus   int
+		expectedOverride bool
+	}{
+		{name: "no role", role: "", expectedStatus: http.StatusForbidden},
+		{name: "regular user", role: models.RoleUser, expectedStatus: http.StatusForbidden},
+		{name: "auditor", role: models.RoleAuditor, expectedStatus: http.StatusForbidden},
+		{name: "support", role: models.RoleSupport, expectedStatus: http.StatusOK, expectedOverride: true},
+		{name: "admin", role: models.RoleAdmin, expectedStatus: http.StatusOK, expectedOverride: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			_, r := gin.CreateTestContext(w)
+
+			r.Use(func(c *gin.Context) {
+				c.Set("role", tt.role)
+			}, RequireRole(models.RoleSupport, models.RoleAdmin))
+			r.GET("/test", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"adminOverride": c.GetBool("adminOverride")})
+			})
+
+			req := httptest.NewRequest("GET", "/test", nil)
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.JSONEq(t, fmt.Sprintf(`{"adminOverride":%t}`, tt.expectedOverride), w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gin-wallet2/models"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyHeader request header carrying the client generated key
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLen matches the idempotency_keys.idempotency_key column
+const maxIdempotencyKeyLen = 255
+
+// ErrIdempotencyKeyNotFound returned when completing an unknown key
+var ErrIdempotencyKeyNotFound = errors.New("idempotency key not found")
+
+// IdempotencyRecord stored state of an Idempotency-Key
+type IdempotencyRecord struct {
+	RequestHash string
+	Completed   bool
+	StatusCode  int
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore persists idempotency keys per user
+type IdempotencyStore interface {
+	// Reserve claims key for userID. When an unexpired record already exists
+	// it is returned with reserved set to false.
+	Reserve(ctx context.Context, userID int, key, requestHash string, ttl time.Duration) (rec *IdempotencyRecord, reserved bool, err error)
+	// Complete stores the response of a reserved key for replay
+	Complete(ctx context.Context, userID int, key string, statusCode int, body []byte) error
+	// Release drops a reservation so the request can be retried
+	Release(ctx context.Context, userID int, key string) error
+}
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key. Keys are scoped to the authenticated user, so it must
+// be used after AuthMiddleware. Requests without the header pass through.
+func Idempotency(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key too long", "code": models.CodeInvalidInput})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "code": models.CodeInvalidInput})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		userID := c.GetInt("userID")
+		hash := requestHash(c.Request.Method, c.FullPath(), body)
+
+		rec, reserved, err := store.Reserve(ctx, userID, key, hash, ttl)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key", "code": models.CodeInternal})
+			c.Abort()
+			return
+		}
+		if !reserved {
+			replayIdempotent(c, rec, hash)
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// the request may have been cancelled by a shutdown, the key must not
+		// stay reserved because of that
+		ctx = context.WithoutCancel(ctx)
+		// server errors are not final and neither are rejections the client
+		// fixes with headers alone, such as a missing two-factor code; let the
+		// client retry with the same key
+		if retryableStatus(recorder.Status()) {
+			err = store.Release(ctx, userID, key)
+		} else {
+			err = store.Complete(ctx, userID, key, recorder.Status(), recorder.body.Bytes())
+		}
+		if err != nil {
+			_ = c.Error(err)
+		}
+	}
+}
+
+// retryableStatus responses that do not use up an Idempotency-Key
+func retryableStatus(status int) bool {
+	return status >= http.StatusInternalServerError || status == http.StatusUnauthorized ||
+		status == http.StatusForbidden || status == http.StatusTooManyRequests
+}
+
+// replayIdempotent answers a request whose key is already known
+func replayIdempotent(c *gin.Context, rec *IdempotencyRecord, hash string) {
+	switch {
+	case rec.RequestHash != hash:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key already used with a different request", "code": models.CodeIdempotencyKeyReused})
+	case !rec.Completed:
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress", "code": models.CodeIdempotencyKeyInProgress})
+	default:
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(rec.StatusCode, "application/json; charset=utf-8", rec.Body)
+	}
+	c.Abort()
+}
+
+// requestHash fingerprints a request so a reused key with a different body is detected
+func requestHash(method, route string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(route))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bodyRecorder copies the response body so it can be stored for replay
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+// MemoryIdempotencyStore in-process IdempotencyStore, for tests and single instance setups
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[idempotencyKey]*IdempotencyRecord
+	now     func() time.Time
+}
+
+// NewMemoryIdempotencyStore new in-memory idempotency store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[idempotencyKey]*IdempotencyRecord),
+		now:     time.Now,
+	}
+}
+
+// Reserve implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Reserve(_ context.Context, userID int, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, rec := range s.records {
+		if !rec.ExpiresAt.After(now) {
+			delete(s.records, k)
+		}
+	}
+
+	k := idempotencyKey{userID: userID, key: key}
+	if rec, ok := s.records[k]; ok {
+		cp := *rec
+		return &cp, false, nil
+	}
+	s.records[k] = &IdempotencyRecord{RequestHash: requestHash, ExpiresAt: now.Add(ttl)}
+	return nil, true, nil
+}
+
+// Complete implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Complete(_ context.Context, userID int, key string, statusCode int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[idempotencyKey{userID: userID, key: key}]
+	if !ok {
+		return ErrIdempotencyKeyNotFound
+	}
+	rec.Completed = true
+	rec.StatusCode = statusCode
+	rec.Body = append([]byte(nil), body...)
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Release(_ context.Context, userID int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, idempotencyKey{userID: userID, key: key})
+	return nil
+}
+
+// idempotencySweepInterval how often PostgresIdempotencyStore deletes expired
+// keys, at most once per instance
+const idempotencySweepInterval = time.Minute
+
+// PostgresIdempotencyStore IdempotencyStore backed by the idempotency_keys
+// table. Expiry is computed and compared on the database clock only.
+type PostgresIdempotencyStore struct {
+	DB *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewPostgresIdempotencyStore new postgres idempotency store
+func NewPostgresIdempotencyStore(db *sql.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{DB: db, now: time.Now}
+}
+
+// sweep deletes expired keys at most once per idempotencySweepInterval, keys
+// that are never reused would otherwise stay forever. A failed sweep is
+// retried on the next call.
+func (s *PostgresIdempotencyStore) sweep(ctx context.Context) error {
+	s.mu.Lock()
+	due := s.now().Sub(s.lastSweep) >= idempotencySweepInterval
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= CURRENT_TIMESTAMP"); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.lastSweep = s.now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Reserve implements IdempotencyStore, an expired key is taken over in place
+// and the other expired keys are swept on the way. A failed sweep is only
+// logged, cleanup must not block the request.
+func (s *PostgresIdempotencyStore) Reserve(ctx context.Context, userID int, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	if err := s.sweep(ctx); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Sweeping expired idempotency keys failed")
+	}
+
+	var reserved bool
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * interval '1 microsecond')
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status_code = NULL, response_body = NULL,
+			created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+		RETURNING true`,
+		userID, key, requestHash, ttl.Microseconds()).Scan(&reserved)
+	if err == nil {
+		return nil, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, err
+	}
+
+	var rec IdempotencyRecord
+	var statusCode sql.NullInt64
+	err = s.DB.QueryRowContext(ctx,
+		"SELECT request_hash, status_code, response_body, expires_at FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2",
+		userID, key).Scan(&rec.RequestHash, &statusCode, &rec.Body, &rec.ExpiresAt)
+	if err != nil {
+		return nil, false, err
+	}
+	rec.Completed = statusCode.Valid
+	rec.StatusCode = int(statusCode.Int64)
+	return &rec, false, nil
+}
+
+// Complete implements IdempotencyStore
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, userID int, key string, statusCode int, body []byte) error {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE idempotency_keys SET status_code = $1, response_body = $2 WHERE user_id = $3 AND idempotency_key = $4",
+		statusCode, body, userID, key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+// Release implements IdempotencyStore
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, userID int, key string) error {
+	_, err := s.DB.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2 AND status_code IS NULL",
+		userID, key)
+	return err
+}
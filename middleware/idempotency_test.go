@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupIdempotencyRouter(store IdempotencyStore, status *int, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("userID", 1)
+	}, Idempotency(store, time.Hour))
+	r.POST("/deposit", func(c *gin.Context) {
+		*calls++
+		c.JSON(*status, gin.H{"call": *calls})
+	})
+	return r
+}
+
+func doIdempotentRequest(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/deposit", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// ctxCheckingStore fails like a database would on a cancelled context
+type ctxCheckingStore struct {
+	*MemoryIdempotencyStore
+}
+
+func (s *ctxCheckingStore) Complete(ctx context.Context, userID int, key string, statusCode int, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryIdempotencyStore.Complete(ctx, userID, key, statusCode, body)
+}
+
+func (s *ctxCheckingStore) Release(ctx context.Context, userID int, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryIdempotencyStore.Release(ctx, userID, key)
+}
+
+func TestIdempotency(t *testing.T) {
+	t.Run("duplicate request is replayed", func(t *testing.T) {
+		status, calls := http.StatusOK, 0
+		r := setupIdempotencyRouter(NewMemoryIdempotencyStore(), &status, &calls)
+
+		first := doIdempotentRequest(r, "key-1", `{"amount":10}`)
+		second := doIdempotentRequest(r, "key-1", `{"amount":10}`)
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.JSONEq(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("reused key with different body", func(t *testing.T) {
+		status, calls := http.StatusOK, 0
+		r := setupIdempotencyRouter(NewMemoryIdempotencyStore(), &status, &calls)
+
+		doIdempotentRequest(r, "key-1", `{"amount":10}`)
+		w := doIdempotentRequest(r, "key-1", `{"amount":20}`)
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("no key passes through", func(t *testing.T) {
+		status, calls := http.StatusOK, 0
+		r := setupIdempotencyRouter(NewMemoryIdempotencyStore(), &status, &calls)
+
+		doIdempotentRequest(r, "", `{"amount":10}`)
+		doIdempotentRequest(r, "", `{"amount":10}`)
+
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("client errors are replayed", func(t *testing.T) {
+		status, calls := http.StatusBadRequest, 0
+		r := setupIdempotencyRouter(NewMemoryIdempotencyStore(), &status, &calls)
+
+		doIdempotentRequest(r, "key-1", `{"amount":10}`)
+		status = http.StatusOK
+		w := doIdempotentRequest(r, "key-1", `{"amount":10}`)
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("server errors release the key", func(t *testing.T) {
+		status, calls := http.StatusInternalServerError, 0
+		r := setupIdempotencyRouter(NewMemoryIdempotencyStore(), &status, &calls)
+
+		doIdempotentRequest(r, "key-1", `{"amount":10}`)
+		status = http.StatusOK
+		w := doIdempotentRequest(r, "key-1", `{"amount":10}`)
+
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("auth rejections release the key", func(t *testing.T) {
+		for _, rejected := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests} {
+			status, calls := rejected, 0
+			r := setupIdempotencyRouter(NewMemoryIdempotencyStore(), &status, &calls)
+
+			doIdempotentRequest(r, "key-1", `{"amount":10}`)
+			status = http.StatusOK
+			w := doIdempotentRequest(r, "key-1", `{"amount":10}`)
+
+			assert.Equal(t, 2, calls, "status %d", rejected)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("cancelled request still releases the key", func(t *testing.T) {
+		status, calls := http.StatusInternalServerError, 0
+		ctx, cancel := context.WithCancel(context.Background())
+		r := setupIdempotencyRouter(&ctxCheckingStore{NewMemoryIdempotencyStore()}, &status, &calls)
+		r.POST("/cancelled", func(c *gin.Context) {
+			calls++
+			// shutdown deadline passed while the handler was running
+			cancel()
+			c.JSON(status, gin.H{"call": calls})
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/cancelled", bytes.NewBufferString(`{"amount":10}`)).WithContext(ctx)
+		req.Header.Set(IdempotencyHeader, "key-1")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		status = http.StatusOK
+		req = httptest.NewRequest(http.MethodPost, "/cancelled", bytes.NewBufferString(`{"amount":10}`))
+		req.Header.Set(IdempotencyHeader, "key-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("expired key is reused", func(t *testing.T) {
+		status, calls := http.StatusOK, 0
+		store := NewMemoryIdempotencyStore()
+		now := time.Now()
+		store.now = func() time.Time { return now }
+		r := setupIdempotencyRouter(store, &status, &calls)
+
+		doIdempotentRequest(r, "key-1", `{"amount":10}`)
+		now = now.Add(2 * time.Hour)
+		w := doIdempotentRequest(r, "key-1", `{"amount":20}`)
+
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestPostgresIdempotencyStore_Reserve(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	store := NewPostgresIdempotencyStore(db)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	mock.ExpectExec("DELETE FROM idempotency_keys WHERE expires_at <= CURRENT_TIMESTAMP").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs(1, "key-1", "hash", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	_, reserved, err := store.Reserve(context.Background(), 1, "key-1", "hash", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	expires := time.Now().Add(time.Hour)
+	mock.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs(1, "key-1", "hash", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}))
+	mock.ExpectQuery("SELECT request_hash, status_code, response_body, expires_at FROM idempotency_keys").
+		WithArgs(1, "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "response_body", "expires_at"}).
+			AddRow("hash", 200, []byte(`{"message":"Deposit successful"}`), expires))
+	rec, reserved, err := store.Reserve(context.Background(), 1, "key-1", "hash", time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, reserved)
+	assert.True(t, rec.Completed)
+	assert.Equal(t, 200, rec.StatusCode)
+
+	// the next sweep is due a minute later, a failed one still reserves the
+	// key and is retried on the next call
+	now = now.Add(idempotencySweepInterval)
+	mock.ExpectExec("DELETE FROM idempotency_keys").
+		WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs(1, "key-2", "hash", time.Hour.Microseconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	_, reserved, err = store.Reserve(context.Background(), 1, "key-2", "hash", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	mock.ExpectExec("DELETE FROM idempotency_keys").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO idempotency_keys").
+		WithArgs(1, "key-3", "hash", time.Hour.Microseconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	_, reserved, err = store.Reserve(context.Background(), 1, "key-3", "hash", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"gin-wallet2/models"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader request id header, accepted from clients and proxies and
+// echoed in every response
+const RequestIDHeader = "X-Request-ID"
+
+// redacted replaces secret values in logs
+const redacted = "[REDACTED]"
+
+// validRequestID ids from clients are only kept if they are short and safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// sensitiveParams query parameters and headers whose values never reach the logs
+var sensitiveParams = []string{"password", "token", "secret", "authorization", "cookie", "api_key", "api-key", "apikey", "code"}
+
+// RequestID assigns every request an id, keeping a valid X-Request-ID from the
+// client, and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set("requestID", id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Logger stores a request-scoped logger carrying the request id and route in
+// the request context, where zerolog.Ctx finds it, and writes one access log
+// line per request. Must be used after RequestID.
+func Logger(base zerolog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		fields := base.With().
+			Str("request_id", c.GetString("requestID")).
+			Str("method", c.Request.Method).
+			Str("route", route)
+		// links log lines to the trace when tracing runs before this middleware
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			fields = fields.Str("trace_id", sc.TraceID().String())
+		}
+		logger := fields.Logger()
+		c.Set("logger", &logger)
+		c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		event := logger.Info()
+		switch {
+		case status >= http.StatusInternalServerError:
+			event = logger.Error()
+		case status >= http.StatusBadRequest:
+			event = logger.Warn()
+		}
+		if query := c.Request.URL.RawQuery; query != "" {
+			event = event.Str("query", RedactQuery(query))
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate); len(errs) > 0 {
+			event = event.Strs("errors", errs.Errors())
+		}
+		if logger.Debug().Enabled() {
+			event = event.Interface("headers", RedactHeaders(c.Request.Header))
+		}
+		event.
+			Str("path", c.Request.URL.Path).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Int("bytes", c.Writer.Size()).
+			Str("client_ip", c.ClientIP()).
+			Str("user_agent", c.Request.UserAgent()).
+			Msg("request")
+	}
+}
+
+// Recovery turns a panic into a 500 response and logs it with the request logger
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err interface{}) {
+		zerolog.Ctx(c.Request.Context()).Error().
+			Interface("panic", err).
+			Bytes("stack", debug.Stack()).
+			Msg("Panic recovered")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error", "code": models.CodeInternal})
+	})
+}
+
+// addLogFields adds fields to the request-scoped logger, so they appear on
+// everything logged for the rest of the request including the access log
+func addLogFields(c *gin.Context, fn func(zerolog.Context) zerolog.Context) {
+	if logger, ok := c.Get("logger"); ok {
+		logger.(*zerolog.Logger).UpdateContext(fn)
+	}
+}
+
+// RedactQuery raw query with the values of sensitive parameters replaced
+func RedactQuery(raw string) string {
+	params := strings.Split(raw, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err != nil || isSensitive(name) {
+			params[i] = key + "=" + redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// RedactHeaders copy of h with the values of sensitive headers replaced
+func RedactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for key, values := range h {
+		if isSensitive(key) {
+			out[key] = redacted
+			continue
+		}
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveParams {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// only fails if the OS entropy source is broken, a time based id still
+		// correlates the log lines of this request
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
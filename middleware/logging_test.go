@@ -0,0 +1,207 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"gin-wallet2/token"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// logLines decodes the JSON log lines written to buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields), line)
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func setupLoggingRouter(buf *bytes.Buffer, level zerolog.Level, handlers ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID(), Logger(zerolog.New(buf).Level(level)), Recovery())
+	r.GET("/wallet/balance/:userID", handlers...)
+	return r
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	r := setupLoggingRouter(&buf, zerolog.InfoLevel, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"propagated", "abc-123.retry:1", true},
+		{"unsafe id replaced", "bad id\nforged log line", false},
+		{"too long id replaced", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/wallet/balance/1", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if tt.keep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+		})
+	}
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("one access log line with request fields", func(t *testing.T) {
+		var buf bytes.Buffer
+		r := setupLoggingRouter(&buf, zerolog.InfoLevel, func(c *gin.Context) {
+			// handlers log through the request context
+			zerolog.Ctx(c.Request.Context()).Info().Msg("from handler")
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/wallet/balance/1?limit=5&access_token=secret-value", nil)
+		req.Header.Set(RequestIDHeader, "req-1")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := logLines(t, &buf)
+		require.Len(t, lines, 2)
+		assert.Equal(t, "from handler", lines[0]["message"])
+		assert.Equal(t, "req-1", lines[0]["request_id"])
+
+		access := lines[1]
+		assert.Equal(t, "request", access["message"])
+		assert.Equal(t, "info", access["level"])
+		assert.Equal(t, "req-1", access["request_id"])
+		assert.Equal(t, "GET", access["method"])
+		assert.Equal(t, "/wallet/balance/:userID", access["route"])
+		assert.Equal(t, "/wallet/balance/1", access["path"])
+		assert.Equal(t, float64(200), access["status"])
+		assert.Equal(t, "limit=5&access_token=[REDACTED]", access["query"])
+		assert.NotContains(t, buf.String(), "secret-value")
+	})
+
+	t.Run("level follows status", func(t *testing.T) {
+		for status, level := range map[int]string{http.StatusNotFound: "warn", http.StatusInternalServerError: "error"} {
+			var buf bytes.Buffer
+			r := setupLoggingRouter(&buf, zerolog.InfoLevel, func(c *gin.Context) { c.Status(status) })
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/wallet/balance/1", nil))
+
+			lines := logLines(t, &buf)
+			require.Len(t, lines, 1)
+			assert.Equal(t, level, lines[0]["level"])
+		}
+	})
+
+	t.Run("headers only at debug level and redacted", func(t *testing.T) {
+		var buf bytes.Buffer
+		r := setupLoggingRouter(&buf, zerolog.DebugLevel, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(http.MethodGet, "/wallet/balance/1", nil)
+		req.Header.Set("Authorization", "Bearer secret-value")
+		req.Header.Set("User-Agent", "wallet-test")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := logLines(t, &buf)
+		require.Len(t, lines, 1)
+		assert.Equal(t, map[string]interface{}{"Authorization": "[REDACTED]", "User-Agent": "wallet-test"}, lines[0]["headers"])
+		assert.NotContains(t, buf.String(), "secret-value")
+	})
+
+	t.Run("user id from the access token", func(t *testing.T) {
+		tokens := token.NewManager(token.HMACKeys([]byte("test-secret")), 15*time.Minute, time.Hour, store.NewMemory(), store.NewMemory())
+		pair, err := tokens.Issue(context.Background(), &models.User{ID: 42})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		r := setupLoggingRouter(&buf, zerolog.InfoLevel, AuthMiddleware(tokens), func(c *gin.Context) { c.Status(http.StatusOK) })
+		req := httptest.NewRequest(http.MethodGet, "/wallet/balance/42", nil)
+		req.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := logLines(t, &buf)
+		require.Len(t, lines, 1)
+		assert.Equal(t, float64(42), lines[0]["user_id"])
+		assert.NotContains(t, buf.String(), pair.AccessToken)
+	})
+
+	t.Run("trace id from the request span", func(t *testing.T) {
+		tp := sdktrace.NewTracerProvider()
+		defer func() { _ = tp.Shutdown(context.Background()) }()
+
+		var buf bytes.Buffer
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		var traceID string
+		r.Use(RequestID(), func(c *gin.Context) {
+			ctx, span := tp.Tracer("test").Start(c.Request.Context(), c.FullPath())
+			defer span.End()
+			traceID = span.SpanContext().TraceID().String()
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+		}, Logger(zerolog.New(&buf)))
+		r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		lines := logLines(t, &buf)
+		require.Len(t, lines, 1)
+		assert.Equal(t, traceID, lines[0]["trace_id"])
+	})
+
+	t.Run("panic logged and answered with 500", func(t *testing.T) {
+		var buf bytes.Buffer
+		r := setupLoggingRouter(&buf, zerolog.InfoLevel, func(c *gin.Context) { panic("boom") })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wallet/balance/1", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":"Internal server error","code":"internal_error"}`, w.Body.String())
+		lines := logLines(t, &buf)
+		require.Len(t, lines, 2)
+		assert.Equal(t, "Panic recovered", lines[0]["message"])
+		assert.Equal(t, "boom", lines[0]["panic"])
+		assert.Equal(t, float64(500), lines[1]["status"])
+	})
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"limit=10&offset=20", "limit=10&offset=20"},
+		{"password=hunter2&name=bob", "password=[REDACTED]&name=bob"},
+		{"Refresh_Token=abc", "Refresh_Token=[REDACTED]"},
+		{"api%5Fkey=abc", "api%5Fkey=[REDACTED]"},
+		{"%zz=abc", "%zz=[REDACTED]"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, RedactQuery(tt.raw), tt.raw)
+	}
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+// RateLimit token bucket holding up to Burst requests, refilled at Burst per
+// Period. A zero Burst disables the limit.
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// interval time to refill one token
+func (l RateLimit) interval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+// RateLimitResult outcome of taking a token
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter until the next token is available, zero if Allowed
+	RetryAfter time.Duration
+	// Reset until the bucket is full again
+	Reset time.Duration
+}
+
+// RateLimiter token bucket backend. The in-memory limiter counts per instance,
+// a shared implementation (e.g. Redis) makes limits hold across instances.
+type RateLimiter interface {
+	// Take removes one token from the bucket of key if it has one
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKey identifies the bucket of a request, "" skips limiting
+type RateLimitKey func(c *gin.Context) string
+
+// KeyByIP buckets requests by client IP, see gin's SetTrustedProxies
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByUser buckets requests by authenticated user, must be used after
+// AuthMiddleware
+func KeyByUser(c *gin.Context) string {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return ""
+	}
+	return "user:" + strconv.Itoa(userID)
+}
+
+// RateLimitMiddleware answers 429 with Retry-After once the bucket of the
+// request is empty. name separates buckets of different route groups sharing
+// a limiter. Every response carries RateLimit-* headers. If the backend fails
+// the request is let through.
+func RateLimitMiddleware(limiter RateLimiter, name string, limit RateLimit, key RateLimitKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit.Burst <= 0 {
+			return
+		}
+		k := key(c)
+		if k == "" {
+			return
+		}
+
+		res, err := limiter.Take(c.Request.Context(), name+":"+k, limit)
+		if err != nil {
+			// availability over limiting, the failure shows in the logs
+			zerolog.Ctx(c.Request.Context()).Error().Err(err).Msg("Rate limiter failed")
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		c.Header("RateLimit-Policy", strconv.Itoa(limit.Burst)+";w="+strconv.Itoa(ceilSeconds(limit.Period)))
+		if !res.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": models.ErrRateLimited.Message, "code": models.CodeRateLimited})
+			c.Abort()
+		}
+	}
+}
+
+func currentUserID(c *gin.Context) (int, bool) {
+	userID, ok := c.Get("userID")
+	id, isInt := userID.(int)
+	return id, ok && isInt && id > 0
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// bucket state of one key. tokens is only correct as of updated, full is when
+// the bucket will have refilled completely.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// MemoryRateLimiter in-process RateLimiter, limits are per instance
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryRateLimiter new in-memory rate limiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// sweepInterval how often buckets of idle clients are dropped
+const sweepInterval = time.Minute
+
+// Take implements RateLimiter
+func (l *MemoryRateLimiter) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	burst := float64(limit.Burst)
+	perToken := limit.interval()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+float64(now.Sub(b.updated))/float64(perToken))
+	b.updated = now
+
+	res := RateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = time.Duration((burst - b.tokens) * float64(perToken))
+	b.full = now.Add(res.Reset)
+	return res, nil
+}
+
+// sweep drops buckets that have refilled completely, a new bucket for the
+// same key starts full anyway
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if !b.full.After(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock time source for MemoryRateLimiter, advanced by hand
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter() (*MemoryRateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewMemoryRateLimiter()
+	l.now = clock.now
+	return l, clock
+}
+
+func setupRateLimitRouter(limiter RateLimiter, limit RateLimit) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	auth := RateLimitMiddleware(limiter, "auth", limit, KeyByIP)
+	r.POST("/login", auth, ok)
+	r.POST("/register", auth, ok)
+	// userID comes from the X-User header instead of a token
+	r.GET("/wallet/balance", func(c *gin.Context) {
+		if id, err := strconv.Atoi(c.GetHeader("X-User")); err == nil {
+			c.Set("userID", id)
+		}
+	}, RateLimitMiddleware(limiter, "wallet", limit, KeyByUser), ok)
+	return r
+}
+
+func doRequest(r *gin.Engine, method, path, ip, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":1234"
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limit := RateLimit{Burst: 3, Period: time.Minute}
+
+	t.Run("burst then 429 with headers", func(t *testing.T) {
+		limiter, _ := newTestLimiter()
+		r := setupRateLimitRouter(limiter, limit)
+
+		for i := 0; i < 3; i++ {
+			w := doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, strconv.Itoa(2-i), w.Header().Get("RateLimit-Remaining"))
+			assert.Equal(t, "3;w=60", w.Header().Get("RateLimit-Policy"))
+			assert.Empty(t, w.Header().Get("Retry-After"))
+		}
+
+		w := doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.JSONEq(t, `{"error":"Too many requests","code":"rate_limited"}`, w.Body.String())
+		assert.Equal(t, "20", w.Header().Get("Retry-After"))
+		assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	})
+
+	t.Run("tokens refill over time", func(t *testing.T) {
+		limiter, clock := newTestLimiter()
+		r := setupRateLimitRouter(limiter, limit)
+
+		for i := 0; i < 3; i++ {
+			doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+		}
+		clock.advance(19 * time.Second)
+		w := doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		clock.advance(time.Second)
+		assert.Equal(t, http.StatusOK, doRequest(r, http.MethodPost, "/login", "10.0.0.1", "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, doRequest(r, http.MethodPost, "/login", "10.0.0.1", "").Code)
+	})
+
+	t.Run("buckets per ip shared by auth routes", func(t *testing.T) {
+		limiter, _ := newTestLimiter()
+		r := setupRateLimitRouter(limiter, limit)
+
+		doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+		doRequest(r, http.MethodPost, "/register", "10.0.0.1", "")
+		doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+		assert.Equal(t, http.StatusTooManyRequests, doRequest(r, http.MethodPost, "/register", "10.0.0.1", "").Code)
+		assert.Equal(t, http.StatusOK, doRequest(r, http.MethodPost, "/login", "10.0.0.2", "").Code)
+	})
+
+	t.Run("buckets per user not ip", func(t *testing.T) {
+		limiter, _ := newTestLimiter()
+		r := setupRateLimitRouter(limiter, limit)
+
+		for i := 0; i < 3; i++ {
+			// a user moving between networks keeps their bucket
+			ip := "10.0.0." + strconv.Itoa(i+1)
+			require.Equal(t, http.StatusOK, doRequest(r, http.MethodGet, "/wallet/balance", ip, "1").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, doRequest(r, http.MethodGet, "/wallet/balance", "10.0.0.9", "1").Code)
+		assert.Equal(t, http.StatusOK, doRequest(r, http.MethodGet, "/wallet/balance", "10.0.0.1", "2").Code)
+		// the wallet limit does not use up the auth limit of the same ip
+		assert.Equal(t, http.StatusOK, doRequest(r, http.MethodPost, "/login", "10.0.0.1", "").Code)
+	})
+
+	t.Run("unauthenticated requests not limited by user", func(t *testing.T) {
+		limiter, _ := newTestLimiter()
+		r := setupRateLimitRouter(limiter, limit)
+
+		for i := 0; i < 5; i++ {
+			w := doRequest(r, http.MethodGet, "/wallet/balance", "10.0.0.1", "")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("disabled limit", func(t *testing.T) {
+		limiter, _ := newTestLimiter()
+		r := setupRateLimitRouter(limiter, RateLimit{})
+
+		for i := 0; i < 5; i++ {
+			w := doRequest(r, http.MethodPost, "/login", "10.0.0.1", "")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("failing backend lets requests through", func(t *testing.T) {
+		r := setupRateLimitRouter(failingLimiter{}, limit)
+
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, http.StatusOK, doRequest(r, http.MethodPost, "/login", "10.0.0.1", "").Code)
+		}
+	})
+}
+
+// failingLimiter fails like an unreachable shared backend would
+type failingLimiter struct{}
+
+func (failingLimiter) Take(context.Context, string, RateLimit) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("connection refused")
+}
+
+func TestMemoryRateLimiter_Sweep(t *testing.T) {
+	limiter, clock := newTestLimiter()
+	ctx := context.Background()
+
+	_, err := limiter.Take(ctx, "short", RateLimit{Burst: 1, Period: time.Second})
+	require.NoError(t, err)
+	_, err = limiter.Take(ctx, "long", RateLimit{Burst: 1, Period: time.Hour})
+	require.NoError(t, err)
+
+	clock.advance(sweepInterval)
+	_, err = limiter.Take(ctx, "other", RateLimit{Burst: 1, Period: time.Second})
+	require.NoError(t, err)
+
+	// only the refilled bucket is dropped, the long one is still empty
+	assert.NotContains(t, limiter.buckets, "short")
+	assert.Contains(t, limiter.buckets, "long")
+	res, err := limiter.Take(ctx, "long", RateLimit{Burst: 1, Period: time.Hour})
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunMigrate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	t.Run("usage errors", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.EqualError(t, runMigrate(ctx, db, nil, &out), migrateUsage)
+		assert.ErrorContains(t, runMigrate(ctx, db, []string{"sideways"}, &out), `unknown migrate command "sideways"`)
+		assert.ErrorContains(t, runMigrate(ctx, db, []string{"down", "0"}, &out), `invalid steps "0"`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("status", func(t *testing.T) {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+			WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).
+				AddRow(1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+		var out bytes.Buffer
+		assert.NoError(t, runMigrate(ctx, db, []string{"status"}, &out))
+		assert.Contains(t, out.String(), "0001_initial_schema")
+		assert.Contains(t, out.String(), "2024-01-01T00:00:00Z")
+		assert.Contains(t, out.String(), "0002_users_is_admin")
+		assert.Contains(t, out.String(), "pending")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
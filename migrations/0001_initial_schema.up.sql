@@ -0,0 +1,18 @@
+-- users and transactions as they were in the original doc/wallet.sql dump.
+-- IF NOT EXISTS lets databases created from that dump adopt migrations.
+CREATE TABLE IF NOT EXISTS users (
+  id serial PRIMARY KEY,
+  name varchar(100) NOT NULL,
+  balance numeric(10,2) DEFAULT 0 CONSTRAINT users_balance_check CHECK (balance >= 0),
+  created_at timestamp(6) DEFAULT CURRENT_TIMESTAMP,
+  password_hash text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+  id serial PRIMARY KEY,
+  user_id int4 NOT NULL REFERENCES users (id),
+  type varchar(50) NOT NULL,
+  amount numeric(10,2) NOT NULL CONSTRAINT transactions_amount_check CHECK (amount > 0),
+  description text,
+  created_at timestamp(6) DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  user_id int4 NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  idempotency_key varchar(255) NOT NULL,
+  request_hash char(64) NOT NULL,
+  status_code int4,
+  response_body bytea,
+  created_at timestamp(6) DEFAULT CURRENT_TIMESTAMP,
+  expires_at timestamp(6) NOT NULL,
+  PRIMARY KEY (user_id, idempotency_key)
+);
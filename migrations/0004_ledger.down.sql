@@ -0,0 +1,4 @@
+ALTER TABLE transactions DROP COLUMN IF EXISTS entry_id;
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS accounts (
+  id serial PRIMARY KEY,
+  code varchar(64) NOT NULL UNIQUE,
+  user_id int4 UNIQUE REFERENCES users (id),
+  created_at timestamp(6) DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+  id serial PRIMARY KEY,
+  kind varchar(50) NOT NULL,
+  description text,
+  created_at timestamp(6) DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS postings (
+  id serial PRIMARY KEY,
+  entry_id int4 NOT NULL REFERENCES journal_entries (id),
+  account_id int4 NOT NULL REFERENCES accounts (id),
+  amount numeric(12,2) NOT NULL CONSTRAINT postings_amount_check CHECK (amount <> 0),
+  created_at timestamp(6) DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS postings_entry_id_idx ON postings (entry_id);
+CREATE INDEX IF NOT EXISTS postings_account_id_idx ON postings (account_id);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS entry_id int4 REFERENCES journal_entries (id);
+
+INSERT INTO accounts (code) VALUES ('system:cash') ON CONFLICT (code) DO NOTHING;
+
+-- every existing user gets a ledger account
+INSERT INTO accounts (code, user_id)
+SELECT 'user:' || u.id, u.id FROM users u
+WHERE NOT EXISTS (SELECT 1 FROM accounts a WHERE a.user_id = u.id);
+
+-- balances from before the ledger are carried over in one opening entry
+-- against the cash account, so VerifyLedger starts out balanced
+WITH drift AS (
+  SELECT a.id AS account_id, COALESCE(u.balance, 0) - COALESCE(SUM(p.amount), 0) AS amount
+  FROM users u
+  JOIN accounts a ON a.user_id = u.id
+  LEFT JOIN postings p ON p.account_id = a.id
+  GROUP BY a.id, u.balance
+  HAVING COALESCE(u.balance, 0) - COALESCE(SUM(p.amount), 0) <> 0
+), entry AS (
+  INSERT INTO journal_entries (kind, description)
+  SELECT 'opening_balance', 'Balance carried over to the ledger'
+  WHERE EXISTS (SELECT 1 FROM drift)
+  RETURNING id
+)
+INSERT INTO postings (entry_id, account_id, amount)
+SELECT entry.id, drift.account_id, drift.amount FROM entry, drift
+UNION ALL
+SELECT entry.id, (SELECT id FROM accounts WHERE code = 'system:cash'), -(SELECT SUM(amount) FROM drift)
+FROM entry
+WHERE (SELECT SUM(amount) FROM drift) <> 0;
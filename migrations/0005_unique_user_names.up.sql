@@ -0,0 +1,2 @@
+-- fails if duplicate names already exist, they have to be renamed by hand first
+ALTER TABLE users ADD CONSTRAINT users_name_key UNIQUE (name);
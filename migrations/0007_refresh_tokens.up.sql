@@ -0,0 +1,22 @@
+-- refresh tokens are stored hashed, one row per rotation
+CREATE TABLE refresh_tokens (
+  id serial PRIMARY KEY,
+  token_hash char(64) NOT NULL UNIQUE,
+  family_id varchar(64) NOT NULL,
+  user_id int4 NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  access_jti varchar(64) NOT NULL,
+  access_expires_at timestamptz NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz,
+  revoked_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- access token denylist, rows are useless once the token has expired
+CREATE TABLE revoked_tokens (
+  jti varchar(64) PRIMARY KEY,
+  expires_at timestamptz NOT NULL
+);
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
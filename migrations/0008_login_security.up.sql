@@ -0,0 +1,18 @@
+-- consecutive failed logins per "user:<id>" or "ip:<addr>" key
+CREATE TABLE login_failures (
+  key varchar(128) PRIMARY KEY,
+  failures int4 NOT NULL DEFAULT 0,
+  last_failed_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  locked_until timestamptz
+);
+
+-- login history of each user, read newest first
+CREATE TABLE login_attempts (
+  id serial PRIMARY KEY,
+  user_id int4 NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  ip varchar(64) NOT NULL,
+  user_agent varchar(512) NOT NULL DEFAULT '',
+  outcome varchar(32) NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX login_attempts_user_id_created_at_idx ON login_attempts (user_id, created_at);
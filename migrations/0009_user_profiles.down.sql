@@ -0,0 +1,7 @@
+ALTER TABLE users
+  DROP COLUMN IF EXISTS phone,
+  DROP COLUMN IF EXISTS email,
+  DROP COLUMN IF EXISTS display_name;
+
+DROP INDEX IF EXISTS users_name_lower_key;
+ALTER TABLE users ADD CONSTRAINT users_name_key UNIQUE (name);
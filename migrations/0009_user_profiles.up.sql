@@ -0,0 +1,10 @@
+-- names are unique regardless of case; fails if names differing only in case
+-- already exist, they have to be renamed by hand first
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_name_key;
+CREATE UNIQUE INDEX users_name_lower_key ON users (lower(name));
+
+-- optional profile fields, empty when not set
+ALTER TABLE users
+  ADD COLUMN display_name varchar(100) NOT NULL DEFAULT '',
+  ADD COLUMN email varchar(254) NOT NULL DEFAULT '',
+  ADD COLUMN phone varchar(16) NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+-- TOTP secret of each user, enabled_at is NULL while enrollment is pending
+CREATE TABLE user_mfa (
+  user_id int4 PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  secret varchar(255) NOT NULL,
+  enabled_at timestamptz,
+  last_counter int8 NOT NULL DEFAULT 0,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- single use recovery codes, stored as their sha256
+CREATE TABLE mfa_recovery_codes (
+  id serial PRIMARY KEY,
+  user_id int4 NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  code_hash char(64) NOT NULL,
+  used_at timestamptz,
+  UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,6 @@
+-- the system:adjustments account stays, its postings are part of the ledger
+DROP TABLE IF EXISTS adjustments;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin bool NOT NULL DEFAULT false;
+UPDATE users SET is_admin = (role = 'admin');
+ALTER TABLE users DROP COLUMN IF EXISTS role;
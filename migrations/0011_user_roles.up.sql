@@ -0,0 +1,19 @@
+-- one role per user replaces the admin flag, admins keep their access
+ALTER TABLE users ADD COLUMN role varchar(16) NOT NULL DEFAULT 'user'
+  CONSTRAINT users_role_check CHECK (role IN ('user', 'support', 'admin', 'auditor'));
+UPDATE users SET role = 'admin' WHERE is_admin;
+ALTER TABLE users DROP COLUMN is_admin;
+
+-- manual adjustments post against their own system account
+INSERT INTO accounts (code) VALUES ('system:adjustments') ON CONFLICT (code) DO NOTHING;
+
+CREATE TABLE adjustments (
+  id serial PRIMARY KEY,
+  entry_id int4 NOT NULL REFERENCES journal_entries (id),
+  user_id int4 NOT NULL REFERENCES users (id),
+  admin_id int4 NOT NULL REFERENCES users (id),
+  amount numeric(10,2) NOT NULL CONSTRAINT adjustments_amount_check CHECK (amount <> 0),
+  reason text NOT NULL,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX adjustments_user_id_created_at_idx ON adjustments (user_id, created_at);
@@ -0,0 +1,16 @@
+-- API keys for server-to-server wallet access, stored as the sha256 of the
+-- whole key. prefix identifies a key without revealing it, scopes are space
+-- separated.
+CREATE TABLE api_keys (
+  id serial PRIMARY KEY,
+  user_id int4 NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  name varchar(100) NOT NULL,
+  prefix varchar(16) NOT NULL UNIQUE,
+  key_hash char(64) NOT NULL,
+  scopes varchar(255) NOT NULL,
+  last_used_at timestamptz,
+  revoked_at timestamptz,
+  created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
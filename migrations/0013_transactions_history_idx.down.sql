@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS transactions_user_id_created_at_idx ON transactions (user_id, created_at);
+DROP INDEX IF EXISTS transactions_user_id_created_at_id_idx;
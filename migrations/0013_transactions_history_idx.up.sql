@@ -0,0 +1,4 @@
+-- history pages are read by (created_at, id) in either direction, id breaks
+-- ties between rows written in the same microsecond
+CREATE INDEX IF NOT EXISTS transactions_user_id_created_at_id_idx ON transactions (user_id, created_at, id);
+DROP INDEX IF EXISTS transactions_user_id_created_at_idx;
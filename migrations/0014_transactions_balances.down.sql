@@ -0,0 +1,4 @@
+ALTER TABLE transactions
+  DROP CONSTRAINT IF EXISTS transactions_balances_check,
+  DROP COLUMN IF EXISTS balance_after,
+  DROP COLUMN IF EXISTS balance_before;
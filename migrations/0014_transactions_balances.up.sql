@@ -0,0 +1,6 @@
+-- wallet balance around each history row, written in the same database
+-- transaction as the balance change. Rows recorded before stay NULL.
+ALTER TABLE transactions
+  ADD COLUMN balance_before numeric(10,2),
+  ADD COLUMN balance_after numeric(10,2),
+  ADD CONSTRAINT transactions_balances_check CHECK ((balance_before IS NULL) = (balance_after IS NULL));
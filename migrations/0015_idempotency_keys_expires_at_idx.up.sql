@@ -0,0 +1,2 @@
+-- expired keys are swept by expires_at
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
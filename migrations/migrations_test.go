@@ -0,0 +1,245 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load(files)
+	require.NoError(t, err)
+
+	// versions are contiguous so a missing file is noticed
+	for i, mig := range migrations {
+		assert.Equal(t, i+1, mig.Version, mig.Name)
+		assert.NotEmpty(t, mig.Up)
+		assert.NotEmpty(t, mig.Down)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr string
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0010_b.up.sql":   {Data: []byte("up b")},
+				"0010_b.down.sql": {Data: []byte("down b")},
+				"0002_a.up.sql":   {Data: []byte("up a")},
+				"0002_a.down.sql": {Data: []byte("down a")},
+			},
+			want: []Migration{
+				{Version: 2, Name: "a", Up: "up a", Down: "down a"},
+				{Version: 10, Name: "b", Up: "up b", Down: "down b"},
+			},
+		},
+		{
+			name:    "missing down file",
+			fsys:    fstest.MapFS{"0001_a.up.sql": {Data: []byte("up")}},
+			wantErr: "migration 1_a: needs both up and down files",
+		},
+		{
+			name: "conflicting names",
+			fsys: fstest.MapFS{
+				"0001_a.up.sql":   {Data: []byte("up")},
+				"0001_b.down.sql": {Data: []byte("down")},
+			},
+			wantErr: "migration 1: conflicting names a and b",
+		},
+		{
+			name:    "bad file name",
+			fsys:    fstest.MapFS{"init.sql": {Data: []byte("up")}},
+			wantErr: "migration init.sql: name must look like 0001_name.up.sql",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func newMockMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &Migrator{DB: db, Migrations: []Migration{
+		{Version: 1, Name: "one", Up: "CREATE TABLE one", Down: "DROP TABLE one"},
+		{Version: 2, Name: "two", Up: "CREATE TABLE two", Down: "DROP TABLE two"},
+		{Version: 3, Name: "three", Up: "CREATE TABLE three", Down: "DROP TABLE three"},
+	}}, mock
+}
+
+func expectLocked(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range applied {
+		rows.AddRow(v, time.Date(2024, 1, v, 0, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").WillReturnRows(rows)
+}
+
+func expectUnlocked(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigrator_Up(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applies pending migrations in order", func(t *testing.T) {
+		m, mock := newMockMigrator(t)
+		expectLocked(mock, 1)
+		for _, v := range []struct {
+			version int
+			name    string
+		}{{2, "two"}, {3, "three"}} {
+			mock.ExpectBegin()
+			mock.ExpectExec("CREATE TABLE " + v.name).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(v.version, v.name).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}
+		expectUnlocked(mock)
+
+		applied, err := m.Up(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, applied, 2)
+		assert.Equal(t, 3, applied[1].Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("failed migration is rolled back and stops", func(t *testing.T) {
+		m, mock := newMockMigrator(t)
+		expectLocked(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectExec("CREATE TABLE two").WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+		expectUnlocked(mock)
+
+		applied, err := m.Up(ctx)
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		assert.Contains(t, err.Error(), "migration 2_two up")
+		assert.Empty(t, applied)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("schema from a newer binary", func(t *testing.T) {
+		m, mock := newMockMigrator(t)
+		expectLocked(mock, 1, 2, 3, 4)
+		expectUnlocked(mock)
+
+		_, err := m.Up(ctx)
+		assert.ErrorIs(t, err, ErrDirty)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	ctx := context.Background()
+	m, mock := newMockMigrator(t)
+
+	expectLocked(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlocked(mock)
+
+	reverted, err := m.Down(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, reverted, 1) {
+		assert.Equal(t, "two", reverted[0].Name)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Status(t *testing.T) {
+	ctx := context.Background()
+	m, mock := newMockMigrator(t)
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, applied_at FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).
+			AddRow(1, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	statuses, err := m.Status(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 3) {
+		assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), *statuses[0].AppliedAt)
+		assert.Nil(t, statuses[1].AppliedAt)
+		assert.Nil(t, statuses[2].AppliedAt)
+	}
+	assert.Equal(t, 3, m.Latest())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Version(t *testing.T) {
+	ctx := context.Background()
+	m, mock := newMockMigrator(t)
+
+	mock.ExpectQuery("SELECT MAX\\(version\\) FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	version, err := m.Version(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	mock.ExpectQuery("SELECT MAX\\(version\\) FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(2))
+	version, err = m.Version(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_CheckCurrent(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		version interface{}
+		wantErr error
+	}{
+		{"current", 3, nil},
+		{"pending", 2, ErrPending},
+		{"empty database", nil, ErrPending},
+		{"newer schema", 4, ErrDirty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, mock := newMockMigrator(t)
+			mock.ExpectQuery("SELECT MAX\\(version\\) FROM schema_migrations").
+				WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(tt.version))
+
+			err := m.CheckCurrent(ctx)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
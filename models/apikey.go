@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// API key scopes, each allows one kind of wallet request
+const (
+	ScopeWalletRead     = "wallet:read"
+	ScopeWalletDeposit  = "wallet:deposit"
+	ScopeWalletWithdraw = "wallet:withdraw"
+	ScopeWalletTransfer = "wallet:transfer"
+)
+
+// Scopes every scope an API key can be granted
+var Scopes = []string{ScopeWalletRead, ScopeWalletDeposit, ScopeWalletWithdraw, ScopeWalletTransfer}
+
+// ValidScope reports whether scope is one of Scopes
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// OutgoingScope reports whether scope lets a key take money out of the wallet
+func OutgoingScope(scope string) bool {
+	return scope == ScopeWalletWithdraw || scope == ScopeWalletTransfer
+}
+
+// APIKey key a service uses to call the wallet on behalf of its owner. Only
+// the sha256 of the key is stored, Prefix identifies it in listings and logs.
+type APIKey struct {
+	ID      int      `json:"id"`
+	UserID  int      `json:"-"`
+	Name    string   `json:"name"`
+	Prefix  string   `json:"prefix"`
+	KeyHash string   `json:"-"`
+	Scopes  []string `json:"scopes"`
+	// LastUsedAt updated at most once a minute
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package models
+
+// Error domain error with a stable machine readable code
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Error codes returned in the "code" field of JSON error responses
+const (
+	CodeInvalidInput      = "invalid_input"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotFound          = "not_found"
+	CodeUserNotFound      = "user_not_found"
+	CodeInsufficientFunds = "insufficient_funds"
+	CodeConflict          = "conflict"
+	CodeInternal          = "internal_error"
+	CodeTokenReused       = "refresh_token_reused"
+	CodeRateLimited       = "rate_limited"
+	CodeAccountLocked     = "account_locked"
+	CodeWeakPassword      = "weak_password"
+	CodeMFARequired       = "mfa_required"
+	CodeInvalidOTP        = "invalid_otp"
+	CodeInsufficientScope = "insufficient_scope"
+	CodeBalanceLimit      = "balance_limit_exceeded"
+
+	CodeIdempotencyKeyReused     = "idempotency_key_reused"
+	CodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+)
+
+var (
+	// ErrInvalidInput request body or parameters failed validation
+	ErrInvalidInput = &Error{Code: CodeInvalidInput, Message: "Invalid input"}
+	// ErrInvalidUserID user id path parameter is not a positive integer
+	ErrInvalidUserID = &Error{Code: CodeInvalidInput, Message: "Invalid user ID"}
+	// ErrBalanceLimit credit would take a wallet past the largest balance the
+	// balance column holds
+	ErrBalanceLimit = &Error{Code: CodeBalanceLimit, Message: "Balance would exceed the wallet limit"}
+	// ErrSameWallet transfer source and destination are the same wallet
+	ErrSameWallet = &Error{Code: CodeInvalidInput, Message: "Cannot transfer to the same wallet"}
+	// ErrInvalidCredentials unknown user name or wrong password
+	ErrInvalidCredentials = &Error{Code: CodeUnauthorized, Message: "Invalid credentials"}
+	// ErrUnauthorized request is not authenticated
+	ErrUnauthorized = &Error{Code: CodeUnauthorized, Message: "Unauthorized"}
+	// ErrInvalidToken access or refresh token is malformed, expired or revoked
+	ErrInvalidToken = &Error{Code: CodeUnauthorized, Message: "Invalid or expired token"}
+	// ErrTokenReused an already rotated refresh token was presented again, the
+	// whole session has been revoked
+	ErrTokenReused = &Error{Code: CodeTokenReused, Message: "Refresh token reuse detected, session revoked"}
+	// ErrWrongPassword current password given to change it is wrong
+	ErrWrongPassword = &Error{Code: CodeForbidden, Message: "Current password is incorrect"}
+	// ErrInsufficientRole caller's role does not allow the route
+	ErrInsufficientRole = &Error{Code: CodeForbidden, Message: "Insufficient role"}
+	// ErrOwnWalletAdjustment admins may not adjust their own wallet
+	ErrOwnWalletAdjustment = &Error{Code: CodeForbidden, Message: "Admins cannot adjust their own wallet"}
+	// ErrOwnRoleChange admins may not change their own role
+	ErrOwnRoleChange = &Error{Code: CodeForbidden, Message: "Admins cannot change their own role"}
+	// ErrInvalidRole role is not one of Roles
+	ErrInvalidRole = &Error{Code: CodeInvalidInput, Message: "Role must be one of user, support, admin, auditor"}
+	// ErrForbidden caller may not act on the requested account
+	ErrForbidden = &Error{Code: CodeForbidden, Message: "Forbidden"}
+	// ErrUserNotFound referenced user does not exist
+	ErrUserNotFound = &Error{Code: CodeUserNotFound, Message: "User not found"}
+	// ErrInsufficientFunds wallet balance is lower than the requested amount
+	ErrInsufficientFunds = &Error{Code: CodeInsufficientFunds, Message: "Insufficient balance"}
+	// ErrRateLimited client sent too many requests, retry after the
+	// Retry-After header
+	ErrRateLimited = &Error{Code: CodeRateLimited, Message: "Too many requests"}
+	// ErrAccountLocked too many failed logins for the account or client IP,
+	// retry after the Retry-After header
+	ErrAccountLocked = &Error{Code: CodeAccountLocked, Message: "Too many failed login attempts, try again later"}
+	// ErrMFARequired amount needs a two-factor code in the X-OTP-Code header
+	ErrMFARequired = &Error{Code: CodeMFARequired, Message: "Two-factor code required for this amount"}
+	// ErrMFARequiredForAPIKey creating an API key needs a two-factor code in
+	// the X-OTP-Code header
+	ErrMFARequiredForAPIKey = &Error{Code: CodeMFARequired, Message: "Two-factor code required to create an API key"}
+	// ErrMFANotEnabled amount needs two-factor authentication, which the user
+	// has not set up
+	ErrMFANotEnabled = &Error{Code: CodeMFARequired, Message: "Enable two-factor authentication to move this amount"}
+	// ErrMFANotEnabledForAPIKey keys that take money out of the wallet need
+	// two-factor authentication, which the user has not set up
+	ErrMFANotEnabledForAPIKey = &Error{Code: CodeMFARequired, Message: "Enable two-factor authentication to create an API key that withdraws or transfers"}
+	// ErrInvalidOTP two-factor or recovery code is wrong or was already used
+	ErrInvalidOTP = &Error{Code: CodeInvalidOTP, Message: "Invalid two-factor code"}
+	// ErrMFAAlreadyEnabled two-factor authentication is already on
+	ErrMFAAlreadyEnabled = &Error{Code: CodeConflict, Message: "Two-factor authentication is already enabled"}
+	// ErrMFANotEnrolled confirm or disable without a started or enabled enrollment
+	ErrMFANotEnrolled = &Error{Code: CodeConflict, Message: "Two-factor authentication is not set up"}
+	// ErrNameTaken another user has the name, compared ignoring case
+	ErrNameTaken = &Error{Code: CodeConflict, Message: "User name already taken"}
+	// ErrInvalidAPIKey API key is malformed, unknown or revoked
+	ErrInvalidAPIKey = &Error{Code: CodeUnauthorized, Message: "Invalid or revoked API key"}
+	// ErrInsufficientScope API key was not granted the scope the route needs
+	ErrInsufficientScope = &Error{Code: CodeInsufficientScope, Message: "API key lacks the required scope"}
+	// ErrInvalidScope scope is not one of Scopes
+	ErrInvalidScope = &Error{Code: CodeInvalidInput, Message: "Scopes must be among wallet:read, wallet:deposit, wallet:withdraw, wallet:transfer"}
+	// ErrAPIKeyNotFound the caller has no API key with the given id
+	ErrAPIKeyNotFound = &Error{Code: CodeNotFound, Message: "API key not found"}
+	// ErrTooManyAPIKeys the caller already has the maximum number of active keys
+	ErrTooManyAPIKeys = &Error{Code: CodeConflict, Message: "Too many active API keys, revoke one first"}
+	// ErrTransactionNotFound the caller has no transaction with the given id
+	ErrTransactionNotFound = &Error{Code: CodeNotFound, Message: "Transaction not found"}
+	// ErrInvalidCursor pagination cursor is malformed
+	ErrInvalidCursor = &Error{Code: CodeInvalidInput, Message: "Invalid cursor"}
+	// ErrConflict request conflicts with the current state of a resource
+	ErrConflict = &Error{Code: CodeConflict, Message: "Conflict"}
+)
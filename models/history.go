@@ -0,0 +1,89 @@
+package models
+
+import "time"
+
+// HistoryTypes types of rows in a user's transaction history
+var HistoryTypes = []string{TxTypeDeposit, TxTypeWithdraw, TxTypeTransfer, TxTypeTransferIn, TxTypeAdjustmentCredit, TxTypeAdjustmentDebit}
+
+// ValidHistoryType reports whether t is one of HistoryTypes
+func ValidHistoryType(t string) bool {
+	for _, h := range HistoryTypes {
+		if h == t {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionFilter narrows a user's transaction history, zero fields do not
+// filter. Amounts are inclusive, From is inclusive and To exclusive.
+type TransactionFilter struct {
+	Types     []string
+	MinAmount *Money
+	MaxAmount *Money
+	From      *time.Time
+	To        *time.Time
+}
+
+// Matches reports whether t passes the filter
+func (f TransactionFilter) Matches(t Transaction) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, typ := range f.Types {
+			if typ == t.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.MinAmount != nil && t.Amount.Cmp(*f.MinAmount) < 0 {
+		return false
+	}
+	if f.MaxAmount != nil && t.Amount.Cmp(*f.MaxAmount) > 0 {
+		return false
+	}
+	if f.From != nil && t.CreatedAt.Before(*f.From) {
+		return false
+	}
+	if f.To != nil && !t.CreatedAt.Before(*f.To) {
+		return false
+	}
+	return true
+}
+
+// TransactionCursor position in the history, a page continues after it
+type TransactionCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// TransactionQuery one page of a user's transaction history, ordered by
+// created_at and then id so rows with equal times keep a stable order
+type TransactionQuery struct {
+	TransactionFilter
+	// Ascending oldest first, newest first otherwise
+	Ascending bool
+	// After continues after this row, nil starts at the beginning
+	After *TransactionCursor
+	// Limit maximum number of rows, 0 returns every match
+	Limit int
+}
+
+// Counterparty other side of a transaction: the other wallet of a transfer or
+// a system account
+type Counterparty struct {
+	Account string `json:"account"`
+	UserID  int    `json:"user_id,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// TransactionDetail transaction with its counterparty, nil for history from
+// before the ledger. BalanceAfter is derived from the ledger on rows recorded
+// before balances were stored.
+type TransactionDetail struct {
+	Transaction
+	Counterparty *Counterparty `json:"counterparty"`
+}
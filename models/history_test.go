@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionFilter_Matches(t *testing.T) {
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	later := at.Add(time.Hour)
+	ten, twenty := MustParseMoney("10"), MustParseMoney("20")
+	tx := Transaction{Type: TxTypeDeposit, Amount: ten, CreatedAt: at}
+
+	tests := []struct {
+		name   string
+		filter TransactionFilter
+		want   bool
+	}{
+		{"empty", TransactionFilter{}, true},
+		{"type", TransactionFilter{Types: []string{TxTypeWithdraw, TxTypeDeposit}}, true},
+		{"other type", TransactionFilter{Types: []string{TxTypeWithdraw}}, false},
+		{"min amount inclusive", TransactionFilter{MinAmount: &ten}, true},
+		{"below min amount", TransactionFilter{MinAmount: &twenty}, false},
+		{"max amount inclusive", TransactionFilter{MaxAmount: &ten}, true},
+		{"from inclusive", TransactionFilter{From: &at}, true},
+		{"before from", TransactionFilter{From: &later}, false},
+		{"to exclusive", TransactionFilter{To: &at}, false},
+		{"before to", TransactionFilter{To: &later}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Matches(tx))
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CashAccount system settlement account on the other side of deposits and
+// withdrawals, its balance is the negative of all money held in wallets
+const CashAccount = "system:cash"
+
+// AdjustmentsAccount system account on the other side of manual adjustments
+const AdjustmentsAccount = "system:adjustments"
+
+// Transaction types recorded in a user's history
+const (
+	TxTypeDeposit    = "deposit"
+	TxTypeWithdraw   = "withdraw"
+	TxTypeTransfer   = "transfer"
+	TxTypeTransferIn = "transfer_in"
+	// TxTypeAdjustment journal entry kind of manual adjustments, the history
+	// rows are credits or debits
+	TxTypeAdjustment       = "adjustment"
+	TxTypeAdjustmentCredit = "adjustment_credit"
+	TxTypeAdjustmentDebit  = "adjustment_debit"
+)
+
+var (
+	// ErrUnbalancedEntry journal entry postings do not sum to zero
+	ErrUnbalancedEntry = errors.New("journal entry does not balance")
+	// ErrUnknownAccount posting references an account that does not exist
+	ErrUnknownAccount = errors.New("unknown ledger account")
+)
+
+// UserAccount ledger account code of a user's wallet
+func UserAccount(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// Posting one line of a journal entry. Amounts are signed: positive credits
+// the account (increases a wallet balance), negative debits it.
+type Posting struct {
+	Account string
+	Amount  Money
+}
+
+// JournalEntry balanced set of postings recording one money movement
+type JournalEntry struct {
+	Kind        string
+	Description string
+	Postings    []Posting
+}
+
+// Validate checks the entry has at least two postings summing to zero
+func (e JournalEntry) Validate() error {
+	var sum Money
+	for _, p := range e.Postings {
+		sum = sum.Add(p.Amount)
+	}
+	if len(e.Postings) < 2 || !sum.IsZero() {
+		return ErrUnbalancedEntry
+	}
+	return nil
+}
+
+// WalletPosting posting to a user's ledger account with the journal entry it
+// belongs to
+type WalletPosting struct {
+	ID          int       `json:"id"`
+	EntryID     int       `json:"entry_id"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	Amount      Money     `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Transaction row of a user's transaction history. BalanceBefore and
+// BalanceAfter are the wallet balance around it, nil on rows recorded before
+// balances were stored.
+type Transaction struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"-"`
+	Type          string    `json:"type"`
+	Amount        Money     `json:"amount"`
+	Description   string    `json:"description"`
+	BalanceBefore *Money    `json:"balance_before"`
+	BalanceAfter  *Money    `json:"balance_after"`
+	EntryID       int       `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Delta signed change of the wallet balance, negative for debits
+func (t Transaction) Delta() Money {
+	switch t.Type {
+	case TxTypeWithdraw, TxTypeTransfer, TxTypeAdjustmentDebit:
+		return t.Amount.Neg()
+	}
+	return t.Amount
+}
+
+// WithBalance returns t with the balance it left the wallet at and the
+// balance before it
+func (t Transaction) WithBalance(after Money) Transaction {
+	before := after.Sub(t.Delta())
+	t.BalanceBefore, t.BalanceAfter = &before, &after
+	return t
+}
+
+// Adjustment manual balance correction of a user's wallet by an admin.
+// Amount is signed, negative debits the wallet.
+type Adjustment struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	AdminID   int       `json:"admin_id"`
+	Amount    Money     `json:"amount"`
+	Reason    string    `json:"reason"`
+	EntryID   int       `json:"entry_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BalanceMismatch wallet whose cached balance differs from its postings
+type BalanceMismatch struct {
+	UserID        int   `json:"user_id"`
+	Balance       Money `json:"balance"`
+	LedgerBalance Money `json:"ledger_balance"`
+}
+
+// LedgerReport result of verifying the ledger
+type LedgerReport struct {
+	UnbalancedEntries  []int             `json:"unbalanced_entries"`
+	MismatchedBalances []BalanceMismatch `json:"mismatched_balances"`
+}
+
+// Balanced reports whether the ledger verified cleanly
+func (r LedgerReport) Balanced() bool {
+	return len(r.UnbalancedEntries) == 0 && len(r.MismatchedBalances) == 0
+}
+
+// HistoryMismatch wallet whose transaction history, replayed from its first
+// recorded balance, does not end at its balance or disagrees with the
+// balances recorded on its rows
+type HistoryMismatch struct {
+	UserID          int   `json:"user_id"`
+	Balance         Money `json:"balance"`
+	ReplayedBalance Money `json:"replayed_balance"`
+	// DriftedAt first transaction whose balance_before or balance_after
+	// differs from the replay, 0 if all recorded balances agree
+	DriftedAt int `json:"drifted_at,omitempty"`
+}
+
+// HistoryReport result of replaying every wallet's transaction history
+type HistoryReport struct {
+	Mismatches []HistoryMismatch `json:"mismatched_histories"`
+	// Unverified wallets holding money or history without a single row that
+	// recorded a balance, there is nothing to replay them from
+	Unverified []int `json:"unverified_wallets"`
+	// LegacyRows rows recorded before balances were stored, left out of the
+	// replay since older history misses transfer_in rows and opening balances
+	LegacyRows int `json:"legacy_rows"`
+}
+
+// NewHistoryReport empty report
+func NewHistoryReport() *HistoryReport {
+	return &HistoryReport{Mismatches: []HistoryMismatch{}, Unverified: []int{}}
+}
+
+// Consistent reports whether every replayed history agreed
+func (r HistoryReport) Consistent() bool {
+	return len(r.Mismatches) == 0
+}
+
+// Replay replays the user's transactions, in the order they were applied,
+// starting at the balance_before of the first row that recorded one, and adds
+// the result to the report. Rows before it are counted as legacy rows.
+func (r *HistoryReport) Replay(userID int, balance Money, txs []Transaction) {
+	start := 0
+	for start < len(txs) && txs[start].BalanceBefore == nil {
+		start++
+	}
+	r.LegacyRows += start
+	if start == len(txs) {
+		if start > 0 || !balance.IsZero() {
+			r.Unverified = append(r.Unverified, userID)
+		}
+		return
+	}
+
+	m := HistoryMismatch{UserID: userID, Balance: balance, ReplayedBalance: *txs[start].BalanceBefore}
+	for _, t := range txs[start:] {
+		drifted := t.BalanceBefore != nil && !t.BalanceBefore.Equal(m.ReplayedBalance)
+		m.ReplayedBalance = m.ReplayedBalance.Add(t.Delta())
+		drifted = drifted || t.BalanceAfter != nil && !t.BalanceAfter.Equal(m.ReplayedBalance)
+		if drifted && m.DriftedAt == 0 {
+			m.DriftedAt = t.ID
+		}
+	}
+	if m.DriftedAt != 0 || !m.ReplayedBalance.Equal(balance) {
+		r.Mismatches = append(r.Mismatches, m)
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransaction_WithBalance(t *testing.T) {
+	debit := Transaction{Type: TxTypeTransfer, Amount: MustParseMoney("12.50")}.WithBalance(MustParseMoney("87.50"))
+	assert.Equal(t, "100.00", debit.BalanceBefore.String())
+	assert.Equal(t, "87.50", debit.BalanceAfter.String())
+
+	credit := Transaction{Type: TxTypeAdjustmentCredit, Amount: MustParseMoney("5")}.WithBalance(MustParseMoney("5"))
+	assert.Equal(t, "0.00", credit.BalanceBefore.String())
+}
+
+func TestHistoryReport_Replay(t *testing.T) {
+	row := func(id int, typ, amount string, balances ...string) Transaction {
+		t := Transaction{ID: id, Type: typ, Amount: MustParseMoney(amount)}
+		if len(balances) == 2 {
+			before, after := MustParseMoney(balances[0]), MustParseMoney(balances[1])
+			t.BalanceBefore, t.BalanceAfter = &before, &after
+		}
+		return t
+	}
+
+	tests := []struct {
+		name       string
+		balance    string
+		txs        []Transaction
+		replayed   string
+		drifted    int
+		unverified bool
+		legacy     int
+	}{
+		{name: "no history", balance: "0"},
+		{
+			name:    "consistent",
+			balance: "70",
+			txs: []Transaction{
+				row(1, TxTypeDeposit, "100", "0", "100"),
+				row(2, TxTypeTransfer, "40", "100", "60"),
+				row(3, TxTypeTransferIn, "10", "60", "70"),
+			},
+		},
+		{
+			name:    "replay starts at the first recorded balance",
+			balance: "90",
+			txs: []Transaction{
+				row(1, TxTypeDeposit, "30", "20", "50"),
+				row(2, TxTypeDeposit, "40", "50", "90"),
+			},
+		},
+		{
+			name:    "legacy rows are skipped",
+			balance: "60",
+			// the transfer_in that funded the wallet predates history
+			txs:    []Transaction{row(1, TxTypeWithdraw, "100"), row(2, TxTypeWithdraw, "40", "100", "60")},
+			legacy: 1,
+		},
+		{
+			name:       "only legacy rows",
+			balance:    "60",
+			txs:        []Transaction{row(1, TxTypeDeposit, "100"), row(2, TxTypeWithdraw, "40")},
+			unverified: true,
+			legacy:     2,
+		},
+		{name: "balance without history", balance: "5", unverified: true},
+		{
+			name:     "balance changed without history",
+			balance:  "80",
+			txs:      []Transaction{row(1, TxTypeDeposit, "100", "0", "100")},
+			replayed: "100",
+		},
+		{
+			name:    "recorded balances drift",
+			balance: "90",
+			txs: []Transaction{
+				row(1, TxTypeDeposit, "100", "0", "100"),
+				row(2, TxTypeAdjustmentDebit, "10", "100", "95"),
+				row(3, TxTypeDeposit, "5", "95", "100"),
+			},
+			replayed: "95",
+			drifted:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewHistoryReport()
+			r.Replay(7, MustParseMoney(tt.balance), tt.txs)
+			assert.Equal(t, tt.legacy, r.LegacyRows)
+			if tt.unverified {
+				assert.Equal(t, []int{7}, r.Unverified)
+			} else {
+				assert.Empty(t, r.Unverified)
+			}
+			if tt.replayed == "" && tt.drifted == 0 {
+				assert.Empty(t, r.Mismatches)
+				assert.True(t, r.Consistent())
+				return
+			}
+			assert.False(t, r.Consistent())
+			if assert.Len(t, r.Mismatches, 1) {
+				m := r.Mismatches[0]
+				assert.Equal(t, 7, m.UserID)
+				assert.Equal(t, MustParseMoney(tt.replayed).String(), m.ReplayedBalance.String())
+				assert.Equal(t, tt.drifted, m.DriftedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Login attempt outcomes
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "invalid_credentials"
+	LoginLocked    = "locked"
+	// LoginChallenged password was right, a two-factor code was requested
+	LoginChallenged = "mfa_required"
+	// LoginInvalidOTP two-factor code of the second step was wrong
+	LoginInvalidOTP = "invalid_otp"
+)
+
+// LoginAttempt entry of a user's login history
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"-"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginFailures consecutive failed logins of a user or client IP. Failures
+// restart from one once the last failure is older than the failure window.
+type LoginFailures struct {
+	Failures     int
+	LastFailedAt time.Time
+	// LockedUntil zero if not locked
+	LockedUntil time.Time
+}
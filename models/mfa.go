@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// MFA two-factor state of a user. Secret is set when enrollment starts,
+// EnabledAt once the user confirmed it with a code.
+type MFA struct {
+	UserID int
+	Secret string
+	// EnabledAt nil while enrollment is pending
+	EnabledAt *time.Time
+	// LastCounter time step of the last accepted code, codes of it and
+	// earlier steps are rejected
+	LastCounter int64
+}
+
+// Enabled reports whether codes are required for the user
+func (m MFA) Enabled() bool {
+	return m.Secret != "" && m.EnabledAt != nil
+}
@@ -0,0 +1,174 @@
+// Package models data models
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// MoneyScale number of decimal places the currency allows, matches numeric(10,2)
+const MoneyScale = 2
+
+// maxMoney exclusive upper bound of numeric(10,2)
+var maxMoney = decimal.New(1, 10-MoneyScale)
+
+var (
+	// ErrTooPrecise amount has more decimal places than the currency allows
+	ErrTooPrecise = errors.New("amount has too many decimal places")
+	// ErrOutOfRange amount does not fit in the balance column
+	ErrOutOfRange = errors.New("amount out of range")
+)
+
+// Money exact monetary amount
+type Money struct {
+	d decimal.Decimal
+}
+
+// ParseMoney parse a decimal string such as "12.34" into Money
+func ParseMoney(s string) (Money, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return Money{}, err
+	}
+	return newMoney(d)
+}
+
+// MustParseMoney like ParseMoney but panics on error, for constants and tests
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// newMoney validate precision and range of d
+func newMoney(d decimal.Decimal) (Money, error) {
+	if !d.Equal(d.Truncate(MoneyScale)) {
+		return Money{}, ErrTooPrecise
+	}
+	if d.Abs().Cmp(maxMoney) >= 0 {
+		return Money{}, ErrOutOfRange
+	}
+	return Money{d: d}, nil
+}
+
+// InRange reports whether m fits the numeric(10,2) balance columns, results
+// of Add and Sub are not checked
+func (m Money) InRange() bool {
+	return m.d.Abs().Cmp(maxMoney) < 0
+}
+
+// Add returns m + o
+func (m Money) Add(o Money) Money {
+	return Money{d: m.d.Add(o.d)}
+}
+
+// Sub returns m - o
+func (m Money) Sub(o Money) Money {
+	return Money{d: m.d.Sub(o.d)}
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return Money{d: m.d.Neg()}
+}
+
+// Cmp compares m and o, returns -1, 0 or +1
+func (m Money) Cmp(o Money) int {
+	return m.d.Cmp(o.d)
+}
+
+// Equal reports whether m and o are the same amount
+func (m Money) Equal(o Money) bool {
+	return m.d.Equal(o.d)
+}
+
+// IsPositive reports whether m > 0
+func (m Money) IsPositive() bool {
+	return m.d.IsPositive()
+}
+
+// IsNegative reports whether m < 0
+func (m Money) IsNegative() bool {
+	return m.d.IsNegative()
+}
+
+// IsZero reports whether m == 0
+func (m Money) IsZero() bool {
+	return m.d.IsZero()
+}
+
+// Float64 approximate value of m, for metrics only, never for arithmetic
+func (m Money) Float64() float64 {
+	f, _ := m.d.Float64()
+	return f
+}
+
+// String formats m with exactly MoneyScale decimal places
+func (m Money) String() string {
+	return m.d.StringFixed(MoneyScale)
+}
+
+// MarshalJSON encodes m as a string so clients never see a float
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts a JSON number or string and rejects excess precision
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	// parse the literal text directly so no value ever passes through float64
+	parsed, err := ParseMoney(string(data))
+	if err != nil {
+		return fmt.Errorf("invalid amount %q: %w", data, err)
+	}
+	*m = parsed
+	return nil
+}
+
+// Value implements driver.Valuer, amounts are sent to the database as text
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan implements sql.Scanner for numeric columns
+func (m *Money) Scan(src interface{}) error {
+	var d decimal.Decimal
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case []byte:
+		if err := d.UnmarshalText(v); err != nil {
+			return err
+		}
+	case string:
+		if err := d.UnmarshalText([]byte(v)); err != nil {
+			return err
+		}
+	case int64:
+		d = decimal.NewFromInt(v)
+	case float64:
+		d = decimal.NewFromFloat(v).Round(MoneyScale)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	*m = Money{d: d}
+	return nil
+}
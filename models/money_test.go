@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "integer number", input: `100`, want: "100.00"},
+		{name: "decimal number", input: `0.1`, want: "0.10"},
+		{name: "string", input: `"12.34"`, want: "12.34"},
+		{name: "trailing zeros", input: `1.500`, want: "1.50"},
+		{name: "too precise", input: `0.001`, wantErr: true},
+		{name: "too precise string", input: `"10.005"`, wantErr: true},
+		{name: "out of range", input: `100000000`, wantErr: true},
+		{name: "largest value", input: `99999999.99`, want: "99999999.99"},
+		{name: "not a number", input: `"abc"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			err := json.Unmarshal([]byte(tt.input), &m)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, m.String())
+		})
+	}
+}
+
+func TestMoneyArithmeticIsExact(t *testing.T) {
+	total := Money{}
+	for i := 0; i < 10; i++ {
+		total = total.Add(MustParseMoney("0.10"))
+	}
+	assert.True(t, total.Equal(MustParseMoney("1.00")))
+	assert.Equal(t, "0.70", MustParseMoney("1.00").Sub(MustParseMoney("0.30")).String())
+}
+
+func TestMoneyInRange(t *testing.T) {
+	largest := MustParseMoney("99999999.99")
+	assert.True(t, largest.InRange())
+	assert.True(t, largest.Neg().InRange())
+	assert.False(t, largest.Add(MustParseMoney("0.01")).InRange())
+}
+
+func TestMoneyFloat64(t *testing.T) {
+	assert.Equal(t, 12.5, MustParseMoney("12.50").Float64())
+	assert.Equal(t, -0.01, MustParseMoney("-0.01").Float64())
+}
+
+func TestMoneyMarshalJSON(t *testing.T) {
+	out, err := json.Marshal(map[string]Money{"amount": MustParseMoney("5")})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"amount":"5.00"}`, string(out))
+}
+
+func TestMoneyScan(t *testing.T) {
+	var m Money
+	assert.NoError(t, m.Scan([]byte("42.10")))
+	assert.Equal(t, "42.10", m.String())
+
+	assert.NoError(t, m.Scan(float64(0.3)))
+	assert.Equal(t, "0.30", m.String())
+
+	assert.Error(t, m.Scan(true))
+}
@@ -0,0 +1,24 @@
+package models
+
+// Roles, every user has exactly one. Support staff can look up users and
+// wallets, auditors can also verify the ledger and review adjustments, admins
+// can do everything including moving money on other wallets.
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+// Roles every valid role
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin, RoleAuditor}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// RefreshToken server side record of an issued refresh token. Refreshing marks
+// the token used and issues the next one in the same family, the family id is
+// also the session id carried by the access tokens.
+type RefreshToken struct {
+	ID        int
+	TokenHash string
+	FamilyID  string
+	UserID    int
+	// AccessTokenID jti of the access token issued together with this token,
+	// denylisted when the family is revoked
+	AccessTokenID   string
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time
+	UsedAt          *time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+}
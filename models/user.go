@@ -0,0 +1,75 @@
+package models
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// User registered wallet user
+type User struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	PasswordHash string `json:"-"`
+	Role         string `json:"role"`
+	Profile
+	Balance   Money     `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Profile optional user details, empty strings when not set
+type Profile struct {
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone"`
+}
+
+// Limits of the users columns
+const (
+	MaxNameLength        = 100
+	MaxDisplayNameLength = 100
+	maxEmailLength       = 254
+)
+
+var (
+	// ErrInvalidDisplayName display name too long or containing control characters
+	ErrInvalidDisplayName = &Error{Code: CodeInvalidInput, Message: "Display name must be at most 100 characters without control characters"}
+	// ErrInvalidEmail email is not a plain address
+	ErrInvalidEmail = &Error{Code: CodeInvalidInput, Message: "Invalid email address"}
+	// ErrInvalidPhone phone number is not in international format
+	ErrInvalidPhone = &Error{Code: CodeInvalidInput, Message: "Phone must be in international format, e.g. +4915112345678"}
+)
+
+// e164 international phone number
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// phoneSeparators characters people write phone numbers with
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+
+// Normalize trims the fields, strips separators from the phone number,
+// lowercases the email domain and validates the result
+func (p Profile) Normalize() (Profile, error) {
+	p.DisplayName = strings.TrimSpace(p.DisplayName)
+	if utf8.RuneCountInString(p.DisplayName) > MaxDisplayNameLength || strings.IndexFunc(p.DisplayName, unicode.IsControl) >= 0 {
+		return p, ErrInvalidDisplayName
+	}
+
+	p.Email = strings.TrimSpace(p.Email)
+	if p.Email != "" {
+		addr, err := mail.ParseAddress(p.Email)
+		if err != nil || addr.Address != p.Email || len(p.Email) > maxEmailLength {
+			return p, ErrInvalidEmail
+		}
+		local, domain, _ := strings.Cut(p.Email, "@")
+		p.Email = local + "@" + strings.ToLower(domain)
+	}
+
+	p.Phone = phoneSeparators.Replace(strings.TrimSpace(p.Phone))
+	if p.Phone != "" && !e164.MatchString(p.Phone) {
+		return p, ErrInvalidPhone
+	}
+	return p, nil
+}
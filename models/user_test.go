@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfile_Normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      Profile
+		want    Profile
+		wantErr error
+	}{
+		{"empty", Profile{}, Profile{}, nil},
+		{
+			"trimmed and normalized",
+			Profile{DisplayName: "  Alice Smith ", Email: " Alice@Example.COM ", Phone: "+49 (151) 123-456.78"},
+			Profile{DisplayName: "Alice Smith", Email: "Alice@example.com", Phone: "+4915112345678"},
+			nil,
+		},
+		{"display name too long", Profile{DisplayName: strings.Repeat("a", 101)}, Profile{}, ErrInvalidDisplayName},
+		{"display name with newline", Profile{DisplayName: "Alice\nAdmin"}, Profile{}, ErrInvalidDisplayName},
+		{"display name in characters", Profile{DisplayName: strings.Repeat("ä", 100)}, Profile{DisplayName: strings.Repeat("ä", 100)}, nil},
+		{"email without at", Profile{Email: "alice.example.com"}, Profile{}, ErrInvalidEmail},
+		{"email with name", Profile{Email: "Alice <alice@example.com>"}, Profile{}, ErrInvalidEmail},
+		{"phone without country code", Profile{Phone: "0151 12345678"}, Profile{}, ErrInvalidPhone},
+		{"phone with letters", Profile{Phone: "+49 CALL-ME"}, Profile{}, ErrInvalidPhone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.in.Normalize()
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Package password password policy and hashing. Hashes are bcrypt or argon2id
+// in PHC format, Verify reports when a hash should be redone with the current
+// parameters.
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gin-wallet2/models"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MaxBytes longest password accepted, bcrypt ignores everything after it
+const MaxBytes = 72
+
+// Hash algorithms
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+var (
+	// ErrMismatch password does not match the hash
+	ErrMismatch = errors.New("password: mismatch")
+
+	// ErrTooLong password is longer than MaxBytes
+	ErrTooLong = &models.Error{Code: models.CodeWeakPassword, Message: fmt.Sprintf("Password must be at most %d bytes", MaxBytes)}
+	// ErrCommon password is on the common password list
+	ErrCommon = &models.Error{Code: models.CodeWeakPassword, Message: "Password is too common"}
+	// ErrSameAsName password equals the user name
+	ErrSameAsName = &models.Error{Code: models.CodeWeakPassword, Message: "Password must not be the user name"}
+)
+
+//go:embed common.txt
+var commonList []byte
+
+// common lowercase common passwords, loaded once from common.txt
+var common = func() map[string]bool {
+	set := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(commonList))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			set[line] = true
+		}
+	}
+	return set
+}()
+
+// Policy rules a new password has to satisfy
+type Policy struct {
+	// MinLength in characters
+	MinLength int
+	// Common rejected passwords, lowercase
+	Common map[string]bool
+}
+
+// NewPolicy policy with the shipped common password list
+func NewPolicy(minLength int) Policy {
+	return Policy{MinLength: minLength, Common: common}
+}
+
+// Validate checks password for the user name, the error is a *models.Error
+// telling the user what to change
+func (p Policy) Validate(name, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &models.Error{Code: models.CodeWeakPassword, Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength)}
+	}
+	if len(password) > MaxBytes {
+		return ErrTooLong
+	}
+	lower := strings.ToLower(password)
+	if p.Common[lower] {
+		return ErrCommon
+	}
+	if lower == strings.ToLower(name) {
+		return ErrSameAsName
+	}
+	return nil
+}
+
+// Argon2Params argon2id cost, Memory in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultArgon2 OWASP's recommended minimum
+var DefaultArgon2 = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Hasher hashes new passwords with Algorithm. The zero value uses bcrypt with
+// bcrypt.DefaultCost, a zero Argon2 uses DefaultArgon2.
+type Hasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+func (h Hasher) bcryptCost() int {
+	if h.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.BcryptCost
+}
+
+func (h Hasher) argon2() Argon2Params {
+	if h.Argon2 == (Argon2Params{}) {
+		return DefaultArgon2
+	}
+	return h.Argon2
+}
+
+// Hash hashes password with the current algorithm and parameters
+func (h Hasher) Hash(password string) (string, error) {
+	if h.Algorithm != Argon2id {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+		return string(hash), err
+	}
+
+	p := h.argon2()
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks password against encoded, a hash of either algorithm. rehash
+// is true when the password matched but encoded was made with another
+// algorithm or other parameters than Hash would use now.
+func (h Hasher) Verify(encoded, password string) (rehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		p, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, err
+		}
+		got := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return false, ErrMismatch
+		}
+		return h.Algorithm != Argon2id || p != h.argon2(), nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, ErrMismatch
+	} else if err != nil {
+		return false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, err
+	}
+	return h.Algorithm == Argon2id || cost != h.bcryptCost(), nil
+}
+
+// decodeArgon2 parses $argon2id$v=19$m=..,t=..,p=..$salt$key
+func decodeArgon2(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("password: malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("password: unsupported argon2 version %q", parts[2])
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, fmt.Errorf("password: malformed argon2id parameters %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("password: malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errors.New("password: malformed argon2id key")
+	}
+	return p, salt, key, nil
+}
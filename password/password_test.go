@@ -0,0 +1,113 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	p := NewPolicy(10)
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		wantErr  string
+	}{
+		{"ok", "alice", "correct horse battery", ""},
+		{"too short", "alice", "Xk9#mQ2", "Password must be at least 10 characters"},
+		{"length in characters", "alice", "пароль-пароль", ""},
+		{"too long", "alice", strings.Repeat("x", MaxBytes+1), "Password must be at most 72 bytes"},
+		{"common", "alice", "Password123", "Password is too common"},
+		{"same as name", "Alice.Smith", "alice.smith", "Password must not be the user name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(tt.user, tt.password)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommonList(t *testing.T) {
+	assert.True(t, common["password123"])
+	assert.True(t, common["qwertyuiop"])
+	for pw := range common {
+		assert.False(t, strings.HasPrefix(pw, "#"), "comment loaded as %q", pw)
+		assert.Equal(t, strings.ToLower(pw), pw)
+	}
+}
+
+// fastArgon2 keeps the tests quick
+var fastArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestHasher(t *testing.T) {
+	bcrypt4 := Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+	bcrypt5 := Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}
+	argon := Hasher{Algorithm: Argon2id, Argon2: fastArgon2}
+	argonMore := Hasher{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1}}
+
+	tests := []struct {
+		name       string
+		hashWith   Hasher
+		verifyWith Hasher
+		rehash     bool
+	}{
+		{"bcrypt same cost", bcrypt4, bcrypt4, false},
+		{"bcrypt cost changed", bcrypt4, bcrypt5, true},
+		{"bcrypt to argon2id", bcrypt4, argon, true},
+		{"argon2id same params", argon, argon, false},
+		{"argon2id params changed", argon, argonMore, true},
+		{"argon2id to bcrypt", argon, bcrypt4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hashWith.Hash("correct horse battery")
+			require.NoError(t, err)
+
+			rehash, err := tt.verifyWith.Verify(hash, "correct horse battery")
+			require.NoError(t, err)
+			assert.Equal(t, tt.rehash, rehash)
+
+			_, err = tt.verifyWith.Verify(hash, "wrong horse battery")
+			assert.ErrorIs(t, err, ErrMismatch)
+		})
+	}
+}
+
+func TestHasher_Argon2Format(t *testing.T) {
+	hash, err := Hasher{Algorithm: Argon2id, Argon2: fastArgon2}.Hash("correct horse battery")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+
+	other, err := Hasher{Algorithm: Argon2id, Argon2: fastArgon2}.Hash("correct horse battery")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salted")
+}
+
+func TestHasher_Malformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+	} {
+		_, err := Hasher{}.Verify(hash, "correct horse battery")
+		assert.Error(t, err, hash)
+		assert.NotErrorIs(t, err, ErrMismatch, hash)
+	}
+}
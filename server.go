@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"gin-wallet2/config"
+	"gin-wallet2/handlers"
+	"net"
+	"net/http"
+	"time"
+)
+
+// errShuttingDown readiness fails as soon as shutdown starts, so load
+// balancers stop routing new requests while in-flight ones drain
+var errShuttingDown = errors.New("shutting down")
+
+// shutdownCheck readiness check failing once ctx, the signal context, is done
+func shutdownCheck(ctx context.Context) handlers.Check {
+	return handlers.Check{Name: "shutdown", Check: func(context.Context) error {
+		if ctx.Err() != nil {
+			return errShuttingDown
+		}
+		return nil
+	}}
+}
+
+// newServer HTTP server for handler. Request contexts derive from the returned
+// context, cancelling it aborts the SQL calls of requests still running.
+func newServer(cfg config.HTTP, addr string, handler http.Handler) (*http.Server, context.CancelFunc) {
+	base, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		BaseContext:  func(net.Listener) context.Context { return base },
+	}
+	return srv, cancel
+}
+
+// serve runs srv on ln until ctx is done, then stops accepting connections and
+// gives in-flight requests up to drain to finish. Requests still running after
+// that have their contexts cancelled so their transactions roll back, and
+// context.DeadlineExceeded is returned.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, drain time.Duration, cancelRequests context.CancelFunc) error {
+	defer cancelRequests()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		cancelRequests()
+		_ = srv.Close()
+	}
+	if serveErr := <-errc; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+		err = serveErr
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"gin-wallet2/config"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler on a random port until the returned stop is
+// called, serve's result is sent on the channel
+func startServer(t *testing.T, handler http.Handler, drain time.Duration) (string, context.CancelFunc, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv, cancelRequests := newServer(config.HTTP{ReadTimeout: time.Second, WriteTimeout: 5 * time.Second, IdleTimeout: time.Second}, ln.Addr().String(), handler)
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, srv, ln, drain, cancelRequests) }()
+	return "http://" + ln.Addr().String(), stop, done
+}
+
+func TestServe(t *testing.T) {
+	t.Run("in-flight request finishes during shutdown", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		url, stop, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			_, _ = io.WriteString(w, "ok")
+		}), 5*time.Second)
+
+		type result struct {
+			body string
+			err  error
+		}
+		resc := make(chan result, 1)
+		go func() {
+			resp, err := http.Get(url)
+			if err != nil {
+				resc <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			resc <- result{string(body), err}
+		}()
+
+		<-started
+		stop()
+		// new connections are refused while the request drains
+		assert.Eventually(t, func() bool {
+			_, err := net.DialTimeout("tcp", url[len("http://"):], 100*time.Millisecond)
+			return err != nil
+		}, time.Second, 10*time.Millisecond)
+		close(release)
+
+		res := <-resc
+		require.NoError(t, res.err)
+		assert.Equal(t, "ok", res.body)
+		assert.NoError(t, <-done)
+		http.DefaultClient.CloseIdleConnections()
+	})
+
+	t.Run("request past the deadline is cancelled", func(t *testing.T) {
+		started, cancelled := make(chan struct{}), make(chan struct{})
+		url, stop, done := startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			// a transaction blocked on a lock, only the context gets it out
+			<-r.Context().Done()
+			close(cancelled)
+		}), 50*time.Millisecond)
+
+		errc := make(chan error, 1)
+		go func() {
+			resp, err := http.Get(url)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errc <- err
+		}()
+
+		<-started
+		stop()
+		assert.ErrorIs(t, <-done, context.DeadlineExceeded)
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("request context was not cancelled")
+		}
+		<-errc
+		http.DefaultClient.CloseIdleConnections()
+	})
+}
+
+func TestShutdownCheck(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	check := shutdownCheck(ctx)
+
+	assert.NoError(t, check.Check(context.Background()))
+	stop()
+	assert.ErrorIs(t, check.Check(context.Background()), errShuttingDown)
+}
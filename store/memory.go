@@ -0,0 +1,466 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory in-process UserStore, WalletStore, TokenStore, LoginStore, MFAStore
+// and APIKeyStore for tests and local runs. Units of work are serialized and applied
+// atomically to a copy of the state.
+type Memory struct {
+	mu     sync.Mutex
+	state  memoryState
+	tokens memoryTokens
+	logins memoryLogins
+	mfa    map[int]memoryMFA
+	keys   memoryAPIKeys
+	now    func() time.Time
+}
+
+type memoryEntry struct {
+	id          int
+	kind        string
+	description string
+	postings    []models.Posting
+	createdAt   time.Time
+}
+
+type memoryState struct {
+	users        map[int]models.User
+	accounts     map[string]bool
+	entries      []memoryEntry
+	transactions []models.Transaction
+	adjustments  []models.Adjustment
+	nextUserID   int
+}
+
+// clone copies the state so a unit of work can be discarded
+func (s memoryState) clone() memoryState {
+	cp := s
+	cp.users = make(map[int]models.User, len(s.users))
+	for id, u := range s.users {
+		cp.users[id] = u
+	}
+	cp.accounts = make(map[string]bool, len(s.accounts))
+	for code := range s.accounts {
+		cp.accounts[code] = true
+	}
+	cp.entries = append([]memoryEntry(nil), s.entries...)
+	cp.transactions = append([]models.Transaction(nil), s.transactions...)
+	cp.adjustments = append([]models.Adjustment(nil), s.adjustments...)
+	return cp
+}
+
+// NewMemory new in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		state: memoryState{
+			users:    make(map[int]models.User),
+			accounts: map[string]bool{models.CashAccount: true, models.AdjustmentsAccount: true},
+		},
+		tokens: memoryTokens{
+			refresh: make(map[string]*models.RefreshToken),
+			revoked: make(map[string]time.Time),
+		},
+		logins: memoryLogins{failures: make(map[string]models.LoginFailures)},
+		mfa:    make(map[int]memoryMFA),
+		keys:   memoryAPIKeys{byPrefix: make(map[string]*models.APIKey)},
+		now:    time.Now,
+	}
+}
+
+// CreateUser implements UserStore
+func (m *Memory) CreateUser(_ context.Context, name, passwordHash string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.state.users {
+		if strings.EqualFold(u.Name, name) {
+			return 0, models.ErrNameTaken
+		}
+	}
+	m.state.nextUserID++
+	id := m.state.nextUserID
+	m.state.users[id] = models.User{ID: id, Name: name, PasswordHash: passwordHash, Role: models.RoleUser, CreatedAt: m.now()}
+	m.state.accounts[models.UserAccount(id)] = true
+	return id, nil
+}
+
+// UserByName implements UserStore
+func (m *Memory) UserByName(_ context.Context, name string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.state.users {
+		if strings.EqualFold(u.Name, name) {
+			return &u, nil
+		}
+	}
+	return nil, models.ErrUserNotFound
+}
+
+// UserByID implements UserStore
+func (m *Memory) UserByID(_ context.Context, id int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.state.users[id]
+	if !ok {
+		return nil, models.ErrUserNotFound
+	}
+	return &u, nil
+}
+
+// UpdatePassword implements UserStore
+func (m *Memory) UpdatePassword(_ context.Context, id int, passwordHash string) error {
+	return m.updateUser(id, func(u *models.User) { u.PasswordHash = passwordHash })
+}
+
+// UpdateProfile implements UserStore
+func (m *Memory) UpdateProfile(_ context.Context, id int, p models.Profile) error {
+	return m.updateUser(id, func(u *models.User) { u.Profile = p })
+}
+
+// UpdateRole implements UserStore
+func (m *Memory) UpdateRole(_ context.Context, id int, role string) error {
+	return m.updateUser(id, func(u *models.User) { u.Role = role })
+}
+
+// SearchUsers implements UserStore
+func (m *Memory) SearchUsers(_ context.Context, query string, limit int) ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.ToLower(query)
+	users := []models.User{}
+	for _, u := range m.state.users {
+		if strings.Contains(strings.ToLower(u.Name), query) ||
+			strings.Contains(strings.ToLower(u.DisplayName), query) ||
+			strings.Contains(strings.ToLower(u.Email), query) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (m *Memory) updateUser(id int, update func(u *models.User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.state.users[id]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	update(&u)
+	m.state.users[id] = u
+	return nil
+}
+
+// WithinTx implements WalletStore
+func (m *Memory) WithinTx(_ context.Context, fn func(tx WalletTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	staged := m.state.clone()
+	if err := fn(&memoryTx{state: &staged, now: m.now}); err != nil {
+		return err
+	}
+	m.state = staged
+	return nil
+}
+
+// Balance implements WalletStore
+func (m *Memory) Balance(_ context.Context, userID int) (models.Money, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.state.users[userID]
+	if !ok {
+		return models.Money{}, models.ErrUserNotFound
+	}
+	return u.Balance, nil
+}
+
+// Transactions implements WalletStore
+func (m *Memory) Transactions(_ context.Context, userID int, q models.TransactionQuery) ([]models.Transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// before reports whether a comes first in the requested order
+	before := func(a, b models.Transaction) bool {
+		if q.Ascending {
+			return historyLess(a, b)
+		}
+		return historyLess(b, a)
+	}
+	transactions := []models.Transaction{}
+	for _, t := range m.state.transactions {
+		if t.UserID != userID || !q.Matches(t) {
+			continue
+		}
+		if q.After != nil && !before(models.Transaction{CreatedAt: q.After.CreatedAt, ID: q.After.ID}, t) {
+			continue
+		}
+		transactions = append(transactions, t)
+	}
+	sort.Slice(transactions, func(i, j int) bool { return before(transactions[i], transactions[j]) })
+	if q.Limit > 0 && len(transactions) > q.Limit {
+		transactions = transactions[:q.Limit]
+	}
+	return transactions, nil
+}
+
+// historyLess orders history by created_at and then id
+func historyLess(a, b models.Transaction) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// CountTransactions implements WalletStore
+func (m *Memory) CountTransactions(_ context.Context, userID int, f models.TransactionFilter) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, t := range m.state.transactions {
+		if t.UserID == userID && f.Matches(t) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Transaction implements WalletStore
+func (m *Memory) Transaction(_ context.Context, userID, id int) (*models.TransactionDetail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.state.transactions {
+		if t.ID != id || t.UserID != userID {
+			continue
+		}
+		detail := &models.TransactionDetail{Transaction: t}
+		if t.EntryID == 0 {
+			return detail, nil
+		}
+		account := models.UserAccount(userID)
+		var balance models.Money
+		for _, e := range m.state.entries {
+			if e.id > t.EntryID {
+				break
+			}
+			for _, p := range e.postings {
+				if p.Account == account {
+					balance = balance.Add(p.Amount)
+				} else if e.id == t.EntryID && detail.Counterparty == nil {
+					detail.Counterparty = m.state.counterparty(p.Account)
+				}
+			}
+		}
+		if detail.BalanceAfter == nil {
+			detail.BalanceAfter = &balance
+		}
+		return detail, nil
+	}
+	return nil, models.ErrTransactionNotFound
+}
+
+// counterparty describes account, naming its user for wallets
+func (s memoryState) counterparty(account string) *models.Counterparty {
+	c := &models.Counterparty{Account: account}
+	for id, u := range s.users {
+		if models.UserAccount(id) == account {
+			c.UserID, c.Name = id, u.Name
+		}
+	}
+	return c
+}
+
+// VerifyLedger implements WalletStore
+func (m *Memory) VerifyLedger(_ context.Context) (*models.LedgerReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := &models.LedgerReport{
+		UnbalancedEntries:  []int{},
+		MismatchedBalances: []models.BalanceMismatch{},
+	}
+	sums := make(map[string]models.Money)
+	for _, e := range m.state.entries {
+		var total models.Money
+		for _, p := range e.postings {
+			total = total.Add(p.Amount)
+			sums[p.Account] = sums[p.Account].Add(p.Amount)
+		}
+		if !total.IsZero() {
+			report.UnbalancedEntries = append(report.UnbalancedEntries, e.id)
+		}
+	}
+
+	ids := make([]int, 0, len(m.state.users))
+	for id := range m.state.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		balance := m.state.users[id].Balance
+		if ledger := sums[models.UserAccount(id)]; !ledger.Equal(balance) {
+			report.MismatchedBalances = append(report.MismatchedBalances,
+				models.BalanceMismatch{UserID: id, Balance: balance, LedgerBalance: ledger})
+		}
+	}
+	return report, nil
+}
+
+// VerifyHistory implements WalletStore
+func (m *Memory) VerifyHistory(_ context.Context) (*models.HistoryReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	history := make(map[int][]models.Transaction)
+	for _, t := range m.state.transactions {
+		history[t.UserID] = append(history[t.UserID], t)
+	}
+	ids := make([]int, 0, len(m.state.users))
+	for id := range m.state.users {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	report := models.NewHistoryReport()
+	for _, id := range ids {
+		report.Replay(id, m.state.users[id].Balance, history[id])
+	}
+	return report, nil
+}
+
+// Adjustments implements WalletStore
+func (m *Memory) Adjustments(_ context.Context, userID, limit int) ([]models.Adjustment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	adjustments := []models.Adjustment{}
+	for i := len(m.state.adjustments) - 1; i >= 0 && len(adjustments) < limit; i-- {
+		if a := m.state.adjustments[i]; userID == 0 || a.UserID == userID {
+			adjustments = append(adjustments, a)
+		}
+	}
+	return adjustments, nil
+}
+
+// Postings implements WalletStore. Postings are numbered in the order they
+// were posted across all entries.
+func (m *Memory) Postings(_ context.Context, userID, limit int) ([]models.WalletPosting, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account := models.UserAccount(userID)
+	if !m.state.accounts[account] {
+		return nil, models.ErrUserNotFound
+	}
+	var all []models.WalletPosting
+	id := 0
+	for _, e := range m.state.entries {
+		for _, p := range e.postings {
+			id++
+			if p.Account == account {
+				all = append(all, models.WalletPosting{
+					ID: id, EntryID: e.id, Kind: e.kind, Description: e.description, Amount: p.Amount, CreatedAt: e.createdAt,
+				})
+			}
+		}
+	}
+
+	postings := []models.WalletPosting{}
+	for i := len(all) - 1; i >= 0 && len(postings) < limit; i-- {
+		postings = append(postings, all[i])
+	}
+	return postings, nil
+}
+
+// memoryTx WalletTx over a staged copy of the state
+type memoryTx struct {
+	state *memoryState
+	now   func() time.Time
+}
+
+// LockBalances implements WalletTx, the whole store is already locked
+func (t *memoryTx) LockBalances(_ context.Context, userIDs ...int) (map[int]models.Money, error) {
+	balances := make(map[int]models.Money, len(userIDs))
+	for _, id := range userIDs {
+		u, ok := t.state.users[id]
+		if !ok {
+			return nil, models.ErrUserNotFound
+		}
+		balances[id] = u.Balance
+	}
+	return balances, nil
+}
+
+// AdjustBalance implements WalletTx, enforcing the non-negative balance check
+// the users table has
+func (t *memoryTx) AdjustBalance(_ context.Context, userID int, delta models.Money) (models.Money, error) {
+	u, ok := t.state.users[userID]
+	if !ok {
+		return models.Money{}, models.ErrUserNotFound
+	}
+	balance := u.Balance.Add(delta)
+	if balance.IsNegative() {
+		return models.Money{}, models.ErrInsufficientFunds
+	}
+	u.Balance = balance
+	t.state.users[userID] = u
+	return balance, nil
+}
+
+// PostEntry implements WalletTx
+func (t *memoryTx) PostEntry(_ context.Context, entry models.JournalEntry) (int, error) {
+	if err := entry.Validate(); err != nil {
+		return 0, err
+	}
+	for _, p := range entry.Postings {
+		if !t.state.accounts[p.Account] {
+			return 0, models.ErrUnknownAccount
+		}
+	}
+	id := len(t.state.entries) + 1
+	t.state.entries = append(t.state.entries, memoryEntry{
+		id:          id,
+		kind:        entry.Kind,
+		description: entry.Description,
+		postings:    append([]models.Posting(nil), entry.Postings...),
+		createdAt:   t.now(),
+	})
+	return id, nil
+}
+
+// RecordTransactions implements WalletTx
+func (t *memoryTx) RecordTransactions(_ context.Context, txs ...models.Transaction) error {
+	for _, r := range txs {
+		r.ID = len(t.state.transactions) + 1
+		r.CreatedAt = t.now()
+		t.state.transactions = append(t.state.transactions, r)
+	}
+	return nil
+}
+
+// RecordAdjustment implements WalletTx
+func (t *memoryTx) RecordAdjustment(_ context.Context, a *models.Adjustment) error {
+	if _, ok := t.state.users[a.AdminID]; !ok {
+		return models.ErrUserNotFound
+	}
+	a.ID = len(t.state.adjustments) + 1
+	a.CreatedAt = t.now()
+	t.state.adjustments = append(t.state.adjustments, *a)
+	return nil
+}
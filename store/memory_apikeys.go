@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"sort"
+	"time"
+)
+
+// memoryAPIKeys API key state of Memory, guarded by Memory.mu
+type memoryAPIKeys struct {
+	byPrefix map[string]*models.APIKey
+	nextID   int
+}
+
+// copyAPIKey copies k including its scopes and times
+func copyAPIKey(k *models.APIKey) models.APIKey {
+	cp := *k
+	cp.Scopes = append([]string(nil), k.Scopes...)
+	if k.LastUsedAt != nil {
+		t := *k.LastUsedAt
+		cp.LastUsedAt = &t
+	}
+	if k.RevokedAt != nil {
+		t := *k.RevokedAt
+		cp.RevokedAt = &t
+	}
+	return cp
+}
+
+// CreateAPIKey implements APIKeyStore
+func (m *Memory) CreateAPIKey(_ context.Context, k *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.keys.byPrefix[k.Prefix]; ok {
+		return models.ErrConflict
+	}
+	m.keys.nextID++
+	k.ID = m.keys.nextID
+	k.CreatedAt = m.now()
+	cp := copyAPIKey(k)
+	m.keys.byPrefix[k.Prefix] = &cp
+	return nil
+}
+
+// APIKeyByPrefix implements APIKeyStore
+func (m *Memory) APIKeyByPrefix(_ context.Context, prefix string) (*models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.keys.byPrefix[prefix]
+	if !ok {
+		return nil, models.ErrInvalidAPIKey
+	}
+	cp := copyAPIKey(k)
+	return &cp, nil
+}
+
+// APIKeys implements APIKeyStore
+func (m *Memory) APIKeys(_ context.Context, userID int) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := []models.APIKey{}
+	for _, k := range m.keys.byPrefix {
+		if k.UserID == userID {
+			keys = append(keys, copyAPIKey(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+// RevokeAPIKey implements APIKeyStore
+func (m *Memory) RevokeAPIKey(_ context.Context, userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.keys.byPrefix {
+		if k.ID == id && k.UserID == userID {
+			if k.RevokedAt == nil {
+				now := m.now()
+				k.RevokedAt = &now
+			}
+			return nil
+		}
+	}
+	return models.ErrAPIKeyNotFound
+}
+
+// TouchAPIKey implements APIKeyStore
+func (m *Memory) TouchAPIKey(_ context.Context, id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.keys.byPrefix {
+		if k.ID == id {
+			k.LastUsedAt = &at
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	_, err := m.APIKeyByPrefix(ctx, "abc")
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+
+	first := &models.APIKey{UserID: 1, Name: "shop", Prefix: "abc", KeyHash: "h1", Scopes: []string{models.ScopeWalletRead}}
+	require.NoError(t, m.CreateAPIKey(ctx, first))
+	assert.Equal(t, 1, first.ID)
+	assert.Equal(t, now, first.CreatedAt)
+	second := &models.APIKey{UserID: 1, Name: "billing", Prefix: "def", KeyHash: "h2", Scopes: []string{models.ScopeWalletDeposit}}
+	require.NoError(t, m.CreateAPIKey(ctx, second))
+	assert.ErrorIs(t, m.CreateAPIKey(ctx, &models.APIKey{UserID: 2, Prefix: "abc"}), models.ErrConflict)
+
+	// callers cannot change the stored key through their copy
+	first.Scopes[0] = models.ScopeWalletTransfer
+	k, err := m.APIKeyByPrefix(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.ScopeWalletRead}, k.Scopes)
+	assert.Nil(t, k.LastUsedAt)
+
+	used := now.Add(time.Minute)
+	require.NoError(t, m.TouchAPIKey(ctx, k.ID, used))
+	k, _ = m.APIKeyByPrefix(ctx, "abc")
+	assert.Equal(t, &used, k.LastUsedAt)
+
+	assert.ErrorIs(t, m.RevokeAPIKey(ctx, 2, k.ID), models.ErrAPIKeyNotFound)
+	require.NoError(t, m.RevokeAPIKey(ctx, 1, k.ID))
+	now = now.Add(time.Hour)
+	require.NoError(t, m.RevokeAPIKey(ctx, 1, k.ID))
+	k, _ = m.APIKeyByPrefix(ctx, "abc")
+	require.NotNil(t, k.RevokedAt)
+	assert.Equal(t, now.Add(-time.Hour), *k.RevokedAt)
+
+	keys, err := m.APIKeys(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "billing", keys[0].Name)
+	assert.Equal(t, "shop", keys[1].Name)
+	keys, err = m.APIKeys(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.NotNil(t, keys)
+}
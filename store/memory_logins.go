@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"time"
+)
+
+// memoryLogins login state of Memory, guarded by Memory.mu
+type memoryLogins struct {
+	failures map[string]models.LoginFailures
+	attempts []models.LoginAttempt
+}
+
+// LoginFailures implements LoginStore
+func (m *Memory) LoginFailures(_ context.Context, key string) (*models.LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.logins.failures[key]
+	return &f, nil
+}
+
+// AddLoginFailure implements LoginStore
+func (m *Memory) AddLoginFailure(_ context.Context, key string, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.logins.failures[key]
+	if f.LastFailedAt.Before(since) {
+		f.Failures = 0
+	}
+	f.Failures++
+	f.LastFailedAt = m.now()
+	m.logins.failures[key] = f
+	return f.Failures, nil
+}
+
+// LockLogin implements LoginStore
+func (m *Memory) LockLogin(_ context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.logins.failures[key]
+	f.LockedUntil = until
+	m.logins.failures[key] = f
+	return nil
+}
+
+// ResetLoginFailures implements LoginStore
+func (m *Memory) ResetLoginFailures(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.logins.failures, key)
+	return nil
+}
+
+// RecordLoginAttempt implements LoginStore
+func (m *Memory) RecordLoginAttempt(_ context.Context, a *models.LoginAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a.ID = len(m.logins.attempts) + 1
+	a.CreatedAt = m.now()
+	m.logins.attempts = append(m.logins.attempts, *a)
+	return nil
+}
+
+// LoginAttempts implements LoginStore
+func (m *Memory) LoginAttempts(_ context.Context, userID, limit int) ([]models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := []models.LoginAttempt{}
+	for i := len(m.logins.attempts) - 1; i >= 0 && len(attempts) < limit; i-- {
+		if m.logins.attempts[i].UserID == userID {
+			attempts = append(attempts, m.logins.attempts[i])
+		}
+	}
+	return attempts, nil
+}
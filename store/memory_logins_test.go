@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory_AddLoginFailure(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	for want := 1; want <= 3; want++ {
+		n, err := m.AddLoginFailure(ctx, "user:1", now.Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, want, n)
+	}
+	assert.NoError(t, m.LockLogin(ctx, "user:1", now.Add(time.Minute)))
+
+	f, _ := m.LoginFailures(ctx, "user:1")
+	assert.Equal(t, 3, f.Failures)
+	assert.Equal(t, now.Add(time.Minute), f.LockedUntil)
+
+	// failures before since are forgotten
+	n, _ := m.AddLoginFailure(ctx, "user:1", now.Add(time.Second))
+	assert.Equal(t, 1, n)
+
+	assert.NoError(t, m.ResetLoginFailures(ctx, "user:1"))
+	f, _ = m.LoginFailures(ctx, "user:1")
+	assert.Zero(t, *f)
+	f, _ = m.LoginFailures(ctx, "ip:10.0.0.1")
+	assert.Zero(t, *f)
+}
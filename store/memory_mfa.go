@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+)
+
+// memoryMFA two-factor state of a user in Memory, recovery maps code hashes
+// to whether they were used
+type memoryMFA struct {
+	mfa      models.MFA
+	recovery map[string]bool
+}
+
+// MFA implements MFAStore
+func (m *Memory) MFA(_ context.Context, userID int) (*models.MFA, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa := m.mfa[userID].mfa
+	mfa.UserID = userID
+	return &mfa, nil
+}
+
+// StartMFA implements MFAStore
+func (m *Memory) StartMFA(_ context.Context, userID int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mfa[userID].mfa.Enabled() {
+		return models.ErrMFAAlreadyEnabled
+	}
+	m.mfa[userID] = memoryMFA{mfa: models.MFA{UserID: userID, Secret: secret}}
+	return nil
+}
+
+// EnableMFA implements MFAStore
+func (m *Memory) EnableMFA(_ context.Context, userID int, counter int64, recoveryHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.mfa[userID]
+	if !ok || state.mfa.EnabledAt != nil {
+		return models.ErrMFANotEnrolled
+	}
+	now := m.now()
+	state.mfa.EnabledAt = &now
+	state.mfa.LastCounter = counter
+	state.recovery = make(map[string]bool, len(recoveryHashes))
+	for _, h := range recoveryHashes {
+		state.recovery[h] = false
+	}
+	m.mfa[userID] = state
+	return nil
+}
+
+// DisableMFA implements MFAStore
+func (m *Memory) DisableMFA(_ context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mfa, userID)
+	return nil
+}
+
+// UseTOTPCounter implements MFAStore
+func (m *Memory) UseTOTPCounter(_ context.Context, userID int, counter int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.mfa[userID]
+	if !ok || state.mfa.LastCounter >= counter {
+		return false, nil
+	}
+	state.mfa.LastCounter = counter
+	m.mfa[userID] = state
+	return true, nil
+}
+
+// UseRecoveryCode implements MFAStore
+func (m *Memory) UseRecoveryCode(_ context.Context, userID int, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.mfa[userID].recovery[hash]
+	if !ok || used {
+		return false, nil
+	}
+	m.mfa[userID].recovery[hash] = true
+	return true, nil
+}
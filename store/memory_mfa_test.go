@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_MFA(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	mfa, err := m.MFA(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, &models.MFA{UserID: 1}, mfa)
+	assert.ErrorIs(t, m.EnableMFA(ctx, 1, 10, nil), models.ErrMFANotEnrolled)
+
+	require.NoError(t, m.StartMFA(ctx, 1, "old"))
+	require.NoError(t, m.StartMFA(ctx, 1, "secret"))
+	mfa, _ = m.MFA(ctx, 1)
+	assert.Equal(t, "secret", mfa.Secret)
+	assert.False(t, mfa.Enabled())
+
+	require.NoError(t, m.EnableMFA(ctx, 1, 10, []string{"h1", "h2"}))
+	mfa, _ = m.MFA(ctx, 1)
+	assert.True(t, mfa.Enabled())
+	assert.Equal(t, int64(10), mfa.LastCounter)
+	assert.ErrorIs(t, m.StartMFA(ctx, 1, "other"), models.ErrMFAAlreadyEnabled)
+	assert.ErrorIs(t, m.EnableMFA(ctx, 1, 11, nil), models.ErrMFANotEnrolled)
+
+	// time steps are used once and in order
+	ok, _ := m.UseTOTPCounter(ctx, 1, 10)
+	assert.False(t, ok)
+	ok, _ = m.UseTOTPCounter(ctx, 1, 11)
+	assert.True(t, ok)
+	ok, _ = m.UseTOTPCounter(ctx, 2, 11)
+	assert.False(t, ok)
+
+	ok, _ = m.UseRecoveryCode(ctx, 1, "h1")
+	assert.True(t, ok)
+	ok, _ = m.UseRecoveryCode(ctx, 1, "h1")
+	assert.False(t, ok)
+	ok, _ = m.UseRecoveryCode(ctx, 1, "h3")
+	assert.False(t, ok)
+	ok, _ = m.UseRecoveryCode(ctx, 2, "h2")
+	assert.False(t, ok)
+
+	require.NoError(t, m.DisableMFA(ctx, 1))
+	mfa, _ = m.MFA(ctx, 1)
+	assert.Equal(t, &models.MFA{UserID: 1}, mfa)
+	ok, _ = m.UseRecoveryCode(ctx, 1, "h2")
+	assert.False(t, ok)
+}
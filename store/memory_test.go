@@ -0,0 +1,356 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_UserByName(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	first, err := m.CreateUser(ctx, "alice", "hash1")
+	assert.NoError(t, err)
+	_, err = m.CreateUser(ctx, "Alice", "hash2")
+	assert.ErrorIs(t, err, models.ErrNameTaken)
+
+	u, err := m.UserByName(ctx, "ALICE")
+	assert.NoError(t, err)
+	assert.Equal(t, first, u.ID)
+	assert.Equal(t, "alice", u.Name)
+	assert.Equal(t, "hash1", u.PasswordHash)
+
+	_, err = m.UserByName(ctx, "bob")
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+}
+
+func TestMemory_UpdateUser(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	id, err := m.CreateUser(ctx, "alice", "hash1")
+	assert.NoError(t, err)
+	assert.NoError(t, m.UpdatePassword(ctx, id, "hash2"))
+
+	u, err := m.UserByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "hash2", u.PasswordHash)
+
+	assert.ErrorIs(t, m.UpdatePassword(ctx, id+1, "hash2"), models.ErrUserNotFound)
+
+	assert.NoError(t, m.UpdateProfile(ctx, id, models.Profile{DisplayName: "Alice"}))
+	u, _ = m.UserByID(ctx, id)
+	assert.Equal(t, "Alice", u.DisplayName)
+	assert.Equal(t, "hash2", u.PasswordHash)
+}
+
+func TestMemory_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	alice, _ := m.CreateUser(ctx, "alice", "hash")
+	bob, _ := m.CreateUser(ctx, "bob", "hash")
+	_, _ = m.CreateUser(ctx, "carol", "hash")
+	assert.NoError(t, m.UpdateProfile(ctx, bob, models.Profile{Email: "bob@alice.example"}))
+
+	u, _ := m.UserByID(ctx, alice)
+	assert.Equal(t, models.RoleUser, u.Role)
+	assert.NoError(t, m.UpdateRole(ctx, alice, models.RoleSupport))
+	u, _ = m.UserByID(ctx, alice)
+	assert.Equal(t, models.RoleSupport, u.Role)
+
+	users, err := m.SearchUsers(ctx, "ALICE", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, alice, users[0].ID)
+		assert.Equal(t, bob, users[1].ID)
+	}
+
+	users, _ = m.SearchUsers(ctx, "", 2)
+	assert.Len(t, users, 2)
+	users, _ = m.SearchUsers(ctx, "dave", 10)
+	assert.NotNil(t, users)
+	assert.Empty(t, users)
+}
+
+func TestMemory_Adjustments(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	admin, _ := m.CreateUser(ctx, "admin", "hash")
+	alice, _ := m.CreateUser(ctx, "alice", "hash")
+	bob, _ := m.CreateUser(ctx, "bob", "hash")
+
+	for _, a := range []models.Adjustment{
+		{UserID: alice, AdminID: admin, Amount: models.MustParseMoney("10"), Reason: "goodwill"},
+		{UserID: bob, AdminID: admin, Amount: models.MustParseMoney("-1"), Reason: "fee"},
+		{UserID: alice, AdminID: admin, Amount: models.MustParseMoney("2"), Reason: "refund"},
+	} {
+		assert.NoError(t, m.WithinTx(ctx, func(tx WalletTx) error { return tx.RecordAdjustment(ctx, &a) }))
+	}
+	err := m.WithinTx(ctx, func(tx WalletTx) error {
+		return tx.RecordAdjustment(ctx, &models.Adjustment{UserID: alice, AdminID: 99, Amount: models.MustParseMoney("1")})
+	})
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	adjustments, err := m.Adjustments(ctx, alice, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, adjustments, 2) {
+		assert.Equal(t, "refund", adjustments[0].Reason)
+		assert.Equal(t, "goodwill", adjustments[1].Reason)
+	}
+	adjustments, _ = m.Adjustments(ctx, 0, 2)
+	if assert.Len(t, adjustments, 2) {
+		assert.Equal(t, 3, adjustments[0].ID)
+		assert.Equal(t, bob, adjustments[1].UserID)
+	}
+}
+
+func TestMemory_WithinTx(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	id, _ := m.CreateUser(ctx, "alice", "hash")
+	amount := models.MustParseMoney("25.50")
+
+	deposit := func(tx WalletTx) error {
+		if _, err := tx.AdjustBalance(ctx, id, amount); err != nil {
+			return err
+		}
+		entryID, err := tx.PostEntry(ctx, models.JournalEntry{Kind: models.TxTypeDeposit, Postings: []models.Posting{
+			{Account: models.CashAccount, Amount: amount.Neg()},
+			{Account: models.UserAccount(id), Amount: amount},
+		}})
+		if err != nil {
+			return err
+		}
+		return tx.RecordTransactions(ctx, models.Transaction{UserID: id, Type: models.TxTypeDeposit, Amount: amount, EntryID: entryID})
+	}
+
+	t.Run("failed unit of work is discarded", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		err := m.WithinTx(ctx, func(tx WalletTx) error {
+			if err := deposit(tx); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		assert.ErrorIs(t, err, errBoom)
+
+		balance, _ := m.Balance(ctx, id)
+		assert.True(t, balance.IsZero())
+		transactions, _ := m.Transactions(ctx, id, models.TransactionQuery{})
+		assert.Empty(t, transactions)
+	})
+
+	t.Run("committed unit of work is visible", func(t *testing.T) {
+		assert.NoError(t, m.WithinTx(ctx, deposit))
+
+		balance, _ := m.Balance(ctx, id)
+		assert.Equal(t, "25.50", balance.String())
+		transactions, _ := m.Transactions(ctx, id, models.TransactionQuery{})
+		assert.Len(t, transactions, 1)
+
+		report, err := m.VerifyLedger(ctx)
+		assert.NoError(t, err)
+		assert.True(t, report.Balanced())
+	})
+
+	t.Run("balance cannot go negative", func(t *testing.T) {
+		err := m.WithinTx(ctx, func(tx WalletTx) error {
+			_, err := tx.AdjustBalance(ctx, id, models.MustParseMoney("-25.51"))
+			return err
+		})
+		assert.ErrorIs(t, err, models.ErrInsufficientFunds)
+	})
+
+	t.Run("unknown account and unbalanced entry", func(t *testing.T) {
+		err := m.WithinTx(ctx, func(tx WalletTx) error {
+			_, err := tx.PostEntry(ctx, models.JournalEntry{Postings: []models.Posting{
+				{Account: models.CashAccount, Amount: amount.Neg()},
+				{Account: models.UserAccount(99), Amount: amount},
+			}})
+			return err
+		})
+		assert.ErrorIs(t, err, models.ErrUnknownAccount)
+
+		err = m.WithinTx(ctx, func(tx WalletTx) error {
+			_, err := tx.PostEntry(ctx, models.JournalEntry{Postings: []models.Posting{
+				{Account: models.CashAccount, Amount: amount.Neg()},
+				{Account: models.UserAccount(id), Amount: models.MustParseMoney("1")},
+			}})
+			return err
+		})
+		assert.ErrorIs(t, err, models.ErrUnbalancedEntry)
+	})
+}
+
+func TestMemory_TransactionHistory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	// every row at the same time, so pages rely on the id tie-break
+	at := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return at }
+	alice, _ := m.CreateUser(ctx, "alice", "hash")
+	bob, _ := m.CreateUser(ctx, "bob", "hash")
+
+	post := func(kind string, amount models.Money, from, to string, txs ...models.Transaction) {
+		t.Helper()
+		require.NoError(t, m.WithinTx(ctx, func(tx WalletTx) error {
+			entryID, err := tx.PostEntry(ctx, models.JournalEntry{Kind: kind, Postings: []models.Posting{
+				{Account: from, Amount: amount.Neg()},
+				{Account: to, Amount: amount},
+			}})
+			if err != nil {
+				return err
+			}
+			for i := range txs {
+				txs[i].EntryID = entryID
+			}
+			return tx.RecordTransactions(ctx, txs...)
+		}))
+	}
+	for _, a := range []string{"10", "20", "30"} {
+		amount := models.MustParseMoney(a)
+		post(models.TxTypeDeposit, amount, models.CashAccount, models.UserAccount(alice),
+			models.Transaction{UserID: alice, Type: models.TxTypeDeposit, Amount: amount})
+	}
+	five := models.MustParseMoney("5")
+	post(models.TxTypeTransfer, five, models.UserAccount(alice), models.UserAccount(bob),
+		models.Transaction{UserID: alice, Type: models.TxTypeTransfer, Amount: five},
+		models.Transaction{UserID: bob, Type: models.TxTypeTransferIn, Amount: five})
+
+	ids := func(txs []models.Transaction) []int {
+		out := []int{}
+		for _, t := range txs {
+			out = append(out, t.ID)
+		}
+		return out
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		page, err := m.Transactions(ctx, alice, models.TransactionQuery{Limit: 2})
+		require.NoError(t, err)
+		assert.Equal(t, []int{4, 3}, ids(page))
+		page, _ = m.Transactions(ctx, alice, models.TransactionQuery{Limit: 2, After: &models.TransactionCursor{CreatedAt: at, ID: 3}})
+		assert.Equal(t, []int{2, 1}, ids(page))
+		page, _ = m.Transactions(ctx, alice, models.TransactionQuery{Ascending: true, After: &models.TransactionCursor{CreatedAt: at, ID: 2}})
+		assert.Equal(t, []int{3, 4}, ids(page))
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		min, max := models.MustParseMoney("10"), models.MustParseMoney("20")
+		f := models.TransactionFilter{Types: []string{models.TxTypeDeposit}, MinAmount: &min, MaxAmount: &max}
+		page, _ := m.Transactions(ctx, alice, models.TransactionQuery{TransactionFilter: f})
+		assert.Equal(t, []int{2, 1}, ids(page))
+		n, err := m.CountTransactions(ctx, alice, f)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		later := at.Add(time.Second)
+		page, _ = m.Transactions(ctx, alice, models.TransactionQuery{TransactionFilter: models.TransactionFilter{From: &later}})
+		assert.NotNil(t, page)
+		assert.Empty(t, page)
+	})
+
+	t.Run("detail", func(t *testing.T) {
+		detail, err := m.Transaction(ctx, alice, 4)
+		require.NoError(t, err)
+		assert.Equal(t, &models.Counterparty{Account: models.UserAccount(bob), UserID: bob, Name: "bob"}, detail.Counterparty)
+		assert.Equal(t, "55.00", detail.BalanceAfter.String())
+
+		detail, err = m.Transaction(ctx, alice, 2)
+		require.NoError(t, err)
+		assert.Equal(t, &models.Counterparty{Account: models.CashAccount}, detail.Counterparty)
+		assert.Equal(t, "30.00", detail.BalanceAfter.String())
+
+		detail, err = m.Transaction(ctx, bob, 5)
+		require.NoError(t, err)
+		assert.Equal(t, alice, detail.Counterparty.UserID)
+		assert.Equal(t, "5.00", detail.BalanceAfter.String())
+
+		_, err = m.Transaction(ctx, bob, 4)
+		assert.ErrorIs(t, err, models.ErrTransactionNotFound)
+	})
+}
+
+func TestMemory_Postings(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	alice, _ := m.CreateUser(ctx, "alice", "hash")
+	bob, _ := m.CreateUser(ctx, "bob", "hash")
+
+	post := func(kind string, from, to string, amount string) {
+		require.NoError(t, m.WithinTx(ctx, func(tx WalletTx) error {
+			_, err := tx.PostEntry(ctx, models.JournalEntry{Kind: kind, Description: kind, Postings: []models.Posting{
+				{Account: from, Amount: models.MustParseMoney(amount).Neg()},
+				{Account: to, Amount: models.MustParseMoney(amount)},
+			}})
+			return err
+		}))
+	}
+	post(models.TxTypeDeposit, models.CashAccount, models.UserAccount(alice), "10")
+	post(models.TxTypeTransfer, models.UserAccount(alice), models.UserAccount(bob), "4")
+	post(models.TxTypeDeposit, models.CashAccount, models.UserAccount(bob), "1")
+
+	postings, err := m.Postings(ctx, alice, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, postings, 2) {
+		assert.Equal(t, 3, postings[0].ID)
+		assert.Equal(t, 2, postings[0].EntryID)
+		assert.Equal(t, models.TxTypeTransfer, postings[0].Kind)
+		assert.Equal(t, "-4.00", postings[0].Amount.String())
+		assert.Equal(t, "10.00", postings[1].Amount.String())
+	}
+	postings, _ = m.Postings(ctx, bob, 1)
+	if assert.Len(t, postings, 1) {
+		assert.Equal(t, 3, postings[0].EntryID)
+	}
+
+	_, err = m.Postings(ctx, 99, 10)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+}
+
+func TestMemory_VerifyHistory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	alice, _ := m.CreateUser(ctx, "alice", "hash")
+	bob, _ := m.CreateUser(ctx, "bob", "hash")
+
+	deposit := func(userID int, amount string) error {
+		return m.WithinTx(ctx, func(tx WalletTx) error {
+			balance, err := tx.AdjustBalance(ctx, userID, models.MustParseMoney(amount))
+			if err != nil {
+				return err
+			}
+			return tx.RecordTransactions(ctx, models.Transaction{
+				UserID: userID, Type: models.TxTypeDeposit, Amount: models.MustParseMoney(amount),
+			}.WithBalance(balance))
+		})
+	}
+	require.NoError(t, deposit(alice, "10"))
+	require.NoError(t, deposit(alice, "15"))
+	require.NoError(t, deposit(bob, "5"))
+
+	report, err := m.VerifyHistory(ctx)
+	assert.NoError(t, err)
+	assert.True(t, report.Consistent())
+	assert.Empty(t, report.Unverified)
+
+	require.NoError(t, m.WithinTx(ctx, func(tx WalletTx) error {
+		_, err := tx.AdjustBalance(ctx, bob, models.MustParseMoney("-5"))
+		return err
+	}))
+	report, err = m.VerifyHistory(ctx)
+	assert.NoError(t, err)
+	if mismatches := report.Mismatches; assert.Len(t, mismatches, 1) {
+		assert.Equal(t, bob, mismatches[0].UserID)
+		assert.Equal(t, "0.00", mismatches[0].Balance.String())
+		assert.Equal(t, "5.00", mismatches[0].ReplayedBalance.String())
+		assert.Zero(t, mismatches[0].DriftedAt)
+	}
+}
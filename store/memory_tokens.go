@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"time"
+)
+
+// memoryTokens token state of Memory, guarded by Memory.mu
+type memoryTokens struct {
+	refresh map[string]*models.RefreshToken
+	revoked map[string]time.Time
+	nextID  int
+}
+
+// CreateRefreshToken implements TokenStore
+func (m *Memory) CreateRefreshToken(_ context.Context, t *models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokens.nextID++
+	t.ID = m.tokens.nextID
+	t.CreatedAt = m.now()
+	cp := *t
+	m.tokens.refresh[t.TokenHash] = &cp
+	return nil
+}
+
+// RefreshTokenByHash implements TokenStore
+func (m *Memory) RefreshTokenByHash(_ context.Context, hash string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens.refresh[hash]
+	if !ok {
+		return nil, models.ErrInvalidToken
+	}
+	cp := *t
+	return &cp, nil
+}
+
+// MarkRefreshTokenUsed implements TokenStore
+func (m *Memory) MarkRefreshTokenUsed(_ context.Context, id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens.refresh {
+		if t.ID == id {
+			if t.UsedAt != nil || t.RevokedAt != nil {
+				return false, nil
+			}
+			now := m.now()
+			t.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RevokeFamily implements TokenStore
+func (m *Memory) RevokeFamily(_ context.Context, familyID string) error {
+	m.revokeRefreshTokens(func(t *models.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+// RevokeUserTokens implements TokenStore
+func (m *Memory) RevokeUserTokens(_ context.Context, userID int) error {
+	m.revokeRefreshTokens(func(t *models.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (m *Memory) revokeRefreshTokens(match func(t *models.RefreshToken) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for _, t := range m.tokens.refresh {
+		if t.RevokedAt != nil || !match(t) {
+			continue
+		}
+		t.RevokedAt = &now
+		if t.AccessExpiresAt.After(now) {
+			m.tokens.revoked[t.AccessTokenID] = t.AccessExpiresAt
+		}
+	}
+}
+
+// RevokeAccessToken implements TokenStore
+func (m *Memory) RevokeAccessToken(_ context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for id, exp := range m.tokens.revoked {
+		if !exp.After(now) {
+			delete(m.tokens.revoked, id)
+		}
+	}
+	m.tokens.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsAccessTokenRevoked implements TokenStore
+func (m *Memory) IsAccessTokenRevoked(_ context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.tokens.revoked[jti]
+	return ok, nil
+}
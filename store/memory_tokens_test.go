@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory_RevokeFamily(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	now := time.Now()
+
+	live := &models.RefreshToken{TokenHash: "a", FamilyID: "fam", UserID: 1, AccessTokenID: "live", AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+	stale := &models.RefreshToken{TokenHash: "b", FamilyID: "fam", UserID: 1, AccessTokenID: "stale", AccessExpiresAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)}
+	other := &models.RefreshToken{TokenHash: "c", FamilyID: "other", UserID: 1, AccessTokenID: "other", AccessExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+	for _, rt := range []*models.RefreshToken{live, stale, other} {
+		assert.NoError(t, m.CreateRefreshToken(ctx, rt))
+	}
+
+	ok, err := m.MarkRefreshTokenUsed(ctx, live.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = m.MarkRefreshTokenUsed(ctx, live.ID)
+	assert.False(t, ok)
+
+	assert.NoError(t, m.RevokeFamily(ctx, "fam"))
+
+	got, _ := m.RefreshTokenByHash(ctx, "a")
+	assert.NotNil(t, got.RevokedAt)
+	got, _ = m.RefreshTokenByHash(ctx, "c")
+	assert.Nil(t, got.RevokedAt)
+
+	// only access tokens that have not expired need to be denylisted
+	revoked, _ := m.IsAccessTokenRevoked(ctx, "live")
+	assert.True(t, revoked)
+	revoked, _ = m.IsAccessTokenRevoked(ctx, "stale")
+	assert.False(t, revoked)
+	revoked, _ = m.IsAccessTokenRevoked(ctx, "other")
+	assert.False(t, revoked)
+}
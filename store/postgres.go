@@ -0,0 +1,516 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gin-wallet2/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Postgres UserStore, WalletStore, TokenStore and LoginStore backed by PostgreSQL
+type Postgres struct {
+	DB *sql.DB
+}
+
+// NewPostgres new postgres store
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{DB: db}
+}
+
+// CreateUser implements UserStore
+func (s *Postgres) CreateUser(ctx context.Context, name, passwordHash string) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+
+	// users_name_lower_key turns a taken name into no row
+	var userID int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO users (name, password_hash) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id", name, passwordHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		_ = tx.Rollback()
+		return 0, models.ErrNameTaken
+	} else if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("insert user: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO accounts (code, user_id) VALUES ($1, $2)", models.UserAccount(userID), userID)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("insert account: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return userID, nil
+}
+
+// selectUser columns read by scanUser
+const selectUser = "SELECT id, name, password_hash, role, display_name, email, phone, created_at FROM users"
+
+// rowScanner *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var u models.User
+	var createdAt sql.NullTime
+	err := row.Scan(&u.ID, &u.Name, &u.PasswordHash, &u.Role, &u.DisplayName, &u.Email, &u.Phone, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = createdAt.Time
+	return &u, nil
+}
+
+// UserByName implements UserStore, served by users_name_lower_key
+func (s *Postgres) UserByName(ctx context.Context, name string) (*models.User, error) {
+	return scanUser(s.DB.QueryRowContext(ctx, selectUser+" WHERE lower(name) = lower($1)", name))
+}
+
+// UserByID implements UserStore
+func (s *Postgres) UserByID(ctx context.Context, id int) (*models.User, error) {
+	return scanUser(s.DB.QueryRowContext(ctx, selectUser+" WHERE id = $1", id))
+}
+
+// UpdatePassword implements UserStore
+func (s *Postgres) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	return updateUser(s.DB.ExecContext(ctx, "UPDATE users SET password_hash = $2 WHERE id = $1", id, passwordHash))
+}
+
+// UpdateProfile implements UserStore
+func (s *Postgres) UpdateProfile(ctx context.Context, id int, p models.Profile) error {
+	return updateUser(s.DB.ExecContext(ctx,
+		"UPDATE users SET display_name = $2, email = $3, phone = $4 WHERE id = $1", id, p.DisplayName, p.Email, p.Phone))
+}
+
+// UpdateRole implements UserStore
+func (s *Postgres) UpdateRole(ctx context.Context, id int, role string) error {
+	return updateUser(s.DB.ExecContext(ctx, "UPDATE users SET role = $2 WHERE id = $1", id, role))
+}
+
+// SearchUsers implements UserStore. The pattern escapes LIKE wildcards so
+// query matches literally.
+func (s *Postgres) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := s.DB.QueryContext(ctx, selectUser+
+		" WHERE name ILIKE $1 OR display_name ILIKE $1 OR email ILIKE $1 ORDER BY id LIMIT $2", pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// likeEscaper escapes the LIKE wildcards and the escape character itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// updateUser maps an update of no rows to models.ErrUserNotFound
+func updateUser(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrUserNotFound
+	}
+	return nil
+}
+
+// WithinTx implements WalletStore
+func (s *Postgres) WithinTx(ctx context.Context, fn func(tx WalletTx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+
+	if err := fn(&postgresTx{tx: tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// Balance implements WalletStore
+func (s *Postgres) Balance(ctx context.Context, userID int) (models.Money, error) {
+	var balance models.Money
+	err := s.DB.QueryRowContext(ctx, "SELECT balance FROM users WHERE id = $1", userID).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return balance, models.ErrUserNotFound
+	}
+	return balance, err
+}
+
+// queryArgs positional arguments of a query being built
+type queryArgs []any
+
+// add appends v and returns its placeholder
+func (a *queryArgs) add(v any) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// transactionFilter WHERE clause selecting the user's transactions matching f
+func transactionFilter(userID int, f models.TransactionFilter, args *queryArgs) string {
+	where := []string{"user_id = " + args.add(userID)}
+	if len(f.Types) > 0 {
+		placeholders := make([]string, len(f.Types))
+		for i, t := range f.Types {
+			placeholders[i] = args.add(t)
+		}
+		where = append(where, "type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if f.MinAmount != nil {
+		where = append(where, "amount >= "+args.add(*f.MinAmount))
+	}
+	if f.MaxAmount != nil {
+		where = append(where, "amount <= "+args.add(*f.MaxAmount))
+	}
+	if f.From != nil {
+		where = append(where, "created_at >= "+args.add(*f.From))
+	}
+	if f.To != nil {
+		where = append(where, "created_at < "+args.add(*f.To))
+	}
+	return strings.Join(where, " AND ")
+}
+
+// Transactions implements WalletStore, the cursor compares the row value
+// (created_at, id) so transactions_user_id_created_at_id_idx serves every page
+func (s *Postgres) Transactions(ctx context.Context, userID int, q models.TransactionQuery) ([]models.Transaction, error) {
+	var args queryArgs
+	where := transactionFilter(userID, q.TransactionFilter, &args)
+	order, after := "DESC", "<"
+	if q.Ascending {
+		order, after = "ASC", ">"
+	}
+	if q.After != nil {
+		where += fmt.Sprintf(" AND (created_at, id) %s (%s, %s)", after, args.add(q.After.CreatedAt), args.add(q.After.ID))
+	}
+	query := "SELECT id, type, amount, description, balance_before, balance_after, created_at FROM transactions WHERE " + where +
+		" ORDER BY created_at " + order + ", id " + order
+	if q.Limit > 0 {
+		query += " LIMIT " + args.add(q.Limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []models.Transaction{}
+	for rows.Next() {
+		t := models.Transaction{UserID: userID}
+		if err := rows.Scan(&t.ID, &t.Type, &t.Amount, &t.Description, &t.BalanceBefore, &t.BalanceAfter, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+// CountTransactions implements WalletStore
+func (s *Postgres) CountTransactions(ctx context.Context, userID int, f models.TransactionFilter) (int, error) {
+	var args queryArgs
+	where := transactionFilter(userID, f, &args)
+	var n int
+	err := s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM transactions WHERE "+where, args...).Scan(&n)
+	return n, err
+}
+
+// Transaction implements WalletStore. The counterparty is the first other
+// account posted to by the transaction's journal entry. Rows without a
+// recorded balance_after get the sum of the wallet's postings up to that
+// entry; entries of one wallet are numbered in the order they committed since
+// each holds its users row lock.
+func (s *Postgres) Transaction(ctx context.Context, userID, id int) (*models.TransactionDetail, error) {
+	d := models.TransactionDetail{Transaction: models.Transaction{ID: id, UserID: userID}}
+	var entryID, otherUserID sql.NullInt64
+	var account, name sql.NullString
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT t.type, t.amount, t.description, t.created_at, t.entry_id, other.code, other.user_id, u.name,
+			t.balance_before, COALESCE(t.balance_after, (SELECT SUM(p.amount) FROM postings p JOIN accounts a ON a.id = p.account_id
+			 WHERE a.user_id = t.user_id AND p.entry_id <= t.entry_id))
+		FROM transactions t
+		LEFT JOIN LATERAL (
+			SELECT a.code, a.user_id FROM postings p JOIN accounts a ON a.id = p.account_id
+			WHERE p.entry_id = t.entry_id AND a.user_id IS DISTINCT FROM t.user_id
+			ORDER BY p.id LIMIT 1
+		) other ON true
+		LEFT JOIN users u ON u.id = other.user_id
+		WHERE t.id = $1 AND t.user_id = $2`, id, userID).
+		Scan(&d.Type, &d.Amount, &d.Description, &d.CreatedAt, &entryID, &account, &otherUserID, &name, &d.BalanceBefore, &d.BalanceAfter)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrTransactionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	d.EntryID = int(entryID.Int64)
+	if account.Valid {
+		d.Counterparty = &models.Counterparty{Account: account.String, UserID: int(otherUserID.Int64), Name: name.String}
+	}
+	return &d, nil
+}
+
+// VerifyLedger implements WalletStore
+func (s *Postgres) VerifyLedger(ctx context.Context) (*models.LedgerReport, error) {
+	report := &models.LedgerReport{
+		UnbalancedEntries:  []int{},
+		MismatchedBalances: []models.BalanceMismatch{},
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT entry_id FROM postings GROUP BY entry_id HAVING SUM(amount) <> 0 ORDER BY entry_id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var entryID int
+		if err := rows.Scan(&entryID); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		report.UnbalancedEntries = append(report.UnbalancedEntries, entryID)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.DB.QueryContext(ctx, `SELECT u.id, u.balance, COALESCE(SUM(p.amount), 0)
+		FROM users u
+		JOIN accounts a ON a.user_id = u.id
+		LEFT JOIN postings p ON p.account_id = a.id
+		GROUP BY u.id, u.balance
+		HAVING u.balance <> COALESCE(SUM(p.amount), 0)
+		ORDER BY u.id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m models.BalanceMismatch
+		if err := rows.Scan(&m.UserID, &m.Balance, &m.LedgerBalance); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		report.MismatchedBalances = append(report.MismatchedBalances, m)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// VerifyHistory implements WalletStore, replaying each wallet's rows in id
+// order: ids are taken while the users row lock is held, so they follow the
+// order balances changed in even when created_at does not
+func (s *Postgres) VerifyHistory(ctx context.Context) (*models.HistoryReport, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT u.id, u.balance, t.id, t.type, t.amount, t.balance_before, t.balance_after
+		FROM users u
+		LEFT JOIN transactions t ON t.user_id = u.id
+		ORDER BY u.id, t.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := models.NewHistoryReport()
+	userID := 0
+	var balance models.Money
+	var history []models.Transaction
+	replay := func() {
+		if userID != 0 {
+			report.Replay(userID, balance, history)
+		}
+	}
+	for rows.Next() {
+		var id int
+		var userBalance models.Money
+		var txID sql.NullInt64
+		var txType sql.NullString
+		var t models.Transaction
+		if err := rows.Scan(&id, &userBalance, &txID, &txType, &t.Amount, &t.BalanceBefore, &t.BalanceAfter); err != nil {
+			return nil, err
+		}
+		if id != userID {
+			replay()
+			userID, balance, history = id, userBalance, history[:0]
+		}
+		// users without history come back as one row of NULLs
+		if txID.Valid {
+			t.ID, t.UserID, t.Type = int(txID.Int64), id, txType.String
+			history = append(history, t)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	replay()
+	return report, nil
+}
+
+// Adjustments implements WalletStore
+func (s *Postgres) Adjustments(ctx context.Context, userID, limit int) ([]models.Adjustment, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, admin_id, amount, reason, entry_id, created_at FROM adjustments
+		WHERE $1 = 0 OR user_id = $1 ORDER BY id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []models.Adjustment{}
+	for rows.Next() {
+		var a models.Adjustment
+		if err := rows.Scan(&a.ID, &a.UserID, &a.AdminID, &a.Amount, &a.Reason, &a.EntryID, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	return adjustments, rows.Err()
+}
+
+// Postings implements WalletStore
+func (s *Postgres) Postings(ctx context.Context, userID, limit int) ([]models.WalletPosting, error) {
+	var accountID int
+	err := s.DB.QueryRowContext(ctx, "SELECT id FROM accounts WHERE user_id = $1", userID).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT p.id, p.entry_id, e.kind, COALESCE(e.description, ''), p.amount, p.created_at
+		FROM postings p JOIN journal_entries e ON e.id = p.entry_id
+		WHERE p.account_id = $1 ORDER BY p.id DESC LIMIT $2`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	postings := []models.WalletPosting{}
+	for rows.Next() {
+		var p models.WalletPosting
+		if err := rows.Scan(&p.ID, &p.EntryID, &p.Kind, &p.Description, &p.Amount, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		postings = append(postings, p)
+	}
+	return postings, rows.Err()
+}
+
+// postgresTx WalletTx over a database transaction
+type postgresTx struct {
+	tx *sql.Tx
+}
+
+// LockBalances implements WalletTx with SELECT ... FOR UPDATE. Rows are locked
+// in ascending id order so two transactions touching the same wallets always
+// queue instead of deadlocking.
+func (t *postgresTx) LockBalances(ctx context.Context, userIDs ...int) (map[int]models.Money, error) {
+	ids := append([]int(nil), userIDs...)
+	sort.Ints(ids)
+
+	balances := make(map[int]models.Money, len(ids))
+	for _, id := range ids {
+		var balance models.Money
+		err := t.tx.QueryRowContext(ctx, "SELECT balance FROM users WHERE id = $1 FOR UPDATE", id).Scan(&balance)
+		if err == sql.ErrNoRows {
+			return nil, models.ErrUserNotFound
+		} else if err != nil {
+			return nil, fmt.Errorf("lock balance: %w", err)
+		}
+		balances[id] = balance
+	}
+	return balances, nil
+}
+
+// AdjustBalance implements WalletTx
+func (t *postgresTx) AdjustBalance(ctx context.Context, userID int, delta models.Money) (models.Money, error) {
+	var balance models.Money
+	err := t.tx.QueryRowContext(ctx, "UPDATE users SET balance = balance + $1 WHERE id = $2 RETURNING balance", delta, userID).
+		Scan(&balance)
+	if err == sql.ErrNoRows {
+		return balance, models.ErrUserNotFound
+	} else if err != nil {
+		return balance, fmt.Errorf("update balance: %w", err)
+	}
+	return balance, nil
+}
+
+// PostEntry implements WalletTx
+func (t *postgresTx) PostEntry(ctx context.Context, entry models.JournalEntry) (int, error) {
+	if err := entry.Validate(); err != nil {
+		return 0, err
+	}
+
+	var entryID int
+	err := t.tx.QueryRowContext(ctx, "INSERT INTO journal_entries (kind, description) VALUES ($1, $2) RETURNING id",
+		entry.Kind, entry.Description).Scan(&entryID)
+	if err != nil {
+		return 0, fmt.Errorf("insert journal entry: %w", err)
+	}
+
+	for _, p := range entry.Postings {
+		res, err := t.tx.ExecContext(ctx, "INSERT INTO postings (entry_id, account_id, amount) SELECT $1, id, $2 FROM accounts WHERE code = $3",
+			entryID, p.Amount, p.Account)
+		if err != nil {
+			return 0, fmt.Errorf("insert posting: %w", err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return 0, fmt.Errorf("insert posting: %w", err)
+		} else if n != 1 {
+			return 0, models.ErrUnknownAccount
+		}
+	}
+	return entryID, nil
+}
+
+// RecordTransactions implements WalletTx
+func (t *postgresTx) RecordTransactions(ctx context.Context, txs ...models.Transaction) error {
+	for _, r := range txs {
+		_, err := t.tx.ExecContext(ctx, `INSERT INTO transactions (user_id, type, amount, description, balance_before, balance_after, entry_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			r.UserID, r.Type, r.Amount, r.Description, r.BalanceBefore, r.BalanceAfter, r.EntryID)
+		if err != nil {
+			return fmt.Errorf("insert transaction: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecordAdjustment implements WalletTx
+func (t *postgresTx) RecordAdjustment(ctx context.Context, a *models.Adjustment) error {
+	err := t.tx.QueryRowContext(ctx,
+		"INSERT INTO adjustments (entry_id, user_id, admin_id, amount, reason) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		a.EntryID, a.UserID, a.AdminID, a.Amount, a.Reason).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert adjustment: %w", err)
+	}
+	return nil
+}
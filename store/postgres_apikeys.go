@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"gin-wallet2/models"
+	"strings"
+	"time"
+)
+
+// CreateAPIKey implements APIKeyStore, a taken prefix turns into no row
+func (s *Postgres) CreateAPIKey(ctx context.Context, k *models.APIKey) error {
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (prefix) DO NOTHING
+		RETURNING id, created_at`,
+		k.UserID, k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, " ")).Scan(&k.ID, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return models.ErrConflict
+	}
+	return err
+}
+
+// selectAPIKey columns scanned by scanAPIKey
+const selectAPIKey = "SELECT id, user_id, name, prefix, key_hash, scopes, last_used_at, revoked_at, created_at FROM api_keys"
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &lastUsedAt, &revokedAt, &k.CreatedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+// APIKeyByPrefix implements APIKeyStore
+func (s *Postgres) APIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	k, err := scanAPIKey(s.DB.QueryRowContext(ctx, selectAPIKey+" WHERE prefix = $1", prefix))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrInvalidAPIKey
+	}
+	return k, err
+}
+
+// APIKeys implements APIKeyStore
+func (s *Postgres) APIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.DB.QueryContext(ctx, selectAPIKey+" WHERE user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey implements APIKeyStore, an already revoked key keeps its
+// revoked_at but still counts as a row
+func (s *Postgres) RevokeAPIKey(ctx context.Context, userID, id int) error {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey implements APIKeyStore
+func (s *Postgres) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	_, err := s.DB.ExecContext(ctx, "UPDATE api_keys SET last_used_at = $2 WHERE id = $1", id, at)
+	return err
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgres_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	columns := []string{"id", "user_id", "name", "prefix", "key_hash", "scopes", "last_used_at", "revoked_at", "created_at"}
+
+	k := &models.APIKey{UserID: 1, Name: "shop", Prefix: "abc", KeyHash: "h1", Scopes: []string{models.ScopeWalletRead, models.ScopeWalletDeposit}}
+	mock.ExpectQuery("INSERT INTO api_keys (.+) ON CONFLICT \\(prefix\\) DO NOTHING RETURNING id, created_at").
+		WithArgs(1, "shop", "abc", "h1", "wallet:read wallet:deposit").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+	require.NoError(t, s.CreateAPIKey(ctx, k))
+	assert.Equal(t, 7, k.ID)
+	assert.Equal(t, now, k.CreatedAt)
+
+	mock.ExpectQuery("INSERT INTO api_keys").WillReturnError(sql.ErrNoRows)
+	assert.ErrorIs(t, s.CreateAPIKey(ctx, k), models.ErrConflict)
+
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE prefix = \\$1").
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, 1, "shop", "abc", "h1", "wallet:read wallet:deposit", now, nil, now))
+	got, err := s.APIKeyByPrefix(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, &models.APIKey{
+		ID: 7, UserID: 1, Name: "shop", Prefix: "abc", KeyHash: "h1",
+		Scopes: []string{models.ScopeWalletRead, models.ScopeWalletDeposit}, LastUsedAt: &now, CreatedAt: now,
+	}, got)
+
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE prefix = \\$1").
+		WithArgs("zzz").
+		WillReturnError(sql.ErrNoRows)
+	_, err = s.APIKeyByPrefix(ctx, "zzz")
+	assert.ErrorIs(t, err, models.ErrInvalidAPIKey)
+
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE user_id = \\$1 ORDER BY id DESC").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(8, 1, "billing", "def", "h2", "wallet:transfer", nil, now, now).
+			AddRow(7, 1, "shop", "abc", "h1", "wallet:read", nil, nil, now))
+	keys, err := s.APIKeys(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, &now, keys[0].RevokedAt)
+	assert.Equal(t, []string{models.ScopeWalletRead}, keys[1].Scopes)
+
+	mock.ExpectExec("UPDATE api_keys SET revoked_at = COALESCE\\(revoked_at, CURRENT_TIMESTAMP\\) WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(7, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.RevokeAPIKey(ctx, 1, 7))
+	mock.ExpectExec("UPDATE api_keys SET revoked_at").
+		WithArgs(7, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.RevokeAPIKey(ctx, 2, 7), models.ErrAPIKeyNotFound)
+
+	mock.ExpectExec("UPDATE api_keys SET last_used_at = \\$2 WHERE id = \\$1").
+		WithArgs(7, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.TouchAPIKey(ctx, 7, now))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"gin-wallet2/models"
+	"time"
+)
+
+// LoginFailures implements LoginStore
+func (s *Postgres) LoginFailures(ctx context.Context, key string) (*models.LoginFailures, error) {
+	var f models.LoginFailures
+	var lockedUntil sql.NullTime
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT failures, last_failed_at, locked_until FROM login_failures WHERE key = $1", key).
+		Scan(&f.Failures, &f.LastFailedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return &models.LoginFailures{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	f.LockedUntil = lockedUntil.Time
+	return &f, nil
+}
+
+// AddLoginFailure implements LoginStore, the upsert counts concurrent
+// failures exactly
+func (s *Postgres) AddLoginFailure(ctx context.Context, key string, since time.Time) (int, error) {
+	var failures int
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO login_failures (key, failures) VALUES ($1, 1)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failed_at < $2 THEN 1 ELSE login_failures.failures + 1 END,
+			last_failed_at = CURRENT_TIMESTAMP
+		RETURNING failures`, key, since).Scan(&failures)
+	return failures, err
+}
+
+// LockLogin implements LoginStore
+func (s *Postgres) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := s.DB.ExecContext(ctx, "UPDATE login_failures SET locked_until = $2 WHERE key = $1", key, until)
+	return err
+}
+
+// ResetLoginFailures implements LoginStore
+func (s *Postgres) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
+
+// RecordLoginAttempt implements LoginStore
+func (s *Postgres) RecordLoginAttempt(ctx context.Context, a *models.LoginAttempt) error {
+	return s.DB.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (user_id, ip, user_agent, outcome) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		a.UserID, a.IP, a.UserAgent, a.Outcome).Scan(&a.ID, &a.CreatedAt)
+}
+
+// LoginAttempts implements LoginStore
+func (s *Postgres) LoginAttempts(ctx context.Context, userID, limit int) ([]models.LoginAttempt, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, ip, user_agent, outcome, created_at FROM login_attempts
+		WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []models.LoginAttempt{}
+	for rows.Next() {
+		a := models.LoginAttempt{UserID: userID}
+		if err := rows.Scan(&a.ID, &a.IP, &a.UserAgent, &a.Outcome, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
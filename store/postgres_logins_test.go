@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgres_LoginFailures(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT failures, last_failed_at, locked_until FROM login_failures WHERE key = \\$1").
+		WithArgs("user:1").
+		WillReturnError(sql.ErrNoRows)
+	f, err := s.LoginFailures(ctx, "user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, &models.LoginFailures{}, f)
+
+	mock.ExpectQuery("INSERT INTO login_failures (.+) ON CONFLICT \\(key\\) DO UPDATE").
+		WithArgs("user:1", now.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(5))
+	n, err := s.AddLoginFailure(ctx, "user:1", now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	mock.ExpectExec("UPDATE login_failures SET locked_until").
+		WithArgs("user:1", now.Add(time.Minute)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.LockLogin(ctx, "user:1", now.Add(time.Minute)))
+
+	mock.ExpectQuery("SELECT failures, last_failed_at, locked_until FROM login_failures WHERE key = \\$1").
+		WithArgs("user:1").
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "last_failed_at", "locked_until"}).AddRow(5, now, now.Add(time.Minute)))
+	f, err = s.LoginFailures(ctx, "user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, &models.LoginFailures{Failures: 5, LastFailedAt: now, LockedUntil: now.Add(time.Minute)}, f)
+
+	mock.ExpectExec("DELETE FROM login_failures WHERE key = \\$1").
+		WithArgs("user:1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.ResetLoginFailures(ctx, "user:1"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_LoginAttempts(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	a := &models.LoginAttempt{UserID: 1, IP: "10.0.0.1", UserAgent: "curl/8.0", Outcome: models.LoginFailed}
+	mock.ExpectQuery("INSERT INTO login_attempts").
+		WithArgs(1, "10.0.0.1", "curl/8.0", models.LoginFailed).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+	assert.NoError(t, s.RecordLoginAttempt(ctx, a))
+	assert.Equal(t, 7, a.ID)
+	assert.Equal(t, now, a.CreatedAt)
+
+	mock.ExpectQuery("SELECT (.+) FROM login_attempts WHERE user_id = \\$1 ORDER BY created_at DESC, id DESC LIMIT \\$2").
+		WithArgs(1, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ip", "user_agent", "outcome", "created_at"}).
+			AddRow(8, "10.0.0.1", "curl/8.0", models.LoginSucceeded, now.Add(time.Second)).
+			AddRow(7, "10.0.0.1", "curl/8.0", models.LoginFailed, now))
+	attempts, err := s.LoginAttempts(ctx, 1, 50)
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, 8, attempts[0].ID)
+	assert.Equal(t, 1, attempts[1].UserID)
+
+	mock.ExpectQuery("SELECT (.+) FROM login_attempts").
+		WithArgs(2, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ip", "user_agent", "outcome", "created_at"}))
+	attempts, err = s.LoginAttempts(ctx, 2, 50)
+	assert.NoError(t, err)
+	assert.NotNil(t, attempts)
+	assert.Empty(t, attempts)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
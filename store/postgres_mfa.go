@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gin-wallet2/models"
+)
+
+// MFA implements MFAStore
+func (s *Postgres) MFA(ctx context.Context, userID int) (*models.MFA, error) {
+	mfa := models.MFA{UserID: userID}
+	var enabledAt sql.NullTime
+	err := s.DB.QueryRowContext(ctx,
+		"SELECT secret, enabled_at, last_counter FROM user_mfa WHERE user_id = $1", userID).
+		Scan(&mfa.Secret, &enabledAt, &mfa.LastCounter)
+	if err == sql.ErrNoRows {
+		return &mfa, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		mfa.EnabledAt = &enabledAt.Time
+	}
+	return &mfa, nil
+}
+
+// StartMFA implements MFAStore, an enabled secret is left alone and turns
+// into no row
+func (s *Postgres) StartMFA(ctx context.Context, userID int, secret string) error {
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_counter = 0, created_at = CURRENT_TIMESTAMP
+		WHERE user_mfa.enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableMFA implements MFAStore
+func (s *Postgres) EnableMFA(ctx context.Context, userID int, counter int64, recoveryHashes []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE user_mfa SET enabled_at = CURRENT_TIMESTAMP, last_counter = $2
+		WHERE user_id = $1 AND enabled_at IS NULL`, userID, counter)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("enable: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		_ = tx.Rollback()
+		if err != nil {
+			return err
+		}
+		return models.ErrMFANotEnrolled
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, h := range recoveryHashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, h)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// DisableMFA implements MFAStore
+func (s *Postgres) DisableMFA(ctx context.Context, userID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userID); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("delete secret: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPCounter implements MFAStore, the conditional update makes a code
+// usable once even by concurrent requests
+func (s *Postgres) UseTOTPCounter(ctx context.Context, userID int, counter int64) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE user_mfa SET last_counter = $2 WHERE user_id = $1 AND last_counter < $2", userID, counter)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode implements MFAStore
+func (s *Postgres) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, hash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
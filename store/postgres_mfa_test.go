@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgres_MFA(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT secret, enabled_at, last_counter FROM user_mfa WHERE user_id = \\$1").
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+	mfa, err := s.MFA(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &models.MFA{UserID: 1}, mfa)
+
+	mock.ExpectQuery("SELECT secret, enabled_at, last_counter FROM user_mfa").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"secret", "enabled_at", "last_counter"}).AddRow("secret", now, 42))
+	mfa, err = s.MFA(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &models.MFA{UserID: 1, Secret: "secret", EnabledAt: &now, LastCounter: 42}, mfa)
+
+	mock.ExpectExec("INSERT INTO user_mfa (.+) ON CONFLICT \\(user_id\\) DO UPDATE (.+) WHERE user_mfa.enabled_at IS NULL").
+		WithArgs(1, "secret").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.StartMFA(ctx, 1, "secret"))
+
+	mock.ExpectExec("INSERT INTO user_mfa").
+		WithArgs(1, "secret").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.StartMFA(ctx, 1, "secret"), models.ErrMFAAlreadyEnabled)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_EnableMFA(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("enables and replaces recovery codes", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE user_mfa SET enabled_at = CURRENT_TIMESTAMP, last_counter = \\$2 WHERE user_id = \\$1 AND enabled_at IS NULL").
+			WithArgs(1, int64(42)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM mfa_recovery_codes WHERE user_id = \\$1").
+			WithArgs(1).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec("INSERT INTO mfa_recovery_codes").WithArgs(1, "h1").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO mfa_recovery_codes").WithArgs(1, "h2").WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, s.EnableMFA(ctx, 1, 42, []string{"h1", "h2"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("nothing pending", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE user_mfa").
+			WithArgs(1, int64(42)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		assert.ErrorIs(t, s.EnableMFA(ctx, 1, 42, []string{"h1"}), models.ErrMFANotEnrolled)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgres_UseMFA(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectExec("UPDATE user_mfa SET last_counter = \\$2 WHERE user_id = \\$1 AND last_counter < \\$2").
+		WithArgs(1, int64(43)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := s.UseTOTPCounter(ctx, 1, 43)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	mock.ExpectExec("UPDATE user_mfa SET last_counter").
+		WithArgs(1, int64(43)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.UseTOTPCounter(ctx, 1, 43)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	mock.ExpectExec("UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = \\$1 AND code_hash = \\$2 AND used_at IS NULL").
+		WithArgs(1, "h1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err = s.UseRecoveryCode(ctx, 1, "h1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM mfa_recovery_codes WHERE user_id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("DELETE FROM user_mfa WHERE user_id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, s.DisableMFA(ctx, 1))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,547 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func newMockPostgres(t *testing.T) (*Postgres, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewPostgres(db), mock
+}
+
+func TestPostgres_CreateUser(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("creates user and ledger account", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").WithArgs("testuser", "hash").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectExec("INSERT INTO accounts").WithArgs("user:7", 7).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		id, err := s.CreateUser(ctx, "testuser", "hash")
+		assert.NoError(t, err)
+		assert.Equal(t, 7, id)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("insert error rolls back", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").WithArgs("testuser", "hash").
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		_, err := s.CreateUser(ctx, "testuser", "hash")
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("taken name", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users (.+) ON CONFLICT DO NOTHING RETURNING id").WithArgs("TestUser", "hash").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+
+		_, err := s.CreateUser(ctx, "TestUser", "hash")
+		assert.ErrorIs(t, err, models.ErrNameTaken)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgres_UserByName(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, name, password_hash, role, display_name, email, phone, created_at FROM users WHERE lower\\(name\\) = lower\\(\\$1\\)").
+		WithArgs("TestUser").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password_hash", "role", "display_name", "email", "phone", "created_at"}).
+			AddRow(1, "testuser", "hash", "admin", "Test User", "test@example.com", "", created))
+	u, err := s.UserByName(ctx, "TestUser")
+	assert.NoError(t, err)
+	assert.Equal(t, &models.User{
+		ID: 1, Name: "testuser", PasswordHash: "hash", Role: models.RoleAdmin,
+		Profile:   models.Profile{DisplayName: "Test User", Email: "test@example.com"},
+		CreatedAt: created,
+	}, u)
+
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE lower\\(name\\) = lower\\(\\$1\\)").
+		WithArgs("nobody").
+		WillReturnError(sql.ErrNoRows)
+	_, err = s.UserByName(ctx, "nobody")
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_UpdateUser(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectExec("UPDATE users SET password_hash = \\$2 WHERE id = \\$1").
+		WithArgs(1, "new-hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.UpdatePassword(ctx, 1, "new-hash"))
+
+	mock.ExpectExec("UPDATE users SET password_hash").
+		WithArgs(2, "new-hash").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, s.UpdatePassword(ctx, 2, "new-hash"), models.ErrUserNotFound)
+
+	profile := models.Profile{DisplayName: "Alice", Email: "alice@example.com", Phone: "+4915112345678"}
+	mock.ExpectExec("UPDATE users SET display_name = \\$2, email = \\$3, phone = \\$4 WHERE id = \\$1").
+		WithArgs(1, "Alice", "alice@example.com", "+4915112345678").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.UpdateProfile(ctx, 1, profile))
+
+	mock.ExpectExec("UPDATE users SET role = \\$2 WHERE id = \\$1").
+		WithArgs(1, "support").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.UpdateRole(ctx, 1, models.RoleSupport))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE name ILIKE \\$1 OR display_name ILIKE \\$1 OR email ILIKE \\$1 ORDER BY id LIMIT \\$2").
+		WithArgs(`%50\%\_off%`, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password_hash", "role", "display_name", "email", "phone", "created_at"}).
+			AddRow(3, "bob", "hash", "user", "", "50%_off@example.com", "", created))
+	users, err := s.SearchUsers(ctx, "50%_off", 20)
+	assert.NoError(t, err)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, 3, users[0].ID)
+		assert.Equal(t, models.RoleUser, users[0].Role)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE name ILIKE").
+		WithArgs("%nobody%", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "password_hash", "role", "display_name", "email", "phone", "created_at"}))
+	users, err = s.SearchUsers(ctx, "nobody", 20)
+	assert.NoError(t, err)
+	assert.NotNil(t, users)
+	assert.Empty(t, users)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_Adjustments(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO adjustments \\(entry_id, user_id, admin_id, amount, reason\\)").
+		WithArgs(10, 2, 1, "-5.00", "duplicate deposit").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, created))
+	mock.ExpectCommit()
+
+	a := &models.Adjustment{UserID: 2, AdminID: 1, Amount: models.MustParseMoney("-5"), Reason: "duplicate deposit", EntryID: 10}
+	assert.NoError(t, s.WithinTx(ctx, func(tx WalletTx) error { return tx.RecordAdjustment(ctx, a) }))
+	assert.Equal(t, 7, a.ID)
+	assert.Equal(t, created, a.CreatedAt)
+
+	mock.ExpectQuery("SELECT (.+) FROM adjustments WHERE \\$1 = 0 OR user_id = \\$1 ORDER BY id DESC LIMIT \\$2").
+		WithArgs(2, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "admin_id", "amount", "reason", "entry_id", "created_at"}).
+			AddRow(7, 2, 1, -5.0, "duplicate deposit", 10, created))
+	adjustments, err := s.Adjustments(ctx, 2, 50)
+	assert.NoError(t, err)
+	if assert.Len(t, adjustments, 1) {
+		assert.Equal(t, "-5.00", adjustments[0].Amount.String())
+		assert.Equal(t, "duplicate deposit", adjustments[0].Reason)
+		assert.Equal(t, 1, adjustments[0].AdminID)
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_Postings(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id FROM accounts WHERE user_id = \\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectQuery("SELECT (.+) FROM postings p JOIN journal_entries e ON e.id = p.entry_id WHERE p.account_id = \\$1 ORDER BY p.id DESC LIMIT \\$2").
+		WithArgs(3, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "entry_id", "kind", "description", "amount", "created_at"}).
+			AddRow(8, 4, "transfer", "rent", "-25.00", created).
+			AddRow(5, 2, "deposit", "", "100.00", created))
+	postings, err := s.Postings(ctx, 2, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.WalletPosting{
+		{ID: 8, EntryID: 4, Kind: "transfer", Description: "rent", Amount: models.MustParseMoney("-25.00"), CreatedAt: created},
+		{ID: 5, EntryID: 2, Kind: "deposit", Amount: models.MustParseMoney("100.00"), CreatedAt: created},
+	}, postings)
+
+	mock.ExpectQuery("SELECT id FROM accounts WHERE user_id = \\$1").WithArgs(99).WillReturnError(sql.ErrNoRows)
+	_, err = s.Postings(ctx, 99, 100)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_WithinTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("deposit unit of work", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("UPDATE users SET balance = balance \\+ \\$1 WHERE id = \\$2 RETURNING balance").
+			WithArgs("100.00", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("150.00"))
+		mock.ExpectQuery("INSERT INTO journal_entries").
+			WithArgs("deposit", "Deposit to wallet").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+		mock.ExpectExec("INSERT INTO postings").
+			WithArgs(10, "-100.00", "system:cash").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO postings").
+			WithArgs(10, "100.00", "user:1").
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectExec("INSERT INTO transactions").
+			WithArgs(1, "deposit", "100.00", "Deposit to wallet", "50.00", "150.00", 10).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		amount := models.MustParseMoney("100")
+		err := s.WithinTx(ctx, func(tx WalletTx) error {
+			balance, err := tx.AdjustBalance(ctx, 1, amount)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "150.00", balance.String())
+			entryID, err := tx.PostEntry(ctx, models.JournalEntry{
+				Kind:        "deposit",
+				Description: "Deposit to wallet",
+				Postings: []models.Posting{
+					{Account: models.CashAccount, Amount: amount.Neg()},
+					{Account: models.UserAccount(1), Amount: amount},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			return tx.RecordTransactions(ctx, models.Transaction{
+				UserID: 1, Type: "deposit", Amount: amount, Description: "Deposit to wallet", EntryID: entryID,
+			}.WithBalance(balance))
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("error rolls back", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		errBoom := errors.New("boom")
+		err := s.WithinTx(ctx, func(_ WalletTx) error { return errBoom })
+		assert.ErrorIs(t, err, errBoom)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("commit error is returned", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectCommit().WillReturnError(sql.ErrConnDone)
+
+		err := s.WithinTx(ctx, func(_ WalletTx) error { return nil })
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("begin error is returned", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin().WillReturnError(sql.ErrConnDone)
+
+		err := s.WithinTx(ctx, func(_ WalletTx) error { return nil })
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresTx_LockBalances(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT balance FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("5.00"))
+	mock.ExpectQuery("SELECT balance FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow("100.00"))
+	mock.ExpectQuery("SELECT balance FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(9).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	err := s.WithinTx(ctx, func(tx WalletTx) error {
+		// rows are locked in id order regardless of argument order
+		balances, err := tx.LockBalances(ctx, 3, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "100.00", balances[3].String())
+		assert.Equal(t, "5.00", balances[2].String())
+
+		_, err = tx.LockBalances(ctx, 9)
+		return err
+	})
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresTx_AdjustBalanceUserNotFound(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users").
+		WithArgs("1.00", 42).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}))
+	mock.ExpectRollback()
+
+	err := s.WithinTx(ctx, func(tx WalletTx) error {
+		_, err := tx.AdjustBalance(ctx, 42, models.MustParseMoney("1"))
+		return err
+	})
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresTx_PostEntry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unbalanced entry is rejected", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err := s.WithinTx(ctx, func(tx WalletTx) error {
+			_, err := tx.PostEntry(ctx, models.JournalEntry{Kind: "deposit", Postings: []models.Posting{
+				{Account: models.CashAccount, Amount: models.MustParseMoney("-10.00")},
+				{Account: models.UserAccount(1), Amount: models.MustParseMoney("10.01")},
+			}})
+			return err
+		})
+		assert.ErrorIs(t, err, models.ErrUnbalancedEntry)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown account", func(t *testing.T) {
+		s, mock := newMockPostgres(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO journal_entries").
+			WithArgs("deposit", "Deposit to wallet").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec("INSERT INTO postings").
+			WithArgs(3, "-10.00", models.CashAccount).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO postings").
+			WithArgs(3, "10.00", "user:42").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := s.WithinTx(ctx, func(tx WalletTx) error {
+			_, err := tx.PostEntry(ctx, models.JournalEntry{Kind: "deposit", Description: "Deposit to wallet", Postings: []models.Posting{
+				{Account: models.CashAccount, Amount: models.MustParseMoney("-10.00")},
+				{Account: models.UserAccount(42), Amount: models.MustParseMoney("10.00")},
+			}})
+			return err
+		})
+		assert.ErrorIs(t, err, models.ErrUnknownAccount)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgres_Balance(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectQuery("SELECT balance FROM users").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
+	balance, err := s.Balance(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "100.00", balance.String())
+
+	mock.ExpectQuery("SELECT balance FROM users").
+		WithArgs(999).
+		WillReturnError(sql.ErrNoRows)
+	_, err = s.Balance(ctx, 999)
+	assert.ErrorIs(t, err, models.ErrUserNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_Transactions(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	columns := []string{"id", "type", "amount", "description", "balance_before", "balance_after", "created_at"}
+	rows := sqlmock.NewRows(columns).
+		AddRow(2, "withdraw", 50.0, "Withdraw from wallet", "100.00", "50.00", created.Add(24*time.Hour)).
+		AddRow(1, "deposit", 100.0, "Deposit to wallet", nil, nil, created)
+	mock.ExpectQuery("SELECT (.+) FROM transactions WHERE user_id = \\$1 ORDER BY created_at DESC, id DESC$").
+		WithArgs(1).
+		WillReturnRows(rows)
+
+	transactions, err := s.Transactions(ctx, 1, models.TransactionQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 2)
+	assert.Equal(t, "withdraw", transactions[0].Type)
+	assert.Equal(t, "50.00", transactions[0].BalanceAfter.String())
+	assert.Nil(t, transactions[1].BalanceBefore)
+	assert.Equal(t, "100.00", transactions[1].Amount.String())
+	assert.Equal(t, created, transactions[1].CreatedAt)
+
+	mock.ExpectQuery("SELECT (.+) FROM transactions WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns))
+	transactions, err = s.Transactions(ctx, 2, models.TransactionQuery{})
+	assert.NoError(t, err)
+	assert.NotNil(t, transactions)
+	assert.Empty(t, transactions)
+
+	min, max := models.MustParseMoney("10"), models.MustParseMoney("500")
+	to := created.Add(48 * time.Hour)
+	q := models.TransactionQuery{
+		TransactionFilter: models.TransactionFilter{
+			Types: []string{models.TxTypeDeposit, models.TxTypeTransferIn}, MinAmount: &min, MaxAmount: &max, From: &created, To: &to,
+		},
+		Ascending: true,
+		After:     &models.TransactionCursor{CreatedAt: created, ID: 1},
+		Limit:     21,
+	}
+	mock.ExpectQuery("SELECT id, type, amount, description, balance_before, balance_after, created_at FROM transactions "+
+		"WHERE user_id = \\$1 AND type IN \\(\\$2, \\$3\\) AND amount >= \\$4 AND amount <= \\$5 AND created_at >= \\$6 AND created_at < \\$7 "+
+		"AND \\(created_at, id\\) > \\(\\$8, \\$9\\) ORDER BY created_at ASC, id ASC LIMIT \\$10").
+		WithArgs(1, "deposit", "transfer_in", "10.00", "500.00", created, to, created, 1, 21).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "deposit", 20.0, "Deposit to wallet", "0.00", "20.00", created))
+	transactions, err = s.Transactions(ctx, 1, q)
+	assert.NoError(t, err)
+	assert.Len(t, transactions, 1)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM transactions WHERE user_id = \\$1 AND type IN \\(\\$2, \\$3\\) AND amount >= \\$4 AND amount <= \\$5 AND created_at >= \\$6 AND created_at < \\$7$").
+		WithArgs(1, "deposit", "transfer_in", "10.00", "500.00", created, to).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	n, err := s.CountTransactions(ctx, 1, q.TransactionFilter)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_Transaction(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+	created := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	columns := []string{"type", "amount", "description", "created_at", "entry_id", "code", "user_id", "name", "balance_before", "balance_after"}
+
+	mock.ExpectQuery("SELECT (.+) FROM transactions t LEFT JOIN LATERAL (.+) WHERE t.id = \\$1 AND t.user_id = \\$2").
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("transfer", "30.00", "Transfer to user 2", created, 9, "user:2", 2, "bob", "100.00", "70.00"))
+	detail, err := s.Transaction(ctx, 1, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 5, detail.ID)
+	assert.Equal(t, 9, detail.EntryID)
+	assert.Equal(t, &models.Counterparty{Account: "user:2", UserID: 2, Name: "bob"}, detail.Counterparty)
+	require.NotNil(t, detail.BalanceAfter)
+	assert.Equal(t, "70.00", detail.BalanceAfter.String())
+	assert.Equal(t, "100.00", detail.BalanceBefore.String())
+
+	// history from before the ledger has no entry
+	mock.ExpectQuery("SELECT (.+) FROM transactions t").
+		WithArgs(6, 1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("deposit", "10.00", "Deposit", created, nil, nil, nil, nil, nil, nil))
+	detail, err = s.Transaction(ctx, 1, 6)
+	require.NoError(t, err)
+	assert.Nil(t, detail.Counterparty)
+	assert.Nil(t, detail.BalanceAfter)
+
+	mock.ExpectQuery("SELECT (.+) FROM transactions t").
+		WithArgs(7, 1).
+		WillReturnError(sql.ErrNoRows)
+	_, err = s.Transaction(ctx, 1, 7)
+	assert.ErrorIs(t, err, models.ErrTransactionNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_VerifyLedger(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectQuery("SELECT entry_id FROM postings").
+		WillReturnRows(sqlmock.NewRows([]string{"entry_id"}).AddRow(5))
+	mock.ExpectQuery("SELECT u.id, u.balance").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "sum"}).AddRow(1, "100.00", "90.00"))
+
+	report, err := s.VerifyLedger(ctx)
+	assert.NoError(t, err)
+	assert.False(t, report.Balanced())
+	assert.Equal(t, []int{5}, report.UnbalancedEntries)
+	assert.Equal(t, []models.BalanceMismatch{{
+		UserID: 1, Balance: models.MustParseMoney("100.00"), LedgerBalance: models.MustParseMoney("90.00"),
+	}}, report.MismatchedBalances)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_VerifyHistory(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectQuery("SELECT (.+) FROM users u LEFT JOIN transactions t ON t.user_id = u.id ORDER BY u.id, t.id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "id", "type", "amount", "balance_before", "balance_after"}).
+			AddRow(1, "150.00", 1, "deposit", "100.00", "0.00", "100.00").
+			AddRow(1, "150.00", 3, "deposit", "50.00", "100.00", "150.00").
+			AddRow(2, "0.00", nil, nil, nil, nil, nil).
+			// a row from before balances were stored, then a balance change
+			// without history
+			AddRow(3, "80.00", 2, "withdraw", "30.00", nil, nil).
+			AddRow(3, "80.00", 4, "withdraw", "10.00", "100.00", "90.00").
+			AddRow(4, "20.00", 5, "transfer_in", "20.00", "5.00", "20.00").
+			// history from before balances were stored only
+			AddRow(5, "40.00", 6, "deposit", "40.00", nil, nil))
+
+	report, err := s.VerifyHistory(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &models.HistoryReport{
+		Mismatches: []models.HistoryMismatch{
+			{UserID: 3, Balance: models.MustParseMoney("80.00"), ReplayedBalance: models.MustParseMoney("90.00")},
+			{UserID: 4, Balance: models.MustParseMoney("20.00"), ReplayedBalance: models.MustParseMoney("25.00"), DriftedAt: 5},
+		},
+		Unverified: []int{5},
+		LegacyRows: 2,
+	}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"gin-wallet2/models"
+	"time"
+)
+
+// revokeRefreshTokens revokes the matching refresh tokens and denylists the
+// access tokens issued with them in one statement. %s is the filter on
+// refresh_tokens, with $1 as its only parameter.
+const revokeRefreshTokens = `
+	WITH revoked AS (
+		UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE %s AND revoked_at IS NULL
+		RETURNING access_jti, access_expires_at
+	)
+	INSERT INTO revoked_tokens (jti, expires_at)
+	SELECT access_jti, access_expires_at FROM revoked WHERE access_expires_at > CURRENT_TIMESTAMP
+	ON CONFLICT (jti) DO NOTHING`
+
+// CreateRefreshToken implements TokenStore
+func (s *Postgres) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	return s.DB.QueryRowContext(ctx, `
+		INSERT INTO refresh_tokens (token_hash, family_id, user_id, access_jti, access_expires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		t.TokenHash, t.FamilyID, t.UserID, t.AccessTokenID, t.AccessExpiresAt, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+}
+
+// RefreshTokenByHash implements TokenStore
+func (s *Postgres) RefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	t := models.RefreshToken{TokenHash: hash}
+	var usedAt, revokedAt sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, family_id, user_id, access_jti, access_expires_at, expires_at, used_at, revoked_at, created_at
+		FROM refresh_tokens WHERE token_hash = $1`, hash).
+		Scan(&t.ID, &t.FamilyID, &t.UserID, &t.AccessTokenID, &t.AccessExpiresAt, &t.ExpiresAt, &usedAt, &revokedAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// MarkRefreshTokenUsed implements TokenStore, the conditional update makes
+// two concurrent refreshes with the same token see exactly one winner
+func (s *Postgres) MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error) {
+	res, err := s.DB.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// RevokeFamily implements TokenStore
+func (s *Postgres) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(revokeRefreshTokens, "family_id = $1"), familyID)
+	return err
+}
+
+// RevokeUserTokens implements TokenStore
+func (s *Postgres) RevokeUserTokens(ctx context.Context, userID int) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(revokeRefreshTokens, "user_id = $1"), userID)
+	return err
+}
+
+// RevokeAccessToken implements TokenStore, expired entries are purged on the way
+func (s *Postgres) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= CURRENT_TIMESTAMP"); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expiresAt)
+	return err
+}
+
+// IsAccessTokenRevoked implements TokenStore
+func (s *Postgres) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := s.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"gin-wallet2/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgres_RefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	rt := &models.RefreshToken{
+		TokenHash: "hash", FamilyID: "fam", UserID: 1, AccessTokenID: "jti",
+		AccessExpiresAt: now.Add(15 * time.Minute), ExpiresAt: now.Add(time.Hour),
+	}
+	mock.ExpectQuery("INSERT INTO refresh_tokens").
+		WithArgs("hash", "fam", 1, "jti", rt.AccessExpiresAt, rt.ExpiresAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, now))
+	assert.NoError(t, s.CreateRefreshToken(ctx, rt))
+	assert.Equal(t, 3, rt.ID)
+
+	mock.ExpectQuery("SELECT (.+) FROM refresh_tokens WHERE token_hash = \\$1").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "family_id", "user_id", "access_jti", "access_expires_at", "expires_at", "used_at", "revoked_at", "created_at"}).
+			AddRow(3, "fam", 1, "jti", rt.AccessExpiresAt, rt.ExpiresAt, now, nil, now))
+	got, err := s.RefreshTokenByHash(ctx, "hash")
+	assert.NoError(t, err)
+	assert.Equal(t, "fam", got.FamilyID)
+	assert.Equal(t, now, *got.UsedAt)
+	assert.Nil(t, got.RevokedAt)
+
+	mock.ExpectQuery("SELECT (.+) FROM refresh_tokens WHERE token_hash = \\$1").
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+	_, err = s.RefreshTokenByHash(ctx, "missing")
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+	mock.ExpectExec("UPDATE refresh_tokens SET used_at").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := s.MarkRefreshTokenUsed(ctx, 3)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	mock.ExpectExec("UPDATE refresh_tokens SET used_at").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+	ok, err = s.MarkRefreshTokenUsed(ctx, 3)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_RevokeTokens(t *testing.T) {
+	ctx := context.Background()
+	s, mock := newMockPostgres(t)
+
+	mock.ExpectExec("WITH revoked AS \\(\\s+UPDATE refresh_tokens (.+) WHERE family_id = \\$1 (.+) INSERT INTO revoked_tokens").
+		WithArgs("fam").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	assert.NoError(t, s.RevokeFamily(ctx, "fam"))
+
+	mock.ExpectExec("WITH revoked AS \\(\\s+UPDATE refresh_tokens (.+) WHERE user_id = \\$1 (.+) INSERT INTO revoked_tokens").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	assert.NoError(t, s.RevokeUserTokens(ctx, 1))
+
+	exp := time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)
+	mock.ExpectExec("DELETE FROM revoked_tokens WHERE expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO revoked_tokens").WithArgs("jti", exp).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.RevokeAccessToken(ctx, "jti", exp))
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("jti").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	revoked, err := s.IsAccessTokenRevoked(ctx, "jti")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,149 @@
+// Package store storage interfaces used by the handlers, with Postgres and
+// in-memory implementations
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"time"
+)
+
+// UserStore user persistence
+type UserStore interface {
+	// CreateUser creates a user together with its ledger account and returns
+	// its id, models.ErrNameTaken if the name is taken ignoring case
+	CreateUser(ctx context.Context, name, passwordHash string) (int, error)
+	// UserByName returns the user with the given name ignoring case,
+	// models.ErrUserNotFound if none
+	UserByName(ctx context.Context, name string) (*models.User, error)
+	// UserByID returns the user with the given id, models.ErrUserNotFound if none
+	UserByID(ctx context.Context, id int) (*models.User, error)
+	// UpdatePassword replaces the user's password hash, models.ErrUserNotFound if none
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+	// UpdateProfile replaces the user's profile, models.ErrUserNotFound if none
+	UpdateProfile(ctx context.Context, id int, profile models.Profile) error
+	// UpdateRole replaces the user's role, models.ErrUserNotFound if none
+	UpdateRole(ctx context.Context, id int, role string) error
+	// SearchUsers returns up to limit users whose name, display name or email
+	// contains query ignoring case, ordered by id
+	SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error)
+}
+
+// TokenStore refresh tokens and revoked access tokens
+type TokenStore interface {
+	// CreateRefreshToken stores a newly issued refresh token and sets its ID
+	CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	// RefreshTokenByHash returns the token with the given hash, models.ErrInvalidToken if none
+	RefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// MarkRefreshTokenUsed marks the token used, false if it already was used or revoked
+	MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error)
+	// RevokeFamily revokes every refresh token of the family and denylists
+	// their access tokens that have not expired yet
+	RevokeFamily(ctx context.Context, familyID string) error
+	// RevokeUserTokens does the same as RevokeFamily for every family of the user
+	RevokeUserTokens(ctx context.Context, userID int) error
+	// RevokeAccessToken denylists an access token until it expires
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsAccessTokenRevoked reports whether the access token is denylisted
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// LoginStore failed login counters and login history. Counters are keyed by
+// strings such as "user:1" or "ip:10.0.0.1" so users and client IPs share them.
+type LoginStore interface {
+	// LoginFailures returns the counter of key, a zero value if there is none
+	LoginFailures(ctx context.Context, key string) (*models.LoginFailures, error)
+	// AddLoginFailure counts a failed login for key and returns the number of
+	// consecutive failures, restarting from one if the last was before since
+	AddLoginFailure(ctx context.Context, key string, since time.Time) (int, error)
+	// LockLogin rejects logins for key until the given time
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	// ResetLoginFailures clears the counter and lock of key
+	ResetLoginFailures(ctx context.Context, key string) error
+	// RecordLoginAttempt appends an attempt to the user's login history and sets its ID
+	RecordLoginAttempt(ctx context.Context, a *models.LoginAttempt) error
+	// LoginAttempts returns the user's most recent login attempts, newest first
+	LoginAttempts(ctx context.Context, userID, limit int) ([]models.LoginAttempt, error)
+}
+
+// MFAStore TOTP secrets and recovery codes
+type MFAStore interface {
+	// MFA returns the user's two-factor state, a zero value if enrollment never started
+	MFA(ctx context.Context, userID int) (*models.MFA, error)
+	// StartMFA stores a pending secret for the user, replacing a pending one,
+	// models.ErrMFAAlreadyEnabled if two-factor authentication is on
+	StartMFA(ctx context.Context, userID int, secret string) error
+	// EnableMFA enables the pending secret with counter as the last used time
+	// step and replaces the recovery codes, models.ErrMFANotEnrolled if
+	// nothing is pending
+	EnableMFA(ctx context.Context, userID int, counter int64, recoveryHashes []string) error
+	// DisableMFA removes the user's secret and recovery codes
+	DisableMFA(ctx context.Context, userID int) error
+	// UseTOTPCounter records counter as the last used time step, false if it
+	// or a later one already was used
+	UseTOTPCounter(ctx context.Context, userID int, counter int64) (bool, error)
+	// UseRecoveryCode marks the recovery code with the given hash used, false
+	// if the user has no such unused code
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+}
+
+// APIKeyStore API keys of users
+type APIKeyStore interface {
+	// CreateAPIKey stores a new key and sets its ID and CreatedAt,
+	// models.ErrConflict if its prefix is taken
+	CreateAPIKey(ctx context.Context, k *models.APIKey) error
+	// APIKeyByPrefix returns the key with the given prefix, revoked or not,
+	// models.ErrInvalidAPIKey if none
+	APIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// APIKeys returns the user's keys including revoked ones, newest first
+	APIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	// RevokeAPIKey revokes the user's key, revoking it again is a no-op,
+	// models.ErrAPIKeyNotFound if the user has no such key
+	RevokeAPIKey(ctx context.Context, userID, id int) error
+	// TouchAPIKey records that the key was used at the given time
+	TouchAPIKey(ctx context.Context, id int, at time.Time) error
+}
+
+// WalletStore wallet and ledger persistence
+type WalletStore interface {
+	// WithinTx runs fn as one unit of work. Changes made through tx are
+	// committed when fn returns nil and discarded otherwise.
+	WithinTx(ctx context.Context, fn func(tx WalletTx) error) error
+	// Balance returns the user's balance, models.ErrUserNotFound if none
+	Balance(ctx context.Context, userID int) (models.Money, error)
+	// Transactions returns the page of the user's history described by q
+	Transactions(ctx context.Context, userID int, q models.TransactionQuery) ([]models.Transaction, error)
+	// CountTransactions returns how many of the user's transactions match f
+	CountTransactions(ctx context.Context, userID int, f models.TransactionFilter) (int, error)
+	// Transaction returns the user's transaction with its counterparty and the
+	// balance after it, models.ErrTransactionNotFound if the user has none with id
+	Transaction(ctx context.Context, userID, id int) (*models.TransactionDetail, error)
+	// VerifyLedger reports unbalanced journal entries and wallets whose
+	// balance differs from the sum of their postings
+	VerifyLedger(ctx context.Context) (*models.LedgerReport, error)
+	// VerifyHistory replays every wallet's transaction history and reports
+	// the wallets it does not agree with, ordered by user id
+	VerifyHistory(ctx context.Context) (*models.HistoryReport, error)
+	// Adjustments returns up to limit manual adjustments, newest first, of the
+	// user or of every user if userID is 0
+	Adjustments(ctx context.Context, userID, limit int) ([]models.Adjustment, error)
+	// Postings returns up to limit postings to the user's ledger account,
+	// newest first, models.ErrUserNotFound if the user has no account
+	Postings(ctx context.Context, userID, limit int) ([]models.WalletPosting, error)
+}
+
+// WalletTx wallet operations inside a unit of work
+type WalletTx interface {
+	// LockBalances locks the wallets until the unit of work ends and returns
+	// their balances, models.ErrUserNotFound if any of them does not exist
+	LockBalances(ctx context.Context, userIDs ...int) (map[int]models.Money, error)
+	// AdjustBalance adds delta, which may be negative, to the user's balance
+	// and returns the new balance
+	AdjustBalance(ctx context.Context, userID int, delta models.Money) (models.Money, error)
+	// PostEntry records a balanced journal entry and returns its id
+	PostEntry(ctx context.Context, entry models.JournalEntry) (int, error)
+	// RecordTransactions appends rows to the users' transaction history
+	RecordTransactions(ctx context.Context, txs ...models.Transaction) error
+	// RecordAdjustment records a manual adjustment and sets its ID and CreatedAt
+	RecordAdjustment(ctx context.Context, a *models.Adjustment) error
+}
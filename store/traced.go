@@ -0,0 +1,382 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"gin-wallet2/models"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer looked up on every call, so stores wrapped before tracing is set up
+// still export spans
+func tracer() trace.Tracer {
+	return otel.Tracer("gin-wallet2/store")
+}
+
+// TraceUsers wraps s so every call is a child span of the request span
+func TraceUsers(s UserStore) UserStore {
+	return &tracedUsers{s}
+}
+
+// TraceTokens wraps s so every call is a child span of the request span
+func TraceTokens(s TokenStore) TokenStore {
+	return &tracedTokens{s}
+}
+
+// TraceWallets wraps s so every call is a child span of the request span, the
+// unit of work is one span with a child per statement inside it
+func TraceWallets(s WalletStore) WalletStore {
+	return &tracedWallets{s}
+}
+
+// TraceLogins wraps s so every call is a child span of the request span
+func TraceLogins(s LoginStore) LoginStore {
+	return &tracedLogins{s}
+}
+
+// TraceMFA wraps s so every call is a child span of the request span
+func TraceMFA(s MFAStore) MFAStore {
+	return &tracedMFA{s}
+}
+
+// TraceAPIKeys wraps s so every call is a child span of the request span
+func TraceAPIKeys(s APIKeyStore) APIKeyStore {
+	return &tracedAPIKeys{s}
+}
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "store."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("db.operation.name", name))...))
+}
+
+// endSpan ends span, marking it failed for unexpected errors. Domain errors
+// such as ErrInsufficientFunds are outcomes, not failures, and only recorded
+// as their code.
+func endSpan(span trace.Span, err error) {
+	var domainErr *models.Error
+	if errors.As(err, &domainErr) {
+		span.SetAttributes(attribute.String("error.code", domainErr.Code))
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func userAttr(userID int) attribute.KeyValue {
+	return attribute.Int("user.id", userID)
+}
+
+type tracedUsers struct {
+	next UserStore
+}
+
+func (s *tracedUsers) CreateUser(ctx context.Context, name, passwordHash string) (id int, err error) {
+	ctx, span := startSpan(ctx, "CreateUser")
+	defer func() { span.SetAttributes(userAttr(id)); endSpan(span, err) }()
+	return s.next.CreateUser(ctx, name, passwordHash)
+}
+
+func (s *tracedUsers) UserByName(ctx context.Context, name string) (u *models.User, err error) {
+	ctx, span := startSpan(ctx, "UserByName")
+	defer func() { endSpan(span, err) }()
+	return s.next.UserByName(ctx, name)
+}
+
+func (s *tracedUsers) UserByID(ctx context.Context, id int) (u *models.User, err error) {
+	ctx, span := startSpan(ctx, "UserByID", userAttr(id))
+	defer func() { endSpan(span, err) }()
+	return s.next.UserByID(ctx, id)
+}
+
+func (s *tracedUsers) UpdatePassword(ctx context.Context, id int, passwordHash string) (err error) {
+	ctx, span := startSpan(ctx, "UpdatePassword", userAttr(id))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdatePassword(ctx, id, passwordHash)
+}
+
+func (s *tracedUsers) UpdateProfile(ctx context.Context, id int, p models.Profile) (err error) {
+	ctx, span := startSpan(ctx, "UpdateProfile", userAttr(id))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateProfile(ctx, id, p)
+}
+
+func (s *tracedUsers) UpdateRole(ctx context.Context, id int, role string) (err error) {
+	ctx, span := startSpan(ctx, "UpdateRole", userAttr(id))
+	defer func() { endSpan(span, err) }()
+	return s.next.UpdateRole(ctx, id, role)
+}
+
+func (s *tracedUsers) SearchUsers(ctx context.Context, query string, limit int) (users []models.User, err error) {
+	ctx, span := startSpan(ctx, "SearchUsers")
+	defer func() { endSpan(span, err) }()
+	return s.next.SearchUsers(ctx, query, limit)
+}
+
+type tracedTokens struct {
+	next TokenStore
+}
+
+func (s *tracedTokens) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) (err error) {
+	ctx, span := startSpan(ctx, "CreateRefreshToken", userAttr(t.UserID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateRefreshToken(ctx, t)
+}
+
+func (s *tracedTokens) RefreshTokenByHash(ctx context.Context, hash string) (t *models.RefreshToken, err error) {
+	ctx, span := startSpan(ctx, "RefreshTokenByHash")
+	defer func() { endSpan(span, err) }()
+	return s.next.RefreshTokenByHash(ctx, hash)
+}
+
+func (s *tracedTokens) MarkRefreshTokenUsed(ctx context.Context, id int) (ok bool, err error) {
+	ctx, span := startSpan(ctx, "MarkRefreshTokenUsed")
+	defer func() { endSpan(span, err) }()
+	return s.next.MarkRefreshTokenUsed(ctx, id)
+}
+
+func (s *tracedTokens) RevokeFamily(ctx context.Context, familyID string) (err error) {
+	ctx, span := startSpan(ctx, "RevokeFamily")
+	defer func() { endSpan(span, err) }()
+	return s.next.RevokeFamily(ctx, familyID)
+}
+
+func (s *tracedTokens) RevokeUserTokens(ctx context.Context, userID int) (err error) {
+	ctx, span := startSpan(ctx, "RevokeUserTokens", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.RevokeUserTokens(ctx, userID)
+}
+
+func (s *tracedTokens) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) (err error) {
+	ctx, span := startSpan(ctx, "RevokeAccessToken")
+	defer func() { endSpan(span, err) }()
+	return s.next.RevokeAccessToken(ctx, jti, expiresAt)
+}
+
+func (s *tracedTokens) IsAccessTokenRevoked(ctx context.Context, jti string) (revoked bool, err error) {
+	ctx, span := startSpan(ctx, "IsAccessTokenRevoked")
+	defer func() { endSpan(span, err) }()
+	return s.next.IsAccessTokenRevoked(ctx, jti)
+}
+
+type tracedWallets struct {
+	next WalletStore
+}
+
+func (s *tracedWallets) WithinTx(ctx context.Context, fn func(tx WalletTx) error) (err error) {
+	ctx, span := startSpan(ctx, "WithinTx")
+	defer func() { endSpan(span, err) }()
+	// fn keeps using the handler's context, tracedTx parents its spans to the
+	// transaction span instead
+	return s.next.WithinTx(ctx, func(tx WalletTx) error {
+		return fn(&tracedTx{next: tx, span: span})
+	})
+}
+
+func (s *tracedWallets) Balance(ctx context.Context, userID int) (b models.Money, err error) {
+	ctx, span := startSpan(ctx, "Balance", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Balance(ctx, userID)
+}
+
+func (s *tracedWallets) Transactions(ctx context.Context, userID int, q models.TransactionQuery) (txs []models.Transaction, err error) {
+	ctx, span := startSpan(ctx, "Transactions", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Transactions(ctx, userID, q)
+}
+
+func (s *tracedWallets) CountTransactions(ctx context.Context, userID int, f models.TransactionFilter) (n int, err error) {
+	ctx, span := startSpan(ctx, "CountTransactions", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CountTransactions(ctx, userID, f)
+}
+
+func (s *tracedWallets) Transaction(ctx context.Context, userID, id int) (t *models.TransactionDetail, err error) {
+	ctx, span := startSpan(ctx, "Transaction", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Transaction(ctx, userID, id)
+}
+
+func (s *tracedWallets) VerifyLedger(ctx context.Context) (r *models.LedgerReport, err error) {
+	ctx, span := startSpan(ctx, "VerifyLedger")
+	defer func() { endSpan(span, err) }()
+	return s.next.VerifyLedger(ctx)
+}
+
+func (s *tracedWallets) VerifyHistory(ctx context.Context) (r *models.HistoryReport, err error) {
+	ctx, span := startSpan(ctx, "VerifyHistory")
+	defer func() { endSpan(span, err) }()
+	return s.next.VerifyHistory(ctx)
+}
+
+func (s *tracedWallets) Adjustments(ctx context.Context, userID, limit int) (a []models.Adjustment, err error) {
+	ctx, span := startSpan(ctx, "Adjustments", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Adjustments(ctx, userID, limit)
+}
+
+func (s *tracedWallets) Postings(ctx context.Context, userID, limit int) (p []models.WalletPosting, err error) {
+	ctx, span := startSpan(ctx, "Postings", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.Postings(ctx, userID, limit)
+}
+
+type tracedTx struct {
+	next WalletTx
+	span trace.Span
+}
+
+// child starts a span under the transaction span, keeping ctx's cancellation
+func (t *tracedTx) child(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(trace.ContextWithSpan(ctx, t.span), name, attrs...)
+}
+
+func (t *tracedTx) LockBalances(ctx context.Context, userIDs ...int) (b map[int]models.Money, err error) {
+	ctx, span := t.child(ctx, "LockBalances", attribute.IntSlice("user.ids", userIDs))
+	defer func() { endSpan(span, err) }()
+	return t.next.LockBalances(ctx, userIDs...)
+}
+
+func (t *tracedTx) AdjustBalance(ctx context.Context, userID int, delta models.Money) (b models.Money, err error) {
+	ctx, span := t.child(ctx, "AdjustBalance", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return t.next.AdjustBalance(ctx, userID, delta)
+}
+
+func (t *tracedTx) PostEntry(ctx context.Context, entry models.JournalEntry) (id int, err error) {
+	ctx, span := t.child(ctx, "PostEntry", attribute.String("wallet.operation", entry.Kind))
+	defer func() { endSpan(span, err) }()
+	return t.next.PostEntry(ctx, entry)
+}
+
+func (t *tracedTx) RecordTransactions(ctx context.Context, txs ...models.Transaction) (err error) {
+	ctx, span := t.child(ctx, "RecordTransactions")
+	defer func() { endSpan(span, err) }()
+	return t.next.RecordTransactions(ctx, txs...)
+}
+
+func (t *tracedTx) RecordAdjustment(ctx context.Context, a *models.Adjustment) (err error) {
+	ctx, span := t.child(ctx, "RecordAdjustment", userAttr(a.UserID), attribute.Int("admin.id", a.AdminID))
+	defer func() { endSpan(span, err) }()
+	return t.next.RecordAdjustment(ctx, a)
+}
+
+type tracedLogins struct {
+	next LoginStore
+}
+
+func (s *tracedLogins) LoginFailures(ctx context.Context, key string) (f *models.LoginFailures, err error) {
+	ctx, span := startSpan(ctx, "LoginFailures")
+	defer func() { endSpan(span, err) }()
+	return s.next.LoginFailures(ctx, key)
+}
+
+func (s *tracedLogins) AddLoginFailure(ctx context.Context, key string, since time.Time) (n int, err error) {
+	ctx, span := startSpan(ctx, "AddLoginFailure")
+	defer func() { endSpan(span, err) }()
+	return s.next.AddLoginFailure(ctx, key, since)
+}
+
+func (s *tracedLogins) LockLogin(ctx context.Context, key string, until time.Time) (err error) {
+	ctx, span := startSpan(ctx, "LockLogin")
+	defer func() { endSpan(span, err) }()
+	return s.next.LockLogin(ctx, key, until)
+}
+
+func (s *tracedLogins) ResetLoginFailures(ctx context.Context, key string) (err error) {
+	ctx, span := startSpan(ctx, "ResetLoginFailures")
+	defer func() { endSpan(span, err) }()
+	return s.next.ResetLoginFailures(ctx, key)
+}
+
+func (s *tracedLogins) RecordLoginAttempt(ctx context.Context, a *models.LoginAttempt) (err error) {
+	ctx, span := startSpan(ctx, "RecordLoginAttempt", userAttr(a.UserID))
+	defer func() { endSpan(span, err) }()
+	return s.next.RecordLoginAttempt(ctx, a)
+}
+
+func (s *tracedLogins) LoginAttempts(ctx context.Context, userID, limit int) (a []models.LoginAttempt, err error) {
+	ctx, span := startSpan(ctx, "LoginAttempts", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.LoginAttempts(ctx, userID, limit)
+}
+
+type tracedMFA struct {
+	next MFAStore
+}
+
+func (s *tracedMFA) MFA(ctx context.Context, userID int) (m *models.MFA, err error) {
+	ctx, span := startSpan(ctx, "MFA", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.MFA(ctx, userID)
+}
+
+func (s *tracedMFA) StartMFA(ctx context.Context, userID int, secret string) (err error) {
+	ctx, span := startSpan(ctx, "StartMFA", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.StartMFA(ctx, userID, secret)
+}
+
+func (s *tracedMFA) EnableMFA(ctx context.Context, userID int, counter int64, recoveryHashes []string) (err error) {
+	ctx, span := startSpan(ctx, "EnableMFA", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.EnableMFA(ctx, userID, counter, recoveryHashes)
+}
+
+func (s *tracedMFA) DisableMFA(ctx context.Context, userID int) (err error) {
+	ctx, span := startSpan(ctx, "DisableMFA", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.DisableMFA(ctx, userID)
+}
+
+func (s *tracedMFA) UseTOTPCounter(ctx context.Context, userID int, counter int64) (ok bool, err error) {
+	ctx, span := startSpan(ctx, "UseTOTPCounter", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.UseTOTPCounter(ctx, userID, counter)
+}
+
+func (s *tracedMFA) UseRecoveryCode(ctx context.Context, userID int, hash string) (ok bool, err error) {
+	ctx, span := startSpan(ctx, "UseRecoveryCode", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.UseRecoveryCode(ctx, userID, hash)
+}
+
+type tracedAPIKeys struct {
+	next APIKeyStore
+}
+
+func (s *tracedAPIKeys) CreateAPIKey(ctx context.Context, k *models.APIKey) (err error) {
+	ctx, span := startSpan(ctx, "CreateAPIKey", userAttr(k.UserID))
+	defer func() { endSpan(span, err) }()
+	return s.next.CreateAPIKey(ctx, k)
+}
+
+func (s *tracedAPIKeys) APIKeyByPrefix(ctx context.Context, prefix string) (k *models.APIKey, err error) {
+	ctx, span := startSpan(ctx, "APIKeyByPrefix")
+	defer func() { endSpan(span, err) }()
+	return s.next.APIKeyByPrefix(ctx, prefix)
+}
+
+func (s *tracedAPIKeys) APIKeys(ctx context.Context, userID int) (k []models.APIKey, err error) {
+	ctx, span := startSpan(ctx, "APIKeys", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.APIKeys(ctx, userID)
+}
+
+func (s *tracedAPIKeys) RevokeAPIKey(ctx context.Context, userID, id int) (err error) {
+	ctx, span := startSpan(ctx, "RevokeAPIKey", userAttr(userID))
+	defer func() { endSpan(span, err) }()
+	return s.next.RevokeAPIKey(ctx, userID, id)
+}
+
+func (s *tracedAPIKeys) TouchAPIKey(ctx context.Context, id int, at time.Time) (err error) {
+	ctx, span := startSpan(ctx, "TouchAPIKey")
+	defer func() { endSpan(span, err) }()
+	return s.next.TouchAPIKey(ctx, id, at)
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a global provider recording every span for this test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		_ = tp.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTraceWallets(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx := context.Background()
+	mem := NewMemory()
+	id, err := mem.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	s := TraceWallets(mem)
+
+	ctx, parent := otel.Tracer("test").Start(ctx, "POST /wallet/withdraw")
+	err = s.WithinTx(ctx, func(tx WalletTx) error {
+		if _, err := tx.LockBalances(ctx, id); err != nil {
+			return err
+		}
+		_, err := tx.AdjustBalance(ctx, id, models.MustParseMoney("-10"))
+		return err
+	})
+	parent.End()
+	assert.ErrorIs(t, err, models.ErrInsufficientFunds)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	lock, adjust, unit := spans[0], spans[1], spans[2]
+	assert.Equal(t, "store.LockBalances", lock.Name())
+	assert.Equal(t, "store.AdjustBalance", adjust.Name())
+	assert.Equal(t, "store.WithinTx", unit.Name())
+
+	// statements are children of the unit of work, which is a child of the request
+	assert.Equal(t, unit.SpanContext().SpanID(), lock.Parent().SpanID())
+	assert.Equal(t, unit.SpanContext().SpanID(), adjust.Parent().SpanID())
+	assert.Equal(t, parent.SpanContext().SpanID(), unit.Parent().SpanID())
+
+	// a domain error is an outcome, not a failed span
+	assert.Equal(t, int64(id), spanAttr(adjust, "user.id").AsInt64())
+	assert.Equal(t, models.CodeInsufficientFunds, spanAttr(adjust, "error.code").AsString())
+	assert.Equal(t, codes.Unset, adjust.Status().Code)
+}
+
+func TestTraceUsers(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx := context.Background()
+	s := TraceUsers(&failingUsers{Memory: NewMemory()})
+
+	_, err := s.UserByName(ctx, "nobody")
+	assert.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "store.UserByName", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "UserByName", spanAttr(spans[0], "db.operation.name").AsString())
+}
+
+// failingUsers fails lookups like a broken connection would
+type failingUsers struct {
+	*Memory
+}
+
+func (s *failingUsers) UserByName(context.Context, string) (*models.User, error) {
+	return nil, context.DeadlineExceeded
+}
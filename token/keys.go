@@ -0,0 +1,274 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms. HS256 keys are shared secrets: every holder can mint
+// tokens, so they are never published.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+// minRSABits smallest accepted RSA modulus
+const minRSABits = 2048
+
+// hmacKeyID kid of the HS256 key
+const hmacKeyID = "hs256"
+
+// Key a signing or verification key, ID is its kid header. Asymmetric keys are
+// identified by their RFC 7638 thumbprint so every instance derives the same
+// kid from the same key file.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	// private is ed25519.PrivateKey, *rsa.PrivateKey or the HS256 secret, nil
+	// for verification only keys
+	private crypto.PrivateKey
+	// public is ed25519.PublicKey, *rsa.PublicKey or the HS256 secret
+	public crypto.PublicKey
+}
+
+// CanSign reports whether the key holds a private key
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgRS256:
+		return jwt.SigningMethodRS256
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// KeySet signs with one key and verifies tokens signed with any of its keys.
+// During a rotation the previous and the next key stay in the set for
+// verification while only one of them signs.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet key set signing with signing and also accepting verification
+func NewKeySet(signing *Key, verification ...*Key) (*KeySet, error) {
+	if signing == nil || !signing.CanSign() {
+		return nil, errors.New("token: signing key must be a private key")
+	}
+	s := &KeySet{signing: signing, keys: map[string]*Key{signing.ID: signing}}
+	for _, k := range verification {
+		if k.Algorithm == AlgHS256 {
+			return nil, errors.New("token: HS256 keys cannot be mixed with asymmetric keys")
+		}
+		if _, ok := s.keys[k.ID]; !ok {
+			s.keys[k.ID] = k
+		}
+	}
+	return s, nil
+}
+
+// HMACKeys key set signing and verifying with an HS256 secret
+func HMACKeys(secret []byte) *KeySet {
+	k := &Key{ID: hmacKeyID, Algorithm: AlgHS256, private: secret, public: secret}
+	return &KeySet{signing: k, keys: map[string]*Key{k.ID: k}}
+}
+
+// LoadKeys reads the PEM signing key and any extra PEM verification keys,
+// public or private
+func LoadKeys(signingFile string, verificationFiles ...string) (*KeySet, error) {
+	signing, err := loadKey(signingFile)
+	if err != nil {
+		return nil, err
+	}
+	verification := make([]*Key, 0, len(verificationFiles))
+	for _, file := range verificationFiles {
+		k, err := loadKey(file)
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, k)
+	}
+	return NewKeySet(signing, verification...)
+}
+
+func loadKey(file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	k, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("token: %s: %w", file, err)
+	}
+	return k, nil
+}
+
+// ParseKey parses a PEM encoded PKCS#8 or PKCS#1 private key or PKIX public
+// key, RSA of at least 2048 bits or Ed25519
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Key{}
+	switch key := parsed.(type) {
+	case ed25519.PrivateKey:
+		k.Algorithm, k.private, k.public = AlgEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Algorithm, k.public = AlgEdDSA, key
+	case *rsa.PrivateKey:
+		k.Algorithm, k.private, k.public = AlgRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Algorithm, k.public = AlgRS256, key
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, want RSA or Ed25519", parsed)
+	}
+	if pub, ok := k.public.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key has %d bits, want at least %d", pub.N.BitLen(), minRSABits)
+	}
+	k.ID = thumbprint(k.jwk())
+	return k, nil
+}
+
+// GenerateKey new private key for alg as PKCS#8 PEM, with its public key as
+// PKIX PEM and its kid
+func GenerateKey(alg string) (privatePEM, publicPEM []byte, kid string, err error) {
+	var private crypto.Signer
+	switch alg {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, nil, "", fmt.Errorf("token: unsupported algorithm %q, want %s or %s", alg, AlgEdDSA, AlgRS256)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	k, err := ParseKey(privatePEM)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return privatePEM, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), k.ID, nil
+}
+
+// Signing key new tokens are signed with
+func (s *KeySet) Signing() *Key {
+	return s.signing
+}
+
+// Key verification key with the given kid
+func (s *KeySet) Key(kid string) (*Key, bool) {
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// JWK public key in RFC 7517 form
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS JSON Web Key Set served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS public verification keys ordered by kid, HS256 keys are left out
+func (s *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		if k.Algorithm == AlgHS256 {
+			continue
+		}
+		jwk := k.jwk()
+		jwk.KeyID, jwk.Use, jwk.Algorithm = k.ID, "sig", k.Algorithm
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// jwk required members of the public key
+func (k *Key) jwk() JWK {
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		return JWK{KeyType: "OKP", Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)}
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType: "RSA",
+			N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint RFC 7638 SHA-256 thumbprint over the required members of jwk,
+// a map because json.Marshal sorts map keys as the RFC requires
+func thumbprint(jwk JWK) string {
+	members := map[string]string{"kty": jwk.KeyType}
+	switch jwk.KeyType {
+	case "RSA":
+		members["n"], members["e"] = jwk.N, jwk.E
+	case "OKP":
+		members["crv"], members["x"] = jwk.Curve, jwk.X
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
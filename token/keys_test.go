@@ -0,0 +1,193 @@
+package token
+
+import (
+	"context"
+	"encoding/base64"
+	"gin-wallet2/models"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey generates a key for alg and writes its private and public PEM files to dir
+func writeKey(t *testing.T, dir, alg string) (privateFile, publicFile, kid string) {
+	t.Helper()
+	private, public, kid, err := GenerateKey(alg)
+	require.NoError(t, err)
+	privateFile = filepath.Join(dir, kid+".pem")
+	publicFile = filepath.Join(dir, kid+".pub.pem")
+	require.NoError(t, os.WriteFile(privateFile, private, 0o600))
+	require.NoError(t, os.WriteFile(publicFile, public, 0o644))
+	return privateFile, publicFile, kid
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1
+	jwk := JWK{
+		KeyType: "RSA",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-" +
+			"5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-" +
+			"bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E: "AQAB",
+	}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint(jwk))
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	edPrivate, edPublic, edKid := writeKey(t, dir, AlgEdDSA)
+	rsaPrivate, rsaPublic, rsaKid := writeKey(t, dir, AlgRS256)
+
+	keys, err := LoadKeys(edPrivate, rsaPublic, edPublic)
+	require.NoError(t, err)
+	assert.Equal(t, edKid, keys.Signing().ID)
+	assert.Equal(t, AlgEdDSA, keys.Signing().Algorithm)
+	rsaKey, ok := keys.Key(rsaKid)
+	require.True(t, ok)
+	assert.False(t, rsaKey.CanSign())
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	for _, jwk := range jwks.Keys {
+		assert.Equal(t, "sig", jwk.Use)
+		switch jwk.KeyID {
+		case edKid:
+			assert.Equal(t, JWK{KeyType: "OKP", KeyID: edKid, Use: "sig", Algorithm: AlgEdDSA, Curve: "Ed25519", X: jwk.X}, jwk)
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			assert.NoError(t, err)
+			assert.Len(t, x, 32)
+		case rsaKid:
+			assert.Equal(t, "RSA", jwk.KeyType)
+			assert.Equal(t, AlgRS256, jwk.Algorithm)
+			assert.Equal(t, "AQAB", jwk.E)
+		default:
+			t.Errorf("unexpected kid %q", jwk.KeyID)
+		}
+	}
+
+	_, err = LoadKeys(rsaPublic)
+	assert.ErrorContains(t, err, "signing key must be a private key")
+	_, err = LoadKeys(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "garbage.pem"), []byte("not a key"), 0o600))
+	_, err = LoadKeys(rsaPrivate, filepath.Join(dir, "garbage.pem"))
+	assert.ErrorContains(t, err, "no PEM block")
+
+	assert.Empty(t, HMACKeys([]byte("secret")).JWKS().Keys)
+	_, _, _, err = GenerateKey("HS512")
+	assert.Error(t, err)
+}
+
+func TestManager_KeyRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldPrivate, oldPublic, oldKid := writeKey(t, dir, AlgEdDSA)
+	newPrivate, newPublic, newKid := writeKey(t, dir, AlgRS256)
+
+	m, user, _ := newTestManager(t)
+	var err error
+
+	// 1. the next key is published for verification while the old one signs
+	m.Keys, err = LoadKeys(oldPrivate, newPublic)
+	require.NoError(t, err)
+	old, err := m.Issue(ctx, user)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(old.AccessToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, oldKid, parsed.Header["kid"])
+	assert.Equal(t, AlgEdDSA, parsed.Method.Alg())
+
+	// 2. the next key signs, tokens of the old one stay valid
+	m.Keys, err = LoadKeys(newPrivate, oldPublic)
+	require.NoError(t, err)
+	next, err := m.Issue(ctx, user)
+	require.NoError(t, err)
+	parsed, _, _ = jwt.NewParser().ParseUnverified(next.AccessToken, jwt.MapClaims{})
+	assert.Equal(t, newKid, parsed.Header["kid"])
+	for _, access := range []string{old.AccessToken, next.AccessToken} {
+		_, err := m.Verify(ctx, access)
+		assert.NoError(t, err)
+	}
+
+	// 3. once old tokens expired the old key is dropped
+	m.Keys, err = LoadKeys(newPrivate)
+	require.NoError(t, err)
+	_, err = m.Verify(ctx, old.AccessToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+	_, err = m.Verify(ctx, next.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestManager_RegisteredClaims(t *testing.T) {
+	ctx := context.Background()
+	m, user, now := newTestManager(t)
+	dir := t.TempDir()
+	private, publicFile, kid := writeKey(t, dir, AlgRS256)
+	var err error
+	m.Keys, err = LoadKeys(private)
+	require.NoError(t, err)
+	m.Issuer, m.Audience = "gin-wallet", "wallet-api"
+
+	pair, err := m.Issue(ctx, user)
+	require.NoError(t, err)
+	_, err = m.Verify(ctx, pair.AccessToken)
+	assert.NoError(t, err)
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(m.Keys.Signing().private)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"userID": user.ID, "jti": "x", "iss": "gin-wallet", "aud": "wallet-api",
+			"nbf": now.Unix(), "exp": now.Add(time.Minute).Unix(),
+		}
+	}
+	_, err = m.Verify(ctx, sign(valid()))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func(c jwt.MapClaims)
+	}{
+		{name: "other issuer", modify: func(c jwt.MapClaims) { c["iss"] = "someone-else" }},
+		{name: "no issuer", modify: func(c jwt.MapClaims) { delete(c, "iss") }},
+		{name: "other audience", modify: func(c jwt.MapClaims) { c["aud"] = []string{"billing"} }},
+		{name: "no audience", modify: func(c jwt.MapClaims) { delete(c, "aud") }},
+		{name: "not yet valid", modify: func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+			_, err := m.Verify(ctx, sign(claims))
+			assert.ErrorIs(t, err, models.ErrInvalidToken)
+		})
+	}
+
+	t.Run("unknown kid", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, valid())
+		token.Header["kid"] = "other"
+		signed, _ := token.SignedString(m.Keys.Signing().private)
+		_, err := m.Verify(ctx, signed)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+	})
+
+	t.Run("public key used as HMAC secret", func(t *testing.T) {
+		public, err := os.ReadFile(publicFile)
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, valid())
+		token.Header["kid"] = kid
+		signed, _ := token.SignedString(public)
+		_, err = m.Verify(ctx, signed)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+	})
+}
@@ -0,0 +1,306 @@
+// Package token issues short-lived access tokens and rotating refresh tokens,
+// and revokes them on logout or refresh token reuse
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultChallengeTTL how long a two-factor login challenge can be answered
+const defaultChallengeTTL = 5 * time.Minute
+
+// clockSkew leeway for exp and nbf, for services verifying tokens on hosts
+// whose clocks drift a little
+const clockSkew = 30 * time.Second
+
+// challengeType typ claim of challenge tokens, access tokens have none
+const challengeType = "mfa_challenge"
+
+// Manager issues, verifies and revokes tokens. Access tokens are JWTs signed
+// with the signing key of Keys, named by their kid header, and carry a jti
+// and the session (refresh token family) id; refresh tokens are random
+// strings stored hashed in Tokens. Challenge tokens stand for a correct
+// password while a two-factor login waits for its code. Non-empty Issuer and
+// Audience are set as iss and aud and required when verifying.
+type Manager struct {
+	Keys         *KeySet
+	Issuer       string
+	Audience     string
+	AccessTTL    time.Duration
+	RefreshTTL   time.Duration
+	ChallengeTTL time.Duration
+	Tokens       store.TokenStore
+	Users        store.UserStore
+
+	now func() time.Time
+}
+
+// NewManager new token manager
+func NewManager(keys *KeySet, accessTTL, refreshTTL time.Duration, tokens store.TokenStore, users store.UserStore) *Manager {
+	return &Manager{
+		Keys:         keys,
+		AccessTTL:    accessTTL,
+		RefreshTTL:   refreshTTL,
+		ChallengeTTL: defaultChallengeTTL,
+		Tokens:       tokens,
+		Users:        users,
+		now:          time.Now,
+	}
+}
+
+// Pair tokens returned by login and refresh
+type Pair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Claims verified access token claims
+type Claims struct {
+	UserID    int
+	Role      string
+	ID        string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// Issue starts a new session for user
+func (m *Manager) Issue(ctx context.Context, user *models.User) (*Pair, error) {
+	familyID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	return m.issue(ctx, user, familyID)
+}
+
+// Refresh rotates refreshToken and returns a new pair in the same session.
+// Presenting a token that was already rotated revokes the whole session.
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (*Pair, error) {
+	rec, err := m.Tokens.RefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if rec.RevokedAt != nil || !rec.ExpiresAt.After(m.now()) {
+		return nil, models.ErrInvalidToken
+	}
+	if rec.UsedAt != nil {
+		return nil, m.reused(ctx, rec.FamilyID)
+	}
+
+	// a concurrent refresh with the same token loses here
+	ok, err := m.Tokens.MarkRefreshTokenUsed(ctx, rec.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, m.reused(ctx, rec.FamilyID)
+	}
+
+	user, err := m.Users.UserByID(ctx, rec.UserID)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return nil, models.ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	return m.issue(ctx, user, rec.FamilyID)
+}
+
+// Verify checks the signature, expiry and revocation of an access token
+func (m *Manager) Verify(ctx context.Context, accessToken string) (*Claims, error) {
+	return m.verify(ctx, accessToken, "")
+}
+
+// IssueChallenge returns a token for the second step of a two-factor login
+// of user, valid for ChallengeTTL. It is not accepted as an access token.
+func (m *Manager) IssueChallenge(user *models.User) (string, error) {
+	now := m.now()
+	jti, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	return m.sign(now, jwt.MapClaims{
+		"userID": user.ID,
+		"typ":    challengeType,
+		"jti":    jti,
+		"exp":    jwt.NewNumericDate(now.Add(m.ChallengeTTL)),
+	})
+}
+
+// VerifyChallenge checks a challenge token like Verify does an access token.
+// Revoke it with Logout once answered so it cannot be used twice.
+func (m *Manager) VerifyChallenge(ctx context.Context, challenge string) (*Claims, error) {
+	return m.verify(ctx, challenge, challengeType)
+}
+
+// verify checks a token whose typ claim must be typ
+func (m *Manager) verify(ctx context.Context, tokenString, typ string) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired(), jwt.WithLeeway(clockSkew), jwt.WithTimeFunc(m.now)}
+	if m.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(m.Issuer))
+	}
+	if m.Audience != "" {
+		opts = append(opts, jwt.WithAudience(m.Audience))
+	}
+	// the kid picks the key and the key the only algorithm accepted, so an
+	// RS256 public key can never be used as an HS256 secret
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.Keys.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.public, nil
+	}, opts...)
+	if err != nil || !token.Valid {
+		return nil, models.ErrInvalidToken
+	}
+
+	mc, _ := token.Claims.(jwt.MapClaims)
+	userID, _ := mc["userID"].(float64)
+	role, _ := mc["role"].(string)
+	jti, _ := mc["jti"].(string)
+	sid, _ := mc["sid"].(string)
+	tokenType, _ := mc["typ"].(string)
+	exp, _ := mc.GetExpirationTime()
+	// tokens without a jti cannot be revoked and are not accepted
+	if userID <= 0 || jti == "" || exp == nil || tokenType != typ {
+		return nil, models.ErrInvalidToken
+	}
+
+	revoked, err := m.Tokens.IsAccessTokenRevoked(ctx, jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, models.ErrInvalidToken
+	}
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	return &Claims{UserID: int(userID), Role: role, ID: jti, SessionID: sid, ExpiresAt: exp.Time}, nil
+}
+
+// Logout revokes the session of claims, including the access token itself
+func (m *Manager) Logout(ctx context.Context, claims *Claims) error {
+	if claims.SessionID != "" {
+		if err := m.Tokens.RevokeFamily(ctx, claims.SessionID); err != nil {
+			return err
+		}
+	}
+	return m.Tokens.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt)
+}
+
+// LogoutAll revokes every session of the user of claims
+func (m *Manager) LogoutAll(ctx context.Context, claims *Claims) error {
+	if err := m.Tokens.RevokeUserTokens(ctx, claims.UserID); err != nil {
+		return err
+	}
+	return m.Tokens.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt)
+}
+
+// userRole role claim of user, users created before roles existed are plain users
+func userRole(user *models.User) string {
+	if user.Role == "" {
+		return models.RoleUser
+	}
+	return user.Role
+}
+
+// reused revokes the family of a refresh token presented twice
+func (m *Manager) reused(ctx context.Context, familyID string) error {
+	if err := m.Tokens.RevokeFamily(ctx, familyID); err != nil {
+		return err
+	}
+	return models.ErrTokenReused
+}
+
+func (m *Manager) issue(ctx context.Context, user *models.User, familyID string) (*Pair, error) {
+	now := m.now()
+	jti, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	accessExpiresAt := now.Add(m.AccessTTL)
+	access, err := m.sign(now, jwt.MapClaims{
+		"userID": user.ID,
+		"role":   userRole(user),
+		"jti":    jti,
+		"sid":    familyID,
+		"exp":    jwt.NewNumericDate(accessExpiresAt),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+
+	err = m.Tokens.CreateRefreshToken(ctx, &models.RefreshToken{
+		TokenHash:       hashToken(refresh),
+		FamilyID:        familyID,
+		UserID:          user.ID,
+		AccessTokenID:   jti,
+		AccessExpiresAt: accessExpiresAt,
+		ExpiresAt:       now.Add(m.RefreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(m.AccessTTL / time.Second),
+	}, nil
+}
+
+// sign adds iat, nbf, iss and aud to claims and signs them with the signing
+// key, named in the kid header
+func (m *Manager) sign(now time.Time, claims jwt.MapClaims) (string, error) {
+	claims["iat"] = jwt.NewNumericDate(now)
+	claims["nbf"] = jwt.NewNumericDate(now)
+	if m.Issuer != "" {
+		claims["iss"] = m.Issuer
+	}
+	if m.Audience != "" {
+		claims["aud"] = m.Audience
+	}
+	key := m.Keys.Signing()
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// hashToken refresh tokens are only stored as their sha256
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,172 @@
+package token
+
+import (
+	"context"
+	"gin-wallet2/models"
+	"gin-wallet2/store"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// newTestManager manager over an in-memory store holding one user, with a
+// clock the test can move
+func newTestManager(t *testing.T) (*Manager, *models.User, *time.Time) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	id, err := s.CreateUser(ctx, "alice", "hash")
+	require.NoError(t, err)
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+
+	now := time.Now()
+	m := NewManager(HMACKeys([]byte("test-secret")), 15*time.Minute, time.Hour, s, s)
+	m.now = func() time.Time { return now }
+	return m, user, &now
+}
+
+func TestManager_IssueAndVerify(t *testing.T) {
+	ctx := context.Background()
+	m, user, now := newTestManager(t)
+
+	pair, err := m.Issue(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, 900, pair.ExpiresIn)
+
+	claims, err := m.Verify(ctx, pair.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+	assert.Equal(t, models.RoleUser, claims.Role)
+	assert.NotEmpty(t, claims.ID)
+	assert.NotEmpty(t, claims.SessionID)
+
+	auditor := *user
+	auditor.Role = models.RoleAuditor
+	pair2, err := m.Issue(ctx, &auditor)
+	require.NoError(t, err)
+	claims, err = m.Verify(ctx, pair2.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleAuditor, claims.Role)
+
+	other := NewManager(HMACKeys([]byte("other-secret")), time.Minute, time.Hour, m.Tokens, m.Users)
+	_, err = other.Verify(ctx, pair.AccessToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"userID": 1, "jti": "x", "exp": now.Add(time.Hour).Unix()}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = m.Verify(ctx, none)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+	*now = now.Add(16 * time.Minute)
+	_, err = m.Verify(ctx, pair.AccessToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+}
+
+func TestManager_Refresh(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rotates within the session", func(t *testing.T) {
+		m, user, _ := newTestManager(t)
+		first, err := m.Issue(ctx, user)
+		require.NoError(t, err)
+
+		second, err := m.Refresh(ctx, first.RefreshToken)
+		require.NoError(t, err)
+		c1, err := m.Verify(ctx, first.AccessToken)
+		require.NoError(t, err)
+		c2, err := m.Verify(ctx, second.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, c1.SessionID, c2.SessionID)
+		assert.NotEqual(t, c1.ID, c2.ID)
+	})
+
+	t.Run("reuse revokes the family", func(t *testing.T) {
+		m, user, _ := newTestManager(t)
+		first, _ := m.Issue(ctx, user)
+		second, err := m.Refresh(ctx, first.RefreshToken)
+		require.NoError(t, err)
+
+		_, err = m.Refresh(ctx, first.RefreshToken)
+		assert.ErrorIs(t, err, models.ErrTokenReused)
+		_, err = m.Refresh(ctx, second.RefreshToken)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+		_, err = m.Verify(ctx, second.AccessToken)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+	})
+
+	t.Run("expired refresh token", func(t *testing.T) {
+		m, user, now := newTestManager(t)
+		pair, _ := m.Issue(ctx, user)
+
+		*now = now.Add(2 * time.Hour)
+		_, err := m.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+	})
+
+	t.Run("unknown refresh token", func(t *testing.T) {
+		m, _, _ := newTestManager(t)
+		_, err := m.Refresh(ctx, "not-a-token")
+		assert.ErrorIs(t, err, models.ErrInvalidToken)
+	})
+}
+
+func TestManager_Logout(t *testing.T) {
+	ctx := context.Background()
+	m, user, _ := newTestManager(t)
+
+	a, _ := m.Issue(ctx, user)
+	b, _ := m.Issue(ctx, user)
+	claims, err := m.Verify(ctx, a.AccessToken)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Logout(ctx, claims))
+	_, err = m.Verify(ctx, a.AccessToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+	_, err = m.Verify(ctx, b.AccessToken)
+	assert.NoError(t, err)
+
+	claims, err = m.Verify(ctx, b.AccessToken)
+	require.NoError(t, err)
+	require.NoError(t, m.LogoutAll(ctx, claims))
+	_, err = m.Verify(ctx, b.AccessToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+	_, err = m.Refresh(ctx, b.RefreshToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+}
+
+func TestManager_Challenge(t *testing.T) {
+	ctx := context.Background()
+	m, user, now := newTestManager(t)
+
+	challenge, err := m.IssueChallenge(user)
+	require.NoError(t, err)
+	claims, err := m.VerifyChallenge(ctx, challenge)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, claims.UserID)
+
+	// challenges and access tokens are not interchangeable
+	_, err = m.Verify(ctx, challenge)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+	pair, _ := m.Issue(ctx, user)
+	_, err = m.VerifyChallenge(ctx, pair.AccessToken)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+	// single use once revoked
+	require.NoError(t, m.Logout(ctx, claims))
+	_, err = m.VerifyChallenge(ctx, challenge)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+
+	challenge, _ = m.IssueChallenge(user)
+	*now = now.Add(m.ChallengeTTL + clockSkew + time.Second)
+	_, err = m.VerifyChallenge(ctx, challenge)
+	assert.ErrorIs(t, err, models.ErrInvalidToken)
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gin-wallet2/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	t.Run("disabled", func(t *testing.T) {
+		shutdown, err := Setup(ctx, config.Tracing{Exporter: "none"}, nil)
+		require.NoError(t, err)
+		assert.NoError(t, shutdown(ctx))
+		assert.Equal(t, prev, otel.GetTracerProvider())
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		var out bytes.Buffer
+		shutdown, err := Setup(ctx, config.Tracing{Exporter: "stdout", ServiceName: "wallet-test", SampleRatio: 1}, &out)
+		require.NoError(t, err)
+
+		_, span := otel.Tracer("test").Start(ctx, "POST /wallet/transfer")
+		span.End()
+		// spans are batched, shutdown flushes them
+		require.NoError(t, shutdown(ctx))
+
+		var exported struct{ Name string }
+		require.NoError(t, json.Unmarshal(out.Bytes(), &exported), out.String())
+		assert.Equal(t, "POST /wallet/transfer", exported.Name)
+		assert.Contains(t, out.String(), "wallet-test")
+	})
+
+	t.Run("sampled out", func(t *testing.T) {
+		var out bytes.Buffer
+		shutdown, err := Setup(ctx, config.Tracing{Exporter: "stdout", ServiceName: "wallet-test", SampleRatio: 0}, &out)
+		require.NoError(t, err)
+
+		_, span := otel.Tracer("test").Start(ctx, "GET /healthz")
+		span.End()
+		require.NoError(t, shutdown(ctx))
+		assert.Empty(t, out.String())
+	})
+
+	t.Run("unknown exporter", func(t *testing.T) {
+		_, err := Setup(ctx, config.Tracing{Exporter: "zipkin"}, nil)
+		assert.EqualError(t, err, `tracing: unknown exporter "zipkin"`)
+	})
+}